@@ -0,0 +1,33 @@
+package obs
+
+import (
+	"encoding/binary"
+	"unsafe"
+)
+
+// Arch describes the machine that has recorded tracepoint data. Decoding raw
+// data recorded on a different machine, eg. a s390x trace analysed on a x86
+// workstation, requires knowing how that machine lays out integers.
+type Arch struct {
+	// ByteOrder is the byte order of the integers.
+	ByteOrder binary.ByteOrder
+	// WordSize is the size of a long and of pointers, in bytes.
+	WordSize int
+}
+
+// HostArch describes the machine running this code.
+var HostArch = Arch{
+	ByteOrder: NativeEndian,
+	WordSize:  int(unsafe.Sizeof(uintptr(0))),
+}
+
+// NativeEndian is the byte order of the machine running this code.
+var NativeEndian = hostByteOrder()
+
+func hostByteOrder() binary.ByteOrder {
+	x := uint16(0x0102)
+	if *(*byte)(unsafe.Pointer(&x)) == 0x01 {
+		return binary.BigEndian
+	}
+	return binary.LittleEndian
+}
@@ -0,0 +1,62 @@
+package obs
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// execDataBE is execData, recorded on a big-endian machine.
+var execDataBE = []byte{
+	0x01, 0x0a, 0x00, 0x00, 0x00, 0x00, 0x01, 0xb3, 0x00, 0x0a, 0x00, 0x14, 0x00, 0x00, 0x01, 0xb3,
+	0x00, 0x00, 0x01, 0xb3, 0x2f, 0x62, 0x69, 0x6e, 0x2f, 0x62, 0x61, 0x73, 0x68, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00,
+}
+
+func TestHostArch(t *testing.T) {
+	var x uint32 = 0x01020304
+	b := make([]byte, 4)
+	NativeEndian.PutUint32(b, x)
+	assert.Equal(t, x, *(*uint32)(unsafe.Pointer(&b[0])))
+}
+
+func TestDecodeBigEndian(t *testing.T) {
+	var f format
+
+	assert.Nil(t, f.initFromReader(strings.NewReader(execFormat)))
+	f.arch = Arch{ByteOrder: binary.BigEndian, WordSize: 8}
+
+	pid, err := f.decodeInt(execDataBE, "pid")
+	assert.Nil(t, err)
+	assert.Equal(t, bashPID, pid)
+
+	filename, err := f.decodeString(execDataBE, "filename")
+	assert.Nil(t, err)
+	assert.Equal(t, bashCmdline, filename)
+}
+
+func TestTracepointFormatEvent(t *testing.T) {
+	tf, err := ParseTracepointFormat(strings.NewReader(execFormat))
+	assert.Nil(t, err)
+
+	tests := []struct {
+		arch Arch
+		data []byte
+	}{
+		{Arch{ByteOrder: binary.LittleEndian, WordSize: 8}, execData},
+		{Arch{ByteOrder: binary.BigEndian, WordSize: 8}, execDataBE},
+		{Arch{ByteOrder: binary.BigEndian, WordSize: 4}, execDataBE},
+	}
+
+	for _, test := range tests {
+		e := tf.Event(test.data, test.arch)
+		assert.Equal(t, test.arch, e.Arch())
+		assert.Equal(t, 266, e.CommonType())
+		assert.Equal(t, bashPID, e.CommonPID())
+		assert.Equal(t, bashPID, e.GetInt("old_pid"))
+		assert.Equal(t, bashCmdline, e.GetString("filename"))
+	}
+}
@@ -0,0 +1,82 @@
+package obs
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Cgroup is a cgroup a process belongs to, as listed in /proc/pid/cgroup.
+type Cgroup struct {
+	// HierarchyID is the ID of the cgroup v1 hierarchy, 0 for the cgroup v2
+	// unified hierarchy.
+	HierarchyID int
+	// Controllers are the controllers bound to the cgroup v1 hierarchy, eg.
+	// "cpu" and "cpuacct". Named hierarchies appear as "name=systemd".
+	// Controllers is empty for the cgroup v2 unified hierarchy.
+	Controllers []string
+	// Path is the path of the cgroup, relative to the root of the hierarchy.
+	Path string
+}
+
+// IsUnified returns true if the cgroup is in the cgroup v2 unified hierarchy.
+func (c *Cgroup) IsUnified() bool {
+	return c.HierarchyID == 0 && len(c.Controllers) == 0
+}
+
+// parseCgroups parses the content of a /proc/pid/cgroup file.
+//
+//	12:cpu,cpuacct:/system.slice/docker.service
+//	1:name=systemd:/system.slice/docker.service
+//	0::/system.slice/docker.service
+func parseCgroups(r io.Reader) ([]Cgroup, error) {
+	var cgroups []Cgroup
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		fields := strings.SplitN(line, ":", 3)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("invalid cgroup line '%s'", line)
+		}
+		id, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid hierarchy ID '%s'", fields[0])
+		}
+		cgroup := Cgroup{
+			HierarchyID: id,
+			Path:        fields[2],
+		}
+		if fields[1] != "" {
+			cgroup.Controllers = strings.Split(fields[1], ",")
+		}
+		cgroups = append(cgroups, cgroup)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return cgroups, nil
+}
+
+// Cgroups returns the cgroups the process belongs to, one per cgroup
+// hierarchy.
+func (p *Process) Cgroups() ([]Cgroup, error) {
+	data, err := p.readFile("cgroup")
+	if err != nil {
+		return nil, err
+	}
+	cgroups, err := parseCgroups(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.Wrap(err, "cgroup")
+	}
+	return cgroups, nil
+}
@@ -0,0 +1,115 @@
+package obs
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCgroups(t *testing.T) {
+	cgroups, err := parseCgroups(strings.NewReader(`12:cpu,cpuacct:/docker/1234
+1:name=systemd:/system.slice/docker.service
+0::/system.slice/docker.service
+`))
+	assert.NoError(t, err)
+	assert.Equal(t, []Cgroup{
+		{HierarchyID: 12, Controllers: []string{"cpu", "cpuacct"}, Path: "/docker/1234"},
+		{HierarchyID: 1, Controllers: []string{"name=systemd"}, Path: "/system.slice/docker.service"},
+		{HierarchyID: 0, Path: "/system.slice/docker.service"},
+	}, cgroups)
+	assert.False(t, cgroups[0].IsUnified())
+	assert.True(t, cgroups[2].IsUnified())
+
+	for _, invalid := range []string{"foo\n", "a:cpu:/\n"} {
+		_, err := parseCgroups(strings.NewReader(invalid))
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestProcessCgroups(t *testing.T) {
+	cgroups, err := NewProcess(os.Getpid()).Cgroups()
+	assert.NoError(t, err)
+	assert.NotEmpty(t, cgroups)
+	for _, cgroup := range cgroups {
+		assert.True(t, strings.HasPrefix(cgroup.Path, "/"), cgroup.Path)
+	}
+
+	_, err = NewProcess(5000000).Cgroups()
+	assert.True(t, IsProcessGone(err))
+}
+
+// cgroup2Roots are where the cgroup v2 hierarchy is mounted, on its own or
+// alongside the cgroup v1 hierarchies.
+var cgroup2Roots = []string{"/sys/fs/cgroup/unified", "/sys/fs/cgroup"}
+
+// createCgroup creates a cgroup v2, skipping the test if it can't.
+func createCgroup(t *testing.T) string {
+	for _, root := range cgroup2Roots {
+		if _, err := os.Stat(root + "/cgroup.procs"); err != nil {
+			continue
+		}
+		dir := fmt.Sprintf("%s/obs-test-%d", root, os.Getpid())
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Skipf("unable to create a cgroup: %v", err)
+		}
+		return dir
+	}
+	t.Skip("no cgroup v2 hierarchy")
+	return ""
+}
+
+func TestWithCgroup(t *testing.T) {
+	cgroup := createCgroup(t)
+	defer os.Remove(cgroup)
+	child := cgroup + "/child"
+	assert.NoError(t, os.Mkdir(child, 0755))
+	defer os.Remove(child)
+
+	o := NewObserver()
+	o.AddTracepoint("sched:sched_process_exec", WithCgroup(cgroup))
+	openObserver(t, o)
+
+	execs := make(chan string, 16)
+	go func() {
+		defer close(execs)
+		for {
+			event, err := o.ReadEvent()
+			if err != nil || event == nil {
+				return
+			}
+			execs <- event.(*TracepointEvent).GetString("filename")
+		}
+	}()
+
+	run := func(script string) {
+		assert.NoError(t, exec.Command("/bin/sh", "-c", script).Run())
+	}
+	// Outside of the cgroup.
+	run("exec /bin/echo")
+	// In the cgroup and in a descendant.
+	run("echo $$ > " + cgroup + "/cgroup.procs && exec /bin/true")
+	run("echo $$ > " + child + "/cgroup.procs && exec /bin/cat /dev/null")
+
+	var filenames []string
+	timeout := time.After(5 * time.Second)
+	for len(filenames) < 2 {
+		select {
+		case filename := <-execs:
+			filenames = append(filenames, filename)
+		case <-timeout:
+			t.Fatalf("execs not received: %v", filenames)
+		}
+	}
+	o.Close()
+	for filename := range execs {
+		filenames = append(filenames, filename)
+	}
+	sort.Strings(filenames)
+	assert.Equal(t, []string{"/bin/cat", "/bin/true"}, filenames)
+}
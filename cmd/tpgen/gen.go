@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"strings"
+	"text/template"
+
+	"github.com/dlespiau/obs"
+)
+
+// tracepoint is a tracepoint to generate code for.
+type tracepoint struct {
+	// FullName is the tracepoint name, including the subsystem, eg.
+	// sched:sched_process_exec.
+	FullName string
+	// TypeName is the Go name of the generated struct.
+	TypeName string
+	// Size is the minimum size of the raw data.
+	Size   int
+	Format *obs.TracepointFormat
+	Fields []goField
+}
+
+// goField is a Go struct field generated from a tracepoint field.
+type goField struct {
+	Name   string
+	Type   string
+	Layout obs.FieldLayout
+	// Decode is the Go code decoding the field from data.
+	Decode string
+}
+
+// initialisms are the words we want to see capitalized in Go identifiers.
+var initialisms = map[string]string{
+	"cpu":  "CPU",
+	"id":   "ID",
+	"io":   "IO",
+	"ip":   "IP",
+	"irq":  "IRQ",
+	"pid":  "PID",
+	"tid":  "TID",
+	"uid":  "UID",
+	"gid":  "GID",
+	"nr":   "NR",
+	"addr": "Addr",
+}
+
+// goName turns a C identifier, eg. "old_pid", into an exported Go
+// identifier, eg. "OldPID".
+func goName(name string) string {
+	var b strings.Builder
+
+	for _, word := range strings.Split(name, "_") {
+		if word == "" {
+			continue
+		}
+		if s, ok := initialisms[word]; ok {
+			b.WriteString(s)
+			continue
+		}
+		b.WriteString(strings.ToUpper(word[:1]))
+		b.WriteString(word[1:])
+	}
+
+	return b.String()
+}
+
+// isChar returns true if t is a C type used for strings.
+func isChar(t string) bool {
+	return t == "char" || t == "const char" || t == "unsigned char"
+}
+
+func newGoField(tpName string, l obs.FieldLayout) goField {
+	f := goField{
+		Name:   goName(l.Name),
+		Layout: l,
+	}
+	data := fmt.Sprintf("data[%d:%d]", l.Offset, l.Offset+l.Size)
+
+	switch {
+	case l.Dynamic:
+		conv := "tpgenBytes"
+		f.Type = "[]byte"
+		if isChar(l.Type) {
+			f.Type, conv = "string", "tpgenCString"
+		}
+		// __rel_loc offsets are relative to the end of the field.
+		base := 0
+		if l.Relative {
+			base = l.Offset + l.Size
+		}
+		f.Decode = fmt.Sprintf(`{
+		v, err := tpgenDataLoc(data, %d, %d, order)
+		if err != nil {
+			return fmt.Errorf("%s: %s: %%v", err)
+		}
+		e.%s = %s(v)
+	}`, l.Offset, base, tpName, l.Name, f.Name, conv)
+	case l.Array && isChar(l.Type):
+		f.Type = "string"
+		f.Decode = fmt.Sprintf("e.%s = tpgenCString(%s)", f.Name, data)
+	case l.Size == 1:
+		f.Type = "uint8"
+		f.Decode = fmt.Sprintf("e.%s = data[%d]", f.Name, l.Offset)
+		if l.Signed {
+			f.Type = "int8"
+			f.Decode = fmt.Sprintf("e.%s = int8(data[%d])", f.Name, l.Offset)
+		}
+	case !l.Array && (l.Size == 2 || l.Size == 4 || l.Size == 8):
+		bits := l.Size * 8
+		f.Type = fmt.Sprintf("uint%d", bits)
+		f.Decode = fmt.Sprintf("e.%s = order.Uint%d(%s)", f.Name, bits, data)
+		if l.Signed {
+			f.Type = fmt.Sprintf("int%d", bits)
+			f.Decode = fmt.Sprintf("e.%s = %s(order.Uint%d(%s))", f.Name, f.Type, bits, data)
+		}
+	default:
+		f.Type = fmt.Sprintf("[%d]byte", l.Size)
+		f.Decode = fmt.Sprintf("copy(e.%s[:], %s)", f.Name, data)
+	}
+
+	return f
+}
+
+func newTracepoint(name string, f *obs.TracepointFormat) *tracepoint {
+	tp := &tracepoint{
+		FullName: name,
+		TypeName: goName(f.Name),
+		Format:   f,
+	}
+
+	for _, l := range f.Fields {
+		tp.Fields = append(tp.Fields, newGoField(name, l))
+		if end := l.Offset + l.Size; end > tp.Size {
+			tp.Size = end
+		}
+	}
+
+	return tp
+}
+
+var fileTemplate = template.Must(template.New("file").Parse(`// Code generated by tpgen. DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/dlespiau/obs"
+)
+{{range .Tracepoints}}
+// {{.TypeName}}Name is the name of the {{.FullName}} tracepoint.
+const {{.TypeName}}Name = "{{.FullName}}"
+
+// {{.TypeName}}Layout is the layout {{.TypeName}} has been generated from. Give
+// it to obs.WithLayout to ensure the running kernel agrees with it:
+//
+//   observer.AddTracepoint({{.TypeName}}Name, obs.WithLayout({{.TypeName}}Layout))
+var {{.TypeName}}Layout = []obs.FieldLayout{
+{{- range .Fields}}
+	{Name: "{{.Layout.Name}}", Type: "{{.Layout.Type}}", Offset: {{.Layout.Offset}}, Size: {{.Layout.Size}}, Signed: {{.Layout.Signed}}, Array: {{.Layout.Array}}, Dynamic: {{.Layout.Dynamic}}, Relative: {{.Layout.Relative}}},
+{{- end}}
+}
+
+// {{.TypeName}} is the data of the {{.FullName}} tracepoint.
+type {{.TypeName}} struct {
+{{- range .Fields}}
+	{{.Name}} {{.Type}}
+{{- end}}
+}
+
+// Decode fills e from the tracepoint raw data, as returned by
+// obs.TracepointEvent.Data().
+func (e *{{.TypeName}}) Decode(data []byte) error {
+	return e.DecodeWithOrder(data, obs.NativeEndian)
+}
+
+// DecodeWithOrder fills e from raw data recorded on a machine with the
+// specified byte order.
+func (e *{{.TypeName}}) DecodeWithOrder(data []byte, order binary.ByteOrder) error {
+	if len(data) < {{.Size}} {
+		return fmt.Errorf("{{.FullName}}: short data (%d bytes)", len(data))
+	}
+{{range .Fields}}
+	{{.Decode}}
+{{- end}}
+
+	return nil
+}
+{{end}}
+var tpgenErrDataLoc = errors.New("dynamic field is beyond data end")
+
+// tpgenDataLoc returns the data pointed at by the dynamic field at offset.
+// base is added to the offset of the data.
+func tpgenDataLoc(data []byte, offset, base int, order binary.ByteOrder) ([]byte, error) {
+	loc := order.Uint32(data[offset : offset+4])
+	start := base + int(loc&0xffff)
+	end := start + int(loc>>16)
+	if end > len(data) {
+		return nil, tpgenErrDataLoc
+	}
+	return data[start:end], nil
+}
+
+// tpgenCString converts a nul-terminated C string to a Go string.
+func tpgenCString(b []byte) string {
+	for i, c := range b {
+		if c == 0 {
+			return string(b[:i])
+		}
+	}
+	return string(b)
+}
+
+// tpgenBytes copies b.
+func tpgenBytes(b []byte) []byte {
+	return append([]byte(nil), b...)
+}
+`))
+
+// generate writes the Go code for the tracepoints.
+func generate(pkg string, tracepoints []*tracepoint) ([]byte, error) {
+	var buf bytes.Buffer
+
+	err := fileTemplate.Execute(&buf, struct {
+		Package     string
+		Tracepoints []*tracepoint
+	}{
+		Package:     pkg,
+		Tracepoints: tracepoints,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return format.Source(buf.Bytes())
+}
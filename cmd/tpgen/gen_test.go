@@ -0,0 +1,51 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+func TestGoName(t *testing.T) {
+	tests := []struct {
+		input, expected string
+	}{
+		{"pid", "PID"},
+		{"old_pid", "OldPID"},
+		{"common_preempt_count", "CommonPreemptCount"},
+		{"__probe_ip", "ProbeIP"},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, goName(test.input))
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	var tracepoints []*tracepoint
+
+	for _, name := range []string{
+		"sched:sched_process_exec",
+		"sched:sched_process_fork",
+		"sched:sched_switch",
+	} {
+		f, err := readFormat("../../testdata/events", name)
+		assert.Nil(t, err)
+		tracepoints = append(tracepoints, newTracepoint(name, f))
+	}
+
+	code, err := generate("sched", tracepoints)
+	assert.Nil(t, err)
+
+	const golden = "testdata/sched.go.golden"
+	if *update {
+		assert.Nil(t, ioutil.WriteFile(golden, code, 0644))
+	}
+	expected, err := ioutil.ReadFile(golden)
+	assert.Nil(t, err)
+	assert.Equal(t, string(expected), string(code))
+}
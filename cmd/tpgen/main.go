@@ -0,0 +1,93 @@
+// Command tpgen generates typed Go structs and decoders for tracepoints.
+//
+// tpgen reads tracepoint format files, either from the running kernel or from
+// a directory laid out as tracefs' events/ directory, and writes Go code with
+// one struct per tracepoint. For instance:
+//
+//   //go:generate go run github.com/dlespiau/obs/cmd/tpgen -o tracepoints.go sched:sched_process_exec
+//
+// generates a SchedProcessExec struct that can be filled from the raw data of
+// a TracepointEvent:
+//
+//   var exec SchedProcessExec
+//   err := exec.Decode(event.Data())
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/dlespiau/obs"
+)
+
+func die(err error) {
+	fmt.Fprintln(os.Stderr, "tpgen:", err)
+	os.Exit(1)
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: tpgen [flags] subsystem:event...\n")
+	flag.PrintDefaults()
+}
+
+// readFormat reads the format of the tracepoint name. When root is empty, the
+// format is read from the running kernel.
+func readFormat(root, name string) (*obs.TracepointFormat, error) {
+	if root == "" {
+		return obs.ReadTracepointFormat(name)
+	}
+
+	parts := strings.SplitN(name, ":", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid tracepoint name: %s", name)
+	}
+	fp, err := os.Open(filepath.Join(root, parts[0], parts[1], "format"))
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+
+	return obs.ParseTracepointFormat(fp)
+}
+
+func main() {
+	output := flag.String("o", "", "output file (default: stdout)")
+	pkg := flag.String("package", os.Getenv("GOPACKAGE"), "package name of the generated code")
+	root := flag.String("root", "", "events directory to read formats from (default: the running kernel)")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+	if *pkg == "" {
+		*pkg = "main"
+	}
+
+	var tracepoints []*tracepoint
+	for _, name := range flag.Args() {
+		f, err := readFormat(*root, name)
+		if err != nil {
+			die(fmt.Errorf("%s: %v", name, err))
+		}
+		tracepoints = append(tracepoints, newTracepoint(name, f))
+	}
+
+	code, err := generate(*pkg, tracepoints)
+	if err != nil {
+		die(err)
+	}
+
+	if *output == "" {
+		os.Stdout.Write(code)
+		return
+	}
+	if err := ioutil.WriteFile(*output, code, 0644); err != nil {
+		die(err)
+	}
+}
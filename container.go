@@ -0,0 +1,237 @@
+package obs
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// ContainerRuntime is the container runtime managing a container.
+type ContainerRuntime string
+
+// Container runtimes recognized from the cgroup paths.
+const (
+	// UnknownRuntime is used for containers found in cgroups not named
+	// after their runtime, eg. with the cgroupfs driver of Kubernetes.
+	UnknownRuntime ContainerRuntime = ""
+	Docker         ContainerRuntime = "docker"
+	Containerd     ContainerRuntime = "containerd"
+	CRIO           ContainerRuntime = "cri-o"
+	Podman         ContainerRuntime = "podman"
+)
+
+// containerIDLen is the length of the container IDs, 32 bytes in
+// hexadecimal.
+const containerIDLen = 64
+
+// Container identifies the container a process runs in.
+type Container struct {
+	// Runtime is the container runtime running the container.
+	Runtime ContainerRuntime
+	// ID is the full container ID.
+	ID string
+	// PodUID is the UID of the Kubernetes pod the container is part of, ""
+	// for containers not managed by Kubernetes.
+	PodUID string
+}
+
+// containerPrefixes are the prefixes of the systemd scopes of containers.
+var containerPrefixes = []struct {
+	prefix  string
+	runtime ContainerRuntime
+}{
+	{"docker-", Docker},
+	{"cri-containerd-", Containerd},
+	{"crio-", CRIO},
+	{"libpod-", Podman},
+}
+
+// runtimeNames are the runtime names used by the systemd cgroup driver of
+// Kubernetes, eg. "kubepods-pod<uid>.slice:cri-containerd:<id>".
+var runtimeNames = map[string]ContainerRuntime{
+	"docker":         Docker,
+	"cri-containerd": Containerd,
+	"crio":           CRIO,
+}
+
+func isHex(s string) bool {
+	for _, c := range s {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func isContainerID(s string) bool {
+	return len(s) == containerIDLen && isHex(s)
+}
+
+// parseContainerID extracts a container ID from the cgroup path component c.
+// parent is the path component before c.
+func parseContainerID(parent, c string) (ContainerRuntime, string, bool) {
+	c = strings.TrimSuffix(c, ".scope")
+
+	// Kubernetes with the systemd cgroup driver.
+	if fields := strings.Split(c, ":"); len(fields) == 3 && isContainerID(fields[2]) {
+		return runtimeNames[fields[1]], fields[2], true
+	}
+
+	// systemd scopes.
+	for _, p := range containerPrefixes {
+		if strings.HasPrefix(c, p.prefix) && isContainerID(c[len(p.prefix):]) {
+			return p.runtime, c[len(p.prefix):], true
+		}
+	}
+
+	// Docker with the cgroupfs driver, /docker/<id>, or Kubernetes with the
+	// cgroupfs driver, /kubepods/<qos>/pod<uid>/<id>.
+	if isContainerID(c) {
+		if parent == "docker" {
+			return Docker, c, true
+		}
+		return UnknownRuntime, c, true
+	}
+
+	return UnknownRuntime, "", false
+}
+
+// podUIDLen is the length of the Kubernetes pod UIDs.
+const podUIDLen = 36
+
+// parsePodUID extracts a Kubernetes pod UID from the cgroup path component
+// c:
+//
+//	pod3d7a6b3e-2c0c-4b4e-9f0a-7c6a2f0f1e2d
+//	kubepods-besteffort-pod3d7a6b3e_2c0c_4b4e_9f0a_7c6a2f0f1e2d.slice
+//	kubepods-pod3d7a6b3e_2c0c_4b4e_9f0a_7c6a2f0f1e2d.slice:cri-containerd:<id>
+func parsePodUID(c string) (string, bool) {
+	if colon := strings.IndexByte(c, ':'); colon != -1 {
+		c = c[:colon]
+	}
+	if strings.HasSuffix(c, ".slice") {
+		c = strings.TrimSuffix(c, ".slice")
+		c = c[strings.LastIndexByte(c, '-')+1:]
+		c = strings.Replace(c, "_", "-", -1)
+	}
+	if !strings.HasPrefix(c, "pod") {
+		return "", false
+	}
+	uid := c[len("pod"):]
+	if len(uid) != podUIDLen || !isHex(strings.Replace(uid, "-", "", -1)) {
+		return "", false
+	}
+	return uid, true
+}
+
+// ParseContainer identifies the container from the path of a cgroup. It
+// recognizes the cgroup naming conventions of Docker, containerd, CRI-O and
+// Podman, with the cgroupfs and systemd cgroup drivers, and the pod cgroups of
+// Kubernetes. ParseContainer returns false if the cgroup isn't the cgroup of
+// a container.
+func ParseContainer(path string) (Container, bool) {
+	var container Container
+
+	components := strings.Split(path, "/")
+	kubernetes := strings.Contains(path, "kubepods")
+	for i, c := range components {
+		if kubernetes && container.PodUID == "" {
+			container.PodUID, _ = parsePodUID(c)
+		}
+		parent := ""
+		if i > 0 {
+			parent = components[i-1]
+		}
+		if runtime, id, ok := parseContainerID(parent, c); ok {
+			container.Runtime = runtime
+			container.ID = id
+		}
+	}
+
+	return container, container.ID != "" || container.PodUID != ""
+}
+
+// containerFromCgroups returns the container found in cgroups, preferring
+// the unified hierarchy.
+func containerFromCgroups(cgroups []Cgroup) (Container, bool) {
+	for _, cgroup := range cgroups {
+		if cgroup.IsUnified() {
+			if container, ok := ParseContainer(cgroup.Path); ok {
+				return container, true
+			}
+		}
+	}
+	for _, cgroup := range cgroups {
+		if container, ok := ParseContainer(cgroup.Path); ok {
+			return container, true
+		}
+	}
+	return Container{}, false
+}
+
+// Container returns the container the process runs in. Container returns
+// false if the process doesn't run in a container.
+func (p *Process) Container() (Container, bool, error) {
+	cgroups, err := p.Cgroups()
+	if err != nil {
+		return Container{}, false, errors.Wrap(err, "container")
+	}
+	container, ok := containerFromCgroups(cgroups)
+	return container, ok, nil
+}
+
+// ContainerResolver finds the containers processes run in, to annotate events
+// with the container of the process they come from. Containers are cached by
+// PID, Forget should be called when processes exit, eg. on the
+// sched:sched_process_exit tracepoint.
+type ContainerResolver struct {
+	mu    sync.Mutex
+	cache map[int]*Container
+}
+
+// NewContainerResolver creates a ContainerResolver.
+func NewContainerResolver() *ContainerResolver {
+	return &ContainerResolver{
+		cache: make(map[int]*Container),
+	}
+}
+
+// Resolve returns the container the process or thread pid runs in. Resolve
+// returns false if pid doesn't run in a container or has exited.
+func (r *ContainerResolver) Resolve(pid int) (Container, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	container, ok := r.cache[pid]
+	if !ok {
+		if c, found, err := NewProcess(pid).Container(); err != nil {
+			// Don't cache anything for processes that have exited.
+			return Container{}, false
+		} else if found {
+			container = &c
+		}
+		r.cache[pid] = container
+	}
+	if container == nil {
+		return Container{}, false
+	}
+	return *container, true
+}
+
+// ResolveEvent returns the container the task that has emitted e runs in.
+func (r *ContainerResolver) ResolveEvent(e *TracepointEvent) (Container, bool) {
+	pid := e.CommonPID()
+	if pid <= 0 {
+		return Container{}, false
+	}
+	return r.Resolve(pid)
+}
+
+// Forget removes pid from the cache.
+func (r *ContainerResolver) Forget(pid int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.cache, pid)
+}
@@ -0,0 +1,92 @@
+package obs
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testContainerID = "3f4d2c1b0a9e8d7c6b5a4f3e2d1c0b9a8f7e6d5c4b3a29181716151413121110"
+	testPodUID      = "3d7a6b3e-2c0c-4b4e-9f0a-7c6a2f0f1e2d"
+	testPodUIDUnder = "3d7a6b3e_2c0c_4b4e_9f0a_7c6a2f0f1e2d"
+)
+
+func TestParseContainer(t *testing.T) {
+	tests := []struct {
+		path     string
+		found    bool
+		expected Container
+	}{
+		{"/", false, Container{}},
+		{"/user.slice/user-1000.slice/session-2.scope", false, Container{}},
+		{"/system.slice/docker.service", false, Container{}},
+		// Docker.
+		{"/docker/" + testContainerID, true, Container{Runtime: Docker, ID: testContainerID}},
+		{"/system.slice/docker-" + testContainerID + ".scope", true, Container{Runtime: Docker, ID: testContainerID}},
+		// containerd.
+		{"/system.slice/cri-containerd-" + testContainerID + ".scope", true, Container{Runtime: Containerd, ID: testContainerID}},
+		// CRI-O, conmon isn't part of the container.
+		{"/machine.slice/crio-" + testContainerID + ".scope", true, Container{Runtime: CRIO, ID: testContainerID}},
+		{"/machine.slice/crio-conmon-" + testContainerID + ".scope", false, Container{}},
+		// Podman.
+		{"/machine.slice/libpod-" + testContainerID + ".scope", true, Container{Runtime: Podman, ID: testContainerID}},
+		{"/user.slice/user-1000.slice/user@1000.service/user.slice/libpod-" + testContainerID + ".scope/container", true, Container{Runtime: Podman, ID: testContainerID}},
+		// Kubernetes, cgroupfs driver.
+		{"/kubepods/besteffort/pod" + testPodUID + "/" + testContainerID, true, Container{ID: testContainerID, PodUID: testPodUID}},
+		{"/kubepods/pod" + testPodUID + "/" + testContainerID, true, Container{ID: testContainerID, PodUID: testPodUID}},
+		// Kubernetes, systemd driver.
+		{"/kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod" + testPodUIDUnder + ".slice/cri-containerd-" + testContainerID + ".scope",
+			true, Container{Runtime: Containerd, ID: testContainerID, PodUID: testPodUID}},
+		{"/kubepods.slice/kubepods-besteffort.slice/kubepods-besteffort-pod" + testPodUIDUnder + ".slice/crio-" + testContainerID + ".scope",
+			true, Container{Runtime: CRIO, ID: testContainerID, PodUID: testPodUID}},
+		{"/kubepods.slice/kubepods-pod" + testPodUIDUnder + ".slice/docker-" + testContainerID + ".scope",
+			true, Container{Runtime: Docker, ID: testContainerID, PodUID: testPodUID}},
+		{"/system.slice/containerd.service/kubepods-besteffort-pod" + testPodUIDUnder + ".slice:cri-containerd:" + testContainerID,
+			true, Container{Runtime: Containerd, ID: testContainerID, PodUID: testPodUID}},
+		// The pod cgroup itself.
+		{"/kubepods.slice/kubepods-besteffort.slice/kubepods-besteffort-pod" + testPodUIDUnder + ".slice", true, Container{PodUID: testPodUID}},
+		// Not quite container IDs.
+		{"/docker/" + testContainerID[1:], false, Container{}},
+		{"/docker/" + testContainerID[1:] + "g", false, Container{}},
+	}
+
+	for _, test := range tests {
+		container, found := ParseContainer(test.path)
+		assert.Equal(t, test.found, found, test.path)
+		assert.Equal(t, test.expected, container, test.path)
+	}
+}
+
+func TestContainerFromCgroups(t *testing.T) {
+	container, found := containerFromCgroups([]Cgroup{
+		{HierarchyID: 1, Controllers: []string{"name=systemd"}, Path: "/docker/" + testContainerID},
+		{HierarchyID: 0, Path: "/system.slice/cri-containerd-" + testContainerID + ".scope"},
+	})
+	assert.True(t, found)
+	assert.Equal(t, Containerd, container.Runtime)
+
+	_, found = containerFromCgroups([]Cgroup{{HierarchyID: 0, Path: "/"}})
+	assert.False(t, found)
+}
+
+func TestContainerResolver(t *testing.T) {
+	r := NewContainerResolver()
+
+	// Whether the tests run in a container or not, the result is
+	// consistent with the one of Process.
+	expected, found, err := NewProcess(os.Getpid()).Container()
+	assert.NoError(t, err)
+	container, ok := r.Resolve(os.Getpid())
+	assert.Equal(t, found, ok)
+	assert.Equal(t, expected, container)
+	assert.Contains(t, r.cache, os.Getpid())
+	r.Forget(os.Getpid())
+	assert.NotContains(t, r.cache, os.Getpid())
+
+	// Exited processes aren't cached.
+	_, ok = r.Resolve(5000000)
+	assert.False(t, ok)
+	assert.NotContains(t, r.cache, 5000000)
+}
@@ -0,0 +1,431 @@
+package obs
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+	"time"
+)
+
+// HardwareEventKind is a generalized hardware event, mapped by the kernel to
+// the corresponding event of the CPU PMU.
+type HardwareEventKind int
+
+// These constants are linux ABI, defined as PERF_COUNT_HW_* in
+// <linux/perf_event.h>.
+const (
+	// CPUCycles is the number of CPU cycles.
+	CPUCycles HardwareEventKind = iota
+	// Instructions is the number of retired instructions.
+	Instructions
+	// CacheReferences is the number of cache accesses, usually to the last
+	// level cache.
+	CacheReferences
+	// CacheMisses is the number of cache misses, usually of the last level
+	// cache.
+	CacheMisses
+	// BranchInstructions is the number of retired branch instructions.
+	BranchInstructions
+	// BranchMisses is the number of mispredicted branch instructions.
+	BranchMisses
+	// BusCycles is the number of bus cycles.
+	BusCycles
+	// StalledCyclesFrontend is the number of stalled cycles during issue.
+	StalledCyclesFrontend
+	// StalledCyclesBackend is the number of stalled cycles during retirement.
+	StalledCyclesBackend
+	// RefCPUCycles is the number of CPU cycles, not affected by CPU frequency
+	// scaling.
+	RefCPUCycles
+)
+
+var hardwareEventNames = []string{
+	"cycles",
+	"instructions",
+	"cache-references",
+	"cache-misses",
+	"branches",
+	"branch-misses",
+	"bus-cycles",
+	"stalled-cycles-frontend",
+	"stalled-cycles-backend",
+	"ref-cycles",
+}
+
+// String returns the name of the event, as listed by perf list.
+func (k HardwareEventKind) String() string {
+	if k < 0 || int(k) >= len(hardwareEventNames) {
+		return "unknown"
+	}
+	return hardwareEventNames[k]
+}
+
+// HWCache is a CPU cache, as seen by the hardware cache events.
+type HWCache int
+
+// These constants are linux ABI, defined as PERF_COUNT_HW_CACHE_* in
+// <linux/perf_event.h>.
+const (
+	CacheL1D HWCache = iota
+	CacheL1I
+	CacheLL
+	CacheDTLB
+	CacheITLB
+	CacheBPU
+	CacheNode
+)
+
+var hwCacheNames = []string{"L1-dcache", "L1-icache", "LLC", "dTLB", "iTLB", "branch", "node"}
+
+// HWCacheOp is the cache operation a hardware cache event counts.
+type HWCacheOp int
+
+// These constants are linux ABI, defined as PERF_COUNT_HW_CACHE_OP_* in
+// <linux/perf_event.h>.
+const (
+	CacheOpRead HWCacheOp = iota
+	CacheOpWrite
+	CacheOpPrefetch
+)
+
+var hwCacheOpNames = []string{"load", "store", "prefetch"}
+
+// HWCacheResult is whether a hardware cache event counts accesses or misses.
+type HWCacheResult int
+
+// These constants are linux ABI, defined as PERF_COUNT_HW_CACHE_RESULT_* in
+// <linux/perf_event.h>.
+const (
+	CacheResultAccess HWCacheResult = iota
+	CacheResultMiss
+)
+
+// CounterEvent is an event a Counter can count.
+type CounterEvent struct {
+	name string
+	// attr holds the event type, config and modifiers of the event.
+	attr perfEventConfig
+}
+
+// String returns the name of the event.
+func (e CounterEvent) String() string {
+	return e.name
+}
+
+// HardwareCounter returns the generalized hardware event kind.
+func HardwareCounter(kind HardwareEventKind) CounterEvent {
+	return CounterEvent{
+		name: kind.String(),
+		attr: perfEventConfig{
+			eventType: perfTypeHardware,
+			config:    uint64(kind),
+		},
+	}
+}
+
+// HWCacheCounter returns the hardware cache event counting op accesses, or
+// misses, of cache.
+func HWCacheCounter(cache HWCache, op HWCacheOp, result HWCacheResult) CounterEvent {
+	name := "unknown"
+	if cache >= 0 && int(cache) < len(hwCacheNames) && op >= 0 && int(op) < len(hwCacheOpNames) {
+		name = hwCacheNames[cache] + "-" + hwCacheOpNames[op] + "s"
+		if result == CacheResultMiss {
+			name = hwCacheNames[cache] + "-" + hwCacheOpNames[op] + "-misses"
+		}
+	}
+
+	return CounterEvent{
+		name: name,
+		attr: perfEventConfig{
+			eventType: perfTypeHWCache,
+			config:    uint64(cache) | uint64(op)<<8 | uint64(result)<<16,
+		},
+	}
+}
+
+// RawCounter returns the CPU specific event config, as described in the CPU
+// manuals.
+func RawCounter(config uint64) CounterEvent {
+	return CounterEvent{
+		name: fmt.Sprintf("r%x", config),
+		attr: perfEventConfig{
+			eventType: perfTypeRaw,
+			config:    config,
+		},
+	}
+}
+
+// SoftwareCounter returns the software event kind. Software events are
+// always available, even on machines without a PMU.
+func SoftwareCounter(kind SoftwareEventKind) CounterEvent {
+	return CounterEvent{
+		name: kind.String(),
+		attr: perfEventConfig{
+			eventType: perfTypeSoftware,
+			config:    uint64(kind),
+		},
+	}
+}
+
+// CounterScope is what a Counter measures: a process, a CPU or the whole
+// system.
+type CounterScope struct {
+	pid int
+	cpu int
+}
+
+// SystemWide counts the events of all processes on all CPUs.
+func SystemWide() CounterScope {
+	return CounterScope{pid: -1, cpu: -1}
+}
+
+// OnCPU counts the events of all processes on cpu.
+func OnCPU(cpu int) CounterScope {
+	return CounterScope{pid: -1, cpu: cpu}
+}
+
+// ForProcess counts the events of the thread pid on any CPU. Use 0 to count
+// the events of the calling thread.
+func ForProcess(pid int) CounterScope {
+	return CounterScope{pid: pid, cpu: -1}
+}
+
+// CounterValue is the value of a counter.
+type CounterValue struct {
+	// Event is the event that was counted.
+	Event CounterEvent
+	// Value is the number of events, scaled to compensate for multiplexing.
+	Value uint64
+	// Raw is the number of events counted while the event was scheduled on
+	// the PMU.
+	Raw uint64
+	// Enabled is for how long the counter has been enabled, summed over the
+	// CPUs it counts on. For SystemWide counters, it's the time the counter
+	// has been enabled times the number of CPUs.
+	Enabled time.Duration
+	// Running is for how long the event has been scheduled on the PMU,
+	// summed over the CPUs it counts on as Enabled. When there are more
+	// events than hardware counters, the kernel multiplexes them and
+	// Running is less than Enabled.
+	Running time.Duration
+}
+
+// Multiplexed returns true if the event wasn't counted for the whole time the
+// counter was enabled and Value is an estimation.
+func (v *CounterValue) Multiplexed() bool {
+	return v.Running < v.Enabled
+}
+
+// Counter counts events, à la perf stat. The events of a counter form a
+// group: they are scheduled on the PMU together, so they can be compared with
+// each other, even when multiplexed.
+type Counter struct {
+	events []CounterEvent
+	// groups has one group per CPU, each group is a list of perf events, the
+	// first one being the group leader.
+	groups [][]*perfEvent
+	buf    []byte
+}
+
+// NewCounter opens a Counter counting events in scope. The counter is created
+// stopped, call Start to start counting.
+func NewCounter(scope CounterScope, events ...CounterEvent) (*Counter, error) {
+	var err error
+
+	if len(events) == 0 {
+		return nil, errors.New("counter: no event to count")
+	}
+
+	c := &Counter{
+		events: events,
+		// nr, time_enabled, time_running and one value per event.
+		buf: make([]byte, 8*(3+len(events))),
+	}
+
+	defer func() {
+		if err != nil {
+			c.Close()
+		}
+	}()
+
+	cpus := []int{scope.cpu}
+	if scope.pid == -1 && scope.cpu == -1 {
+		if cpus, err = getOnlineCPUs(); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, cpu := range cpus {
+		var group []*perfEvent
+
+		group, err = openCounterGroup(events, scope.pid, cpu)
+		c.groups = append(c.groups, group)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// openCounterGroup opens a group of counting events. The leader is created
+// disabled so the group can be enabled as a whole.
+func openCounterGroup(events []CounterEvent, pid, cpu int) ([]*perfEvent, error) {
+	var group []*perfEvent
+
+	for i := range events {
+		config := events[i].counterConfig(i == 0)
+
+		groupFD := -1
+		if i > 0 {
+			groupFD = group[0].fd
+		}
+
+		event, err := perfEventOpen(&config, pid, cpu, groupFD, 0)
+		if err != nil {
+			return group, fmt.Errorf("counter: %s: %v", events[i].name, err)
+		}
+		group = append(group, event)
+	}
+
+	return group, nil
+}
+
+// counterConfig returns the perf configuration of the event as a member of a
+// counter group.
+func (e *CounterEvent) counterConfig(leader bool) perfEventConfig {
+	config := e.attr
+	config.readFormat = perfFormatGroup | perfFormatTotalTimeEnabled | perfFormatTotalTimeRunning
+	config.counting = true
+	config.disabled = leader
+	return config
+}
+
+// forEachGroup calls fn with the leader of each group.
+func (c *Counter) forEachGroup(fn func(leader *perfEvent) error) error {
+	for _, group := range c.groups {
+		if err := fn(group[0]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Start starts counting.
+func (c *Counter) Start() error {
+	return c.forEachGroup((*perfEvent).enableGroup)
+}
+
+// Stop stops counting. The values can still be read after Stop.
+func (c *Counter) Stop() error {
+	return c.forEachGroup((*perfEvent).disableGroup)
+}
+
+// Reset sets the counts back to 0.
+func (c *Counter) Reset() error {
+	return c.forEachGroup((*perfEvent).resetGroup)
+}
+
+// Read returns the value of the counter, one value per event, in the order
+// the events were given to NewCounter. Counters of several CPUs add up the
+// counts and times of the CPUs.
+func (c *Counter) Read() ([]CounterValue, error) {
+	var enabled, running uint64
+	raw := make([]uint64, len(c.events))
+
+	// Aggregate the CPUs before scaling, as perf stat does.
+	for _, group := range c.groups {
+		data, err := group[0].readGroup(c.buf)
+		if err != nil {
+			return nil, err
+		}
+		r, err := parseGroupRead(data, len(c.events))
+		if err != nil {
+			return nil, err
+		}
+		enabled += r.enabled
+		running += r.running
+		for i := range raw {
+			raw[i] += r.values[i]
+		}
+	}
+
+	values := make([]CounterValue, len(c.events))
+	for i := range values {
+		values[i] = CounterValue{
+			Event:   c.events[i],
+			Value:   scaleCount(raw[i], enabled, running),
+			Raw:     raw[i],
+			Enabled: time.Duration(enabled),
+			Running: time.Duration(running),
+		}
+	}
+
+	return values, nil
+}
+
+// Close releases the resources associated with c.
+func (c *Counter) Close() {
+	for _, group := range c.groups {
+		// Close the group members before their leader.
+		for i := len(group) - 1; i >= 0; i-- {
+			group[i].close()
+		}
+	}
+	c.groups = nil
+}
+
+// groupRead is the data returned by read() on a group leader opened with
+// PERF_FORMAT_GROUP|PERF_FORMAT_TOTAL_TIME_ENABLED|PERF_FORMAT_TOTAL_TIME_RUNNING.
+type groupRead struct {
+	enabled uint64
+	running uint64
+	values  []uint64
+}
+
+var errShortRead = errors.New("counter: short read")
+
+// parseGroupRead decodes the data read from a group leader. The group is
+// expected to have n events.
+func parseGroupRead(data []byte, n int) (groupRead, error) {
+	var g groupRead
+	r := sampleReader{data: data}
+
+	nr := r.u64()
+	g.enabled = r.u64()
+	g.running = r.u64()
+	if r.err != nil {
+		return g, errShortRead
+	}
+	if nr != uint64(n) {
+		return g, fmt.Errorf("counter: expected %d values, got %d", n, nr)
+	}
+	g.values = make([]uint64, n)
+	for i := range g.values {
+		g.values[i] = r.u64()
+	}
+	if r.err != nil {
+		return g, errShortRead
+	}
+
+	return g, nil
+}
+
+// scaleCount estimates the number of events that would have been counted if
+// the event had been scheduled on the PMU for the whole time it was enabled.
+func scaleCount(count, enabled, running uint64) uint64 {
+	if running == 0 {
+		return 0
+	}
+	if running >= enabled {
+		return count
+	}
+
+	hi, lo := bits.Mul64(count, enabled)
+	if hi >= running {
+		return math.MaxUint64
+	}
+	v, _ := bits.Div64(hi, lo, running)
+	return v
+}
@@ -0,0 +1,131 @@
+package obs
+
+import (
+	"math"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCounterEventString(t *testing.T) {
+	tests := []struct {
+		event    CounterEvent
+		expected string
+	}{
+		{HardwareCounter(CPUCycles), "cycles"},
+		{HardwareCounter(BranchMisses), "branch-misses"},
+		{HardwareCounter(HardwareEventKind(42)), "unknown"},
+		{HWCacheCounter(CacheL1D, CacheOpRead, CacheResultAccess), "L1-dcache-loads"},
+		{HWCacheCounter(CacheLL, CacheOpWrite, CacheResultMiss), "LLC-store-misses"},
+		{RawCounter(0x1b0), "r1b0"},
+		{SoftwareCounter(TaskClock), "task-clock"},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, test.event.String())
+	}
+}
+
+func TestHWCacheCounterConfig(t *testing.T) {
+	e := HWCacheCounter(CacheDTLB, CacheOpPrefetch, CacheResultMiss)
+	assert.Equal(t, perfTypeHWCache, e.attr.eventType)
+	assert.Equal(t, uint64(0x10203), e.attr.config)
+}
+
+func TestCounterEventParams(t *testing.T) {
+	events := []CounterEvent{
+		HardwareCounter(CPUCycles),
+		HWCacheCounter(CacheL1D, CacheOpRead, CacheResultMiss),
+		RawCounter(0x1b0),
+		SoftwareCounter(TaskClock),
+	}
+
+	for _, e := range events {
+		for _, leader := range []bool{true, false} {
+			config := e.counterConfig(leader)
+			params := perfEventParams(&config)
+			// Counters must not sample, or they would be throttled.
+			assert.Equal(t, uint64(0), uint64(params.sample_period), "%s", e)
+			assert.Equal(t, uint64(config.readFormat), uint64(params.read_format), "%s", e)
+		}
+	}
+
+	// Sampling events sample every event by default.
+	config := perfEventConfig{eventType: perfTypeSoftware}
+	params := perfEventParams(&config)
+	assert.Equal(t, uint64(1), uint64(params.sample_period))
+}
+
+func TestScaleCount(t *testing.T) {
+	tests := []struct {
+		count, enabled, running uint64
+		expected                uint64
+	}{
+		{100, 1000, 1000, 100},
+		{100, 1000, 500, 200},
+		{100, 1000, 0, 0},
+		{0, 0, 0, 0},
+		{math.MaxUint64 / 2, 4, 1, math.MaxUint64},
+		{1 << 62, 6, 3, 1 << 63},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, scaleCount(test.count, test.enabled, test.running))
+	}
+}
+
+func TestParseGroupRead(t *testing.T) {
+	var b sampleBuilder
+	b.u64(2).u64(1000).u64(500).u64(42).u64(43)
+
+	r, err := parseGroupRead([]byte(b), 2)
+	assert.Nil(t, err)
+	assert.Equal(t, groupRead{enabled: 1000, running: 500, values: []uint64{42, 43}}, r)
+
+	_, err = parseGroupRead([]byte(b), 3)
+	assert.NotNil(t, err)
+	_, err = parseGroupRead(b[:len(b)-1], 2)
+	assert.Equal(t, errShortRead, err)
+}
+
+func TestCounter(t *testing.T) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	c, err := NewCounter(ForProcess(0), SoftwareCounter(TaskClock), SoftwareCounter(PageFaults))
+	if err != nil {
+		t.Skipf("unable to open counter: %v", err)
+	}
+	defer c.Close()
+
+	values, err := c.Read()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0), values[0].Value)
+
+	assert.Nil(t, c.Start())
+	x := 0
+	for i := 0; i < 10000000; i++ {
+		x += i
+	}
+	_ = make([]byte, 1<<20)
+	assert.Nil(t, c.Stop())
+
+	values, err = c.Read()
+	assert.Nil(t, err)
+	assert.Len(t, values, 2)
+	assert.Equal(t, "task-clock", values[0].Event.String())
+	assert.True(t, values[0].Value > 0)
+	assert.True(t, values[0].Enabled > 0)
+	assert.False(t, values[0].Multiplexed())
+
+	assert.Nil(t, c.Reset())
+	values, err = c.Read()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0), values[0].Raw)
+}
+
+func TestCounterNoEvent(t *testing.T) {
+	_, err := NewCounter(SystemWide())
+	assert.NotNil(t, err)
+}
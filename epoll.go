@@ -30,6 +30,10 @@ func (ep *epoll) addFd(fd int, events uint32) error {
 	return unix.EpollCtl(ep.fd, unix.EPOLL_CTL_ADD, fd, &ev)
 }
 
+func (ep *epoll) removeFd(fd int) error {
+	return unix.EpollCtl(ep.fd, unix.EPOLL_CTL_DEL, fd, nil)
+}
+
 func (ep *epoll) poll(timeout int) (int, error) {
 	nFds, err := unix.EpollWait(ep.fd, ep.events[0:], timeout)
 	if err != nil {
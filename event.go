@@ -19,11 +19,55 @@ func (e baseEvent) GetSource() EventSource {
 	return e.source
 }
 
+// GetTimestamp returns the time at which e has been recorded, in nanoseconds.
+// The clock is the kernel's local clock, which doesn't have a defined origin
+// but can be used to compute durations between events.
+func (e baseEvent) GetTimestamp() uint64 {
+	return e.timestamp
+}
+
+// SoftwareEvent is fired when a software event is sampled.
+type SoftwareEvent struct {
+	baseEvent
+	// IP is the instruction pointer when the event was sampled.
+	IP uint64
+	// PID is the process ID of the task that was running.
+	PID int
+	// TID is the thread ID of the task that was running.
+	TID int
+	// CPU is the CPU the event happened on.
+	CPU int
+	// Data is the data sent by the eBPF program for BPFOutput events.
+	Data []byte
+}
+
+// Trace flags, found in the common_flags field of tracepoints. Those are
+// TRACE_FLAG_* in the kernel sources.
+const (
+	traceFlagIRQsOff        = 0x01
+	traceFlagIRQsNoSupport  = 0x02
+	traceFlagNeedResched    = 0x04
+	traceFlagHardIRQ        = 0x08
+	traceFlagSoftIRQ        = 0x10
+	traceFlagPreemptResched = 0x20
+	traceFlagNMI            = 0x40
+)
+
+// FieldHandle is a tracepoint field resolved by Observer.Field. Decoding a
+// field through its handle doesn't involve looking up the field by name.
+type FieldHandle struct {
+	source EventSource
+	field  field
+}
+
 // TracepointEvent is fired when a Tracepoint is hit.
 type TracepointEvent struct {
 	baseEvent
-	tp   *tracepoint
-	data []byte
+	tp        *tracepoint
+	data      []byte
+	cpu       int
+	tgid      int
+	callchain []uint64
 }
 
 // Data returns the tracepoint raw data.
@@ -31,6 +75,23 @@ func (e *TracepointEvent) Data() []byte {
 	return e.data
 }
 
+// CPU returns the CPU the tracepoint has been hit on.
+func (e *TracepointEvent) CPU() int {
+	return e.cpu
+}
+
+// Callchain returns the kernel and user space stacks, leaf first, of the task
+// that hit the tracepoint. The stacks are only recorded for tracepoints added
+// with the WithCallchain option.
+func (e *TracepointEvent) Callchain() (kernel, user []uint64) {
+	return splitCallchain(e.callchain)
+}
+
+// Arch describes the machine that has recorded the tracepoint data.
+func (e *TracepointEvent) Arch() Arch {
+	return e.tp.format.arch
+}
+
 // GetInt retrieves an integer corresponding to the field named 'name' from the
 // tracepoint data. If 'name' isn't a valid field name, GetInt returns -1.
 //
@@ -53,9 +114,129 @@ func (e *TracepointEvent) GetInt(name string) int {
 	return v
 }
 
-// GetString retrieves an integer corresponding to the field named 'name' from the
-// tracepoint data. If 'name' isn't a valid field name, GetString returns "".
+// GetString retrieves a string corresponding to the field named 'name' from the
+// tracepoint data. Both dynamic strings and char arrays can be retrieved. If
+// 'name' isn't a valid field name, GetString returns "".
 func (e *TracepointEvent) GetString(name string) string {
 	v, _ := e.tp.format.decodeString(e.data, name)
 	return v
 }
+
+// GetInts retrieves the integers of the array field named 'name' from the
+// tracepoint data. This works for both fixed size arrays and dynamic arrays,
+// eg. the "__data_loc unsigned long[]" fields holding bitmasks. If 'name'
+// isn't a valid array field name, GetInts returns nil.
+func (e *TracepointEvent) GetInts(name string) []int {
+	v, _ := e.tp.format.decodeInts(e.data, name)
+	return v
+}
+
+// GetKernelSymbol renders the kernel pointer field named 'name', eg.
+// "call_site", as a symbol with an offset, see KernelSymbols.Symbolize. If
+// 'name' isn't a valid field name, GetKernelSymbol returns "".
+func (e *TracepointEvent) GetKernelSymbol(name string, symbols *KernelSymbols) string {
+	v, err := e.tp.format.decodeInt(e.data, name)
+	if err != nil {
+		return ""
+	}
+	return symbols.Symbolize(uint64(v))
+}
+
+// IntAt retrieves the integer field h from the tracepoint data. If h doesn't
+// belong to the tracepoint that has emitted e, IntAt returns -1.
+func (e *TracepointEvent) IntAt(h FieldHandle) int {
+	if h.source != e.source {
+		return -1
+	}
+	v, err := decodeIntInternal(e.data, &h.field, e.tp.format.arch.ByteOrder)
+	if err != nil {
+		return -1
+	}
+	return v
+}
+
+// StringAt retrieves the string field h from the tracepoint data. If h doesn't
+// belong to the tracepoint that has emitted e, StringAt returns "".
+func (e *TracepointEvent) StringAt(h FieldHandle) string {
+	if h.source != e.source {
+		return ""
+	}
+	v, _ := decodeStringInternal(e.data, &h.field, e.tp.format.arch.ByteOrder)
+	return v
+}
+
+// commonInt decodes the common field f. Older kernels may not have all common
+// fields, commonInt returns -1 for the missing ones.
+func (e *TracepointEvent) commonInt(f *field) int {
+	if f == nil {
+		return -1
+	}
+	v, err := decodeIntInternal(e.data, f, e.tp.format.arch.ByteOrder)
+	if err != nil {
+		return -1
+	}
+	return v
+}
+
+// CommonType returns the ID of the tracepoint that has emitted e.
+func (e *TracepointEvent) CommonType() int {
+	return e.commonInt(e.tp.format.common.typ)
+}
+
+// CommonPID returns the PID of the task that was running when the tracepoint
+// was hit. It can differ from the PID found in the tracepoint fields, eg. the
+// PID of the woken up task in sched_wakeup.
+func (e *TracepointEvent) CommonPID() int {
+	return e.commonInt(e.tp.format.common.pid)
+}
+
+// TGID returns the thread group ID, the process ID, of the task that was
+// running when the tracepoint was hit. CommonPID is the ID of the thread.
+func (e *TracepointEvent) TGID() int {
+	return e.tgid
+}
+
+// PreemptCount returns the preemption count when the tracepoint was hit.
+func (e *TracepointEvent) PreemptCount() int {
+	return e.commonInt(e.tp.format.common.preemptCount)
+}
+
+func (e *TracepointEvent) hasFlag(flag int) bool {
+	flags := e.commonInt(e.tp.format.common.flags)
+	return flags != -1 && flags&flag != 0
+}
+
+// IRQsDisabled returns true if interrupts were disabled when the tracepoint was
+// hit. Some architectures don't support reporting this and IRQsDisabled
+// always returns false for them.
+func (e *TracepointEvent) IRQsDisabled() bool {
+	return e.hasFlag(traceFlagIRQsOff) && !e.hasFlag(traceFlagIRQsNoSupport)
+}
+
+// NeedResched returns true if a reschedule was pending when the tracepoint was
+// hit.
+func (e *TracepointEvent) NeedResched() bool {
+	return e.hasFlag(traceFlagNeedResched)
+}
+
+// PreemptResched returns true if a reschedule was pending in the preemption
+// count when the tracepoint was hit. Some architectures, eg. x86, fold the
+// need to reschedule into the preemption count.
+func (e *TracepointEvent) PreemptResched() bool {
+	return e.hasFlag(traceFlagPreemptResched)
+}
+
+// InHardIRQ returns true if the tracepoint was hit in hard interrupt context.
+func (e *TracepointEvent) InHardIRQ() bool {
+	return e.hasFlag(traceFlagHardIRQ)
+}
+
+// InSoftIRQ returns true if the tracepoint was hit in soft interrupt context.
+func (e *TracepointEvent) InSoftIRQ() bool {
+	return e.hasFlag(traceFlagSoftIRQ)
+}
+
+// InNMI returns true if the tracepoint was hit in NMI context.
+func (e *TracepointEvent) InNMI() bool {
+	return e.hasFlag(traceFlagNMI)
+}
@@ -0,0 +1,108 @@
+package obs
+
+import (
+	"container/heap"
+	"time"
+)
+
+// timestamped is implemented by all the events emitted by an Observer.
+type timestamped interface {
+	GetTimestamp() uint64
+}
+
+// eventHeap is a min-heap of events, ordered by timestamp.
+type eventHeap []Event
+
+func (h eventHeap) Len() int { return len(h) }
+func (h eventHeap) Less(i, j int) bool {
+	return h[i].(timestamped).GetTimestamp() < h[j].(timestamped).GetTimestamp()
+}
+func (h eventHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *eventHeap) Push(x interface{}) { *h = append(*h, x.(Event)) }
+func (h *eventHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return e
+}
+
+// EventSorter orders events by timestamp.
+//
+// The events of every CPU are read independently by an Observer: events of a
+// CPU are received in order, but events of different CPUs can be received out
+// of order. Analyses pairing events happening on different CPUs, eg. a task
+// switched out on a CPU and switched in on another one, need them in order.
+//
+// EventSorter holds events until events at least window more recent have been
+// pushed, and then releases them in order. Events received more than window
+// late are released out of order.
+type EventSorter struct {
+	window uint64
+	events eventHeap
+	// latest is the timestamp of the most recent event pushed.
+	latest uint64
+}
+
+// NewEventSorter creates an EventSorter holding events for window.
+func NewEventSorter(window time.Duration) *EventSorter {
+	return &EventSorter{
+		window: uint64(window),
+	}
+}
+
+// Push adds an event to the sorter. Events without timestamp are released
+// first.
+func (s *EventSorter) Push(e Event) {
+	if t, ok := e.(timestamped); ok {
+		if ts := t.GetTimestamp(); ts > s.latest {
+			s.latest = ts
+		}
+	} else {
+		e = untimedEvent{e}
+	}
+	heap.Push(&s.events, e)
+}
+
+// untimedEvent gives a 0 timestamp to events that don't have one.
+type untimedEvent struct {
+	Event
+}
+
+func (untimedEvent) GetTimestamp() uint64 { return 0 }
+
+// unwrap returns the event given to Push.
+func unwrap(e Event) Event {
+	if u, ok := e.(untimedEvent); ok {
+		return u.Event
+	}
+	return e
+}
+
+// Pop returns the oldest event, if it's older than the most recent event by
+// at least the window of the sorter. Pop returns nil when there's no such
+// event.
+func (s *EventSorter) Pop() Event {
+	if len(s.events) == 0 {
+		return nil
+	}
+	ts := s.events[0].(timestamped).GetTimestamp()
+	if ts+s.window > s.latest {
+		return nil
+	}
+	return unwrap(heap.Pop(&s.events).(Event))
+}
+
+// Flush returns all the events held by the sorter, in order.
+func (s *EventSorter) Flush() []Event {
+	events := make([]Event, 0, len(s.events))
+	for len(s.events) > 0 {
+		events = append(events, unwrap(heap.Pop(&s.events).(Event)))
+	}
+	return events
+}
+
+// Len returns the number of events held by the sorter.
+func (s *EventSorter) Len() int {
+	return len(s.events)
+}
@@ -0,0 +1,50 @@
+package obs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTimedEvent(ts uint64) Event {
+	return &SoftwareEvent{baseEvent: baseEvent{timestamp: ts}}
+}
+
+func timestamps(events []Event) []uint64 {
+	var ts []uint64
+	for _, e := range events {
+		ts = append(ts, e.(*SoftwareEvent).GetTimestamp())
+	}
+	return ts
+}
+
+func TestEventSorter(t *testing.T) {
+	s := NewEventSorter(10)
+
+	var popped []Event
+	pop := func() {
+		for e := s.Pop(); e != nil; e = s.Pop() {
+			popped = append(popped, e)
+		}
+	}
+
+	for _, ts := range []uint64{100, 105, 102} {
+		s.Push(newTimedEvent(ts))
+	}
+	pop()
+	assert.Len(t, popped, 0)
+
+	s.Push(newTimedEvent(113))
+	pop()
+	assert.Equal(t, []uint64{100, 102}, timestamps(popped))
+	assert.Equal(t, 2, s.Len())
+
+	// Too late to be ordered.
+	s.Push(newTimedEvent(101))
+	pop()
+	assert.Equal(t, []uint64{100, 102, 101}, timestamps(popped))
+
+	assert.Equal(t, []uint64{105, 113}, timestamps(s.Flush()))
+	assert.Equal(t, 0, s.Len())
+	assert.Nil(t, s.Pop())
+}
@@ -0,0 +1,130 @@
+package obs
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestEvent creates an observer with a single tracepoint which format has
+// already been parsed, as if Open had been called, and an event from that
+// tracepoint.
+func newTestEvent(t testing.TB, formatDesc string, data []byte) (*Observer, *TracepointEvent) {
+	o := NewObserver()
+	source := o.AddTracepoint("test:test")
+	tp := o.tracepoints[0].tp
+	assert.Nil(t, tp.format.initFromReader(strings.NewReader(formatDesc)))
+
+	return o, &TracepointEvent{
+		baseEvent: baseEvent{
+			source: source,
+		},
+		tp:   tp,
+		data: data,
+	}
+}
+
+func TestField(t *testing.T) {
+	o, e := newTestEvent(t, execFormat, execData)
+
+	pid, err := o.Field(e.GetSource(), "pid")
+	assert.Nil(t, err)
+	assert.Equal(t, bashPID, e.IntAt(pid))
+
+	filename, err := o.Field(e.GetSource(), "filename")
+	assert.Nil(t, err)
+	assert.Equal(t, bashCmdline, e.StringAt(filename))
+
+	_, err = o.Field(e.GetSource(), "nope")
+	assert.NotNil(t, err)
+	_, err = o.Field(e.GetSource()+1, "pid")
+	assert.NotNil(t, err)
+
+	// Handles from another tracepoint are refused.
+	pid.source++
+	assert.Equal(t, -1, e.IntAt(pid))
+	assert.Equal(t, "", e.StringAt(pid))
+}
+
+func TestFieldBeforeOpen(t *testing.T) {
+	o := NewObserver()
+	source := o.AddTracepoint("sched:sched_process_exec")
+	_, err := o.Field(source, "pid")
+	assert.NotNil(t, err)
+}
+
+func BenchmarkGetInt(b *testing.B) {
+	_, e := newTestEvent(b, execFormat, execData)
+
+	for i := 0; i < b.N; i++ {
+		e.GetInt("old_pid")
+	}
+}
+
+func BenchmarkIntAt(b *testing.B) {
+	o, e := newTestEvent(b, execFormat, execData)
+	oldPID, _ := o.Field(e.GetSource(), "old_pid")
+
+	for i := 0; i < b.N; i++ {
+		e.IntAt(oldPID)
+	}
+}
+
+func TestCommonFields(t *testing.T) {
+	_, e := newTestEvent(t, execFormat, execData)
+
+	assert.Equal(t, 266, e.CommonType())
+	assert.Equal(t, bashPID, e.CommonPID())
+	assert.Equal(t, 0, e.PreemptCount())
+	assert.False(t, e.IRQsDisabled())
+	assert.False(t, e.InSoftIRQ())
+	assert.False(t, e.InHardIRQ())
+}
+
+func TestCommonFlags(t *testing.T) {
+	tests := []struct {
+		flags          byte
+		preemptCount   byte
+		irqsDisabled   bool
+		softIRQ        bool
+		hardIRQ        bool
+		nmi            bool
+		needResched    bool
+		preemptResched bool
+	}{
+		{0x01, 1, true, false, false, false, false, false},
+		{0x03, 0, false, false, false, false, false, false},
+		{0x09, 2, true, false, true, false, false, false},
+		{0x10, 1, false, true, false, false, false, false},
+		{0x49, 3, true, false, true, true, false, false},
+		{0x04, 0, false, false, false, false, true, false},
+		{0x24, 1, false, false, false, false, true, true},
+	}
+
+	for _, test := range tests {
+		data := append([]byte(nil), execData...)
+		data[2] = test.flags
+		data[3] = test.preemptCount
+		_, e := newTestEvent(t, execFormat, data)
+
+		assert.Equal(t, int(test.preemptCount), e.PreemptCount())
+		assert.Equal(t, test.irqsDisabled, e.IRQsDisabled())
+		assert.Equal(t, test.softIRQ, e.InSoftIRQ())
+		assert.Equal(t, test.hardIRQ, e.InHardIRQ())
+		assert.Equal(t, test.nmi, e.InNMI())
+		assert.Equal(t, test.needResched, e.NeedResched())
+		assert.Equal(t, test.preemptResched, e.PreemptResched())
+	}
+}
+
+func TestCallchain(t *testing.T) {
+	_, e := newTestEvent(t, execFormat, execData)
+	e.cpu = 3
+	e.callchain = []uint64{perfContextKernel, 0xffffffff81000010, perfContextUser, 0x401000}
+
+	kernel, user := e.Callchain()
+	assert.Equal(t, []uint64{0xffffffff81000010}, kernel)
+	assert.Equal(t, []uint64{0x401000}, user)
+	assert.Equal(t, 3, e.CPU())
+}
@@ -38,7 +38,12 @@ func main() {
 			if err == nil {
 				pidnsStr = strconv.FormatUint(pidns, 10)
 			}
-			fmt.Printf("exec\t%d\t% 10s\t%s\n", pid, pidnsStr, tp.GetString("filename"))
+			// The PID as seen from inside the PID namespace.
+			nspidStr := "err"
+			if nspids, err := process.NSPids(); err == nil {
+				nspidStr = strconv.Itoa(nspids[len(nspids)-1])
+			}
+			fmt.Printf("exec\t%d\t% 10s\t%s\t%s\n", pid, pidnsStr, nspidStr, tp.GetString("filename"))
 		default:
 			fmt.Fprintf(os.Stderr, "Unknown event source: %d", source)
 		}
@@ -0,0 +1,110 @@
+package obs
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// Exec runs cmd and observes it, along with the processes and threads it
+// creates, until it exits. Exec returns the exit status of cmd, -1 if it has
+// been killed by a signal.
+//
+// Exec opens the observer, and fails if it has already been opened, and
+// restricts all the event sources to cmd, overriding the WithPID, WithInherit
+// and WithCgroup options. Events are received with ReadEvent, from another
+// goroutine, from the moment cmd executes its program. Exec closes the
+// observer once cmd has exited and the pending events have been received,
+// ReadEvent then returns a nil event. Pending events are dropped when none
+// has been received for a second, Exec doesn't wait forever for a reader.
+//
+// cmd is first started as a shell waiting for the events to be opened before
+// executing the program. The program is given cmd.Path, rather than
+// cmd.Args[0], as argv[0]. The Path, Args and ExtraFiles fields of cmd are
+// modified while the command runs, and restored before Exec returns.
+//
+// This is the equivalent of perf record -- cmd.
+func (o *Observer) Exec(cmd *exec.Cmd) (int, error) {
+	if o.opened {
+		return -1, errors.New("exec: observer already opened")
+	}
+	if cmd.Process != nil {
+		return -1, errors.New("exec: command already started")
+	}
+	// Catch errors before the shell does.
+	if _, err := exec.LookPath(cmd.Path); err != nil {
+		return -1, errors.Wrap(err, "exec")
+	}
+
+	// The events are created disabled and enabled by the kernel when the
+	// process calls exec(). We need the PID of the process to create the
+	// events, so the process is created first, running a shell waiting on
+	// a pipe, and only then executes the command.
+	gate, release, err := os.Pipe()
+	if err != nil {
+		return -1, errors.Wrap(err, "exec")
+	}
+	defer release.Close()
+
+	path, args, extraFiles := cmd.Path, cmd.Args, cmd.ExtraFiles
+	defer func() {
+		cmd.Path, cmd.Args, cmd.ExtraFiles = path, args, extraFiles
+	}()
+
+	// POSIX shells can't choose the argv[0] of the program they execute.
+	fd := 3 + len(extraFiles)
+	script := "read _ <&" + strconv.Itoa(fd) + "; exec " + strconv.Itoa(fd) + "<&- \"$@\""
+	cmd.ExtraFiles = append(append([]*os.File(nil), extraFiles...), gate)
+	cmd.Args = []string{"sh", "-c", script, "sh", path}
+	if len(args) > 1 {
+		cmd.Args = append(cmd.Args, args[1:]...)
+	}
+	cmd.Path = "/bin/sh"
+
+	err = cmd.Start()
+	gate.Close()
+	if err != nil {
+		return -1, errors.Wrap(err, "exec")
+	}
+
+	pid := cmd.Process.Pid
+	for i := range o.tracepoints {
+		restrictToExec(&o.tracepoints[i].tp.options, pid)
+	}
+	for i := range o.softwareEvents {
+		restrictToExec(&o.softwareEvents[i].event.options, pid)
+	}
+
+	if err := o.Open(); err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return -1, err
+	}
+
+	// Let the command run.
+	_, err = release.Write([]byte{'\n'})
+	release.Close()
+	if err != nil {
+		cmd.Process.Kill()
+	}
+
+	waitErr := cmd.Wait()
+	o.drain()
+
+	if err != nil {
+		return -1, errors.Wrap(err, "exec")
+	}
+	if _, ok := waitErr.(*exec.ExitError); waitErr != nil && !ok {
+		return -1, errors.Wrap(waitErr, "exec")
+	}
+	return cmd.ProcessState.ExitCode(), nil
+}
+
+func restrictToExec(options *eventOptions, pid int) {
+	options.pid = pid
+	options.inherit = true
+	options.enableOnExec = true
+	options.cgroup = ""
+}
@@ -0,0 +1,123 @@
+package obs
+
+import (
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// isPerfPermission returns true if err is perf_event_open refusing to let us
+// observe the system.
+func isPerfPermission(err error) bool {
+	serr, ok := err.(*os.SyscallError)
+	return ok && serr.Syscall == "perf_event_open" && os.IsPermission(serr)
+}
+
+func TestExec(t *testing.T) {
+	o := NewObserver()
+	o.AddTracepoint("sched:sched_process_exec")
+
+	var filenames []string
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			event, err := o.ReadEvent()
+			if err != nil || event == nil {
+				return
+			}
+			filenames = append(filenames, event.(*TracepointEvent).GetString("filename"))
+		}
+	}()
+
+	cmd := exec.Command("/bin/sh", "-c", "/bin/true; exit 3")
+	args := cmd.Args
+	status, err := o.Exec(cmd)
+	if isPerfPermission(err) {
+		t.Skipf("unable to exec: %v", err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 3, status)
+	// The command is left as it was given.
+	assert.Equal(t, "/bin/sh", cmd.Path)
+	assert.Equal(t, args, cmd.Args)
+	assert.Empty(t, cmd.ExtraFiles)
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the observer hasn't been closed")
+	}
+	// The gate isn't observed, the command and its children are.
+	assert.Equal(t, []string{"/bin/sh", "/bin/true"}, filenames)
+}
+
+func TestExecNoReader(t *testing.T) {
+	o := NewObserver()
+	o.AddTracepoint("sched:sched_process_exec")
+
+	done := make(chan error)
+	go func() {
+		_, err := o.Exec(exec.Command("/bin/true"))
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if isPerfPermission(err) {
+			t.Skipf("unable to exec: %v", err)
+		}
+		assert.NoError(t, err)
+	case <-time.After(5 * drainTimeout):
+		t.Fatal("Exec is waiting for a reader")
+	}
+	event, err := o.ReadEvent()
+	assert.NoError(t, err)
+	assert.Nil(t, event)
+}
+
+func TestExecErrors(t *testing.T) {
+	o := NewObserver()
+	o.AddTracepoint("sched:sched_process_exec")
+	_, err := o.Exec(exec.Command("/does/not/exist"))
+	assert.Error(t, err)
+
+	cmd := exec.Command("/bin/true")
+	assert.NoError(t, cmd.Run())
+	_, err = NewObserver().Exec(cmd)
+	assert.Error(t, err)
+
+	// The observer is already opened.
+	o = NewObserver()
+	o.AddSoftwareEvent(Dummy)
+	openObserver(t, o)
+	defer o.Close()
+	_, err = o.Exec(exec.Command("/bin/true"))
+	assert.Error(t, err)
+}
+
+func TestWithPID(t *testing.T) {
+	sleep := exec.Command("sleep", "10")
+	assert.NoError(t, sleep.Start())
+	defer sleep.Wait()
+	defer sleep.Process.Kill()
+
+	o := NewObserver()
+	o.AddTracepoint("sched:sched_process_exit", WithPID(sleep.Process.Pid))
+	openObserver(t, o)
+	defer o.Close()
+
+	// Other processes aren't observed.
+	assert.NoError(t, exec.Command("/bin/true").Run())
+	sleep.Process.Kill()
+
+	event, err := o.ReadEvent()
+	assert.NoError(t, err)
+	assert.Equal(t, sleep.Process.Pid, event.(*TracepointEvent).GetInt("pid"))
+	assert.Equal(t, sleep.Process.Pid, event.(*TracepointEvent).TGID())
+}
@@ -2,6 +2,7 @@ package obs
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -9,6 +10,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"unicode"
 )
 
 type fieldFlag int
@@ -22,6 +24,7 @@ const (
 	fieldFlagLong
 	fieldFlagFlag
 	fieldFlagSymbolic
+	fieldFlagRelative // __rel_loc
 )
 
 // field describes one field associated with a ftrace event.
@@ -30,6 +33,7 @@ const (
 // description for each event.
 type field struct {
 	name   string
+	typ    string
 	offset int
 	size   int
 	flags  fieldFlag
@@ -38,7 +42,48 @@ type field struct {
 
 // format is the metadata associated with a ftrace event
 type format struct {
+	name string
+	id   int
+	// arch describes the machine that has recorded the data to decode.
+	arch Arch
+	// fields holds the common fields followed by the per-event fields.
 	fields []field
+	// nCommon is the number of common fields at the start of fields.
+	nCommon int
+	// common points at the common fields the kernel adds to every event.
+	common struct {
+		typ          *field
+		flags        *field
+		preemptCount *field
+		pid          *field
+	}
+}
+
+// commonFields returns the fields common to all ftrace events.
+func (f *format) commonFields() []field {
+	return f.fields[:f.nCommon]
+}
+
+// eventFields returns the fields specific to this ftrace event.
+func (f *format) eventFields() []field {
+	return f.fields[f.nCommon:]
+}
+
+// resolveCommonFields looks up the common fields once and for all.
+func (f *format) resolveCommonFields() {
+	common := f.commonFields()
+	for i := range common {
+		switch common[i].name {
+		case "common_type":
+			f.common.typ = &common[i]
+		case "common_flags":
+			f.common.flags = &common[i]
+		case "common_preempt_count":
+			f.common.preemptCount = &common[i]
+		case "common_pid":
+			f.common.pid = &common[i]
+		}
+	}
 }
 
 // State of the format description parser.
@@ -160,6 +205,27 @@ func (ctx *tokenCtx) discard(end byte) bool {
 	return true
 }
 
+// discardParens consumes characters until the ')' matching an already consumed
+// '(' is found.
+func (ctx *tokenCtx) discardParens() bool {
+	depth := 1
+
+	for c := ctx.peekChar(); c != 0; c = ctx.peekChar() {
+		ctx.index++
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
 func (ctx *tokenCtx) getToken() (string, tokenType) {
 next:
 	ctx.token = make([]byte, 0, 32)
@@ -179,31 +245,67 @@ next:
 		goto next
 	case tokenTypeNewline:
 		return "", tokenTypeNone
-	case tokenTypeOperator:
+	case tokenTypeOperator, tokenTypeDelimiter:
 		return string(ctx.token), t
-	case tokenTypeDelimiter:
-		fallthrough
 	default:
 		return "", tokenTypeError
 	}
 
 }
 
+// parseFunctionPointer parses the declarator of a function pointer field, eg.
+// "(*func)(void *)", once the first '(' has been consumed.
+func parseFunctionPointer(ctx *tokenCtx, out *field) error {
+	if token, _ := ctx.getToken(); token != "*" {
+		return fmt.Errorf("format: expected '*' in function pointer, got '%s'", token)
+	}
+	name, t := ctx.getToken()
+	if t != tokenTypeIdentifier {
+		return fmt.Errorf("format: expected function pointer name, got '%s'", name)
+	}
+	if token, _ := ctx.getToken(); token != ")" {
+		return fmt.Errorf("format: expected ')' in function pointer, got '%s'", token)
+	}
+	if token, _ := ctx.getToken(); token != "(" {
+		return fmt.Errorf("format: expected function pointer arguments, got '%s'", token)
+	}
+	if !ctx.discardParens() {
+		return errors.New("format: unmatched '(' in function pointer arguments")
+	}
+
+	out.name = name
+	out.flags |= fieldFlagPointer
+
+	return nil
+}
+
 func parseFieldTypeName(str string, out *field) error {
-	if !strings.HasPrefix(str, "field:") {
+	switch {
+	case strings.HasPrefix(str, "field:"):
+		str = str[len("field:"):]
+	case strings.HasPrefix(str, "field special:"):
+		// Some ftrace internal events use that on old kernels.
+		str = str[len("field special:"):]
+	default:
 		return errors.New("format: expected 'field:'")
 	}
 
-	str = str[len("field:"):]
 	ctx := tokenCtx{}
 	ctx.init(str)
 
+	// The C type is made of all the identifiers and '*' but the last
+	// identifier, the variable name. Function pointers are the exception:
+	// their name is found between parenthesis.
+	var parts []string
+	functionPointer := false
+
 	for token, t := ctx.getToken(); token != ""; {
 		if t == tokenTypeError {
 			return errors.New("format: error parsing field: " + str)
 		}
 
-		if t == tokenTypeOperator {
+		switch t {
+		case tokenTypeOperator:
 			switch token {
 			// Note that the field is an array and discard its size (the size property
 			// will tell us anyway)
@@ -212,20 +314,47 @@ func parseFieldTypeName(str string, out *field) error {
 				if !ctx.discard(']') {
 					return fmt.Errorf("format: unmatched '[' in \"%s\"", str)
 				}
+			case "*":
+				out.flags |= fieldFlagPointer
+				parts = append(parts, token)
+			}
+		case tokenTypeDelimiter:
+			if token != "(" || functionPointer || len(parts) == 0 {
+				return fmt.Errorf("format: unexpected '%s' in \"%s\"", token, str)
+			}
+			if err := parseFunctionPointer(&ctx, out); err != nil {
+				return err
 			}
-		} else if t == tokenTypeIdentifier {
+			functionPointer = true
+		case tokenTypeIdentifier:
 			switch token {
 			case "__data_loc":
 				out.flags |= fieldFlagDynamic
+			case "__rel_loc":
+				out.flags |= fieldFlagDynamic | fieldFlagRelative
 			default:
-				// The last identifier is the variable name.
-				out.name = token
+				if functionPointer {
+					return fmt.Errorf("format: unexpected '%s' after function pointer in \"%s\"", token, str)
+				}
+				parts = append(parts, token)
 			}
 		}
 
 		token, t = ctx.getToken()
 	}
 
+	if functionPointer {
+		out.typ = strings.Join(parts, " ") + " (*)()"
+		return nil
+	}
+
+	// The last identifier is the variable name.
+	if len(parts) == 0 || parts[len(parts)-1] == "*" {
+		return errors.New("format: no field name in " + str)
+	}
+	out.name = parts[len(parts)-1]
+	out.typ = strings.Join(parts[:len(parts)-1], " ")
+
 	return nil
 }
 
@@ -234,7 +363,7 @@ func parseFieldNumber(str string, prefix string, out *int) error {
 		return errors.New("format: expected '" + prefix + "'")
 	}
 
-	i, err := strconv.Atoi(str[len(prefix):])
+	i, err := strconv.Atoi(strings.TrimSpace(str[len(prefix):]))
 	if err != nil {
 		return err
 	}
@@ -264,6 +393,35 @@ func parseFieldSign(str string, out *field) error {
 	return nil
 }
 
+// unsignedTypes are the C types, found in the wild, we know are unsigned.
+var unsignedTypes = map[string]bool{
+	"bool":    true,
+	"u8":      true,
+	"u16":     true,
+	"u32":     true,
+	"u64":     true,
+	"__u8":    true,
+	"__u16":   true,
+	"__u32":   true,
+	"__u64":   true,
+	"size_t":  true,
+	"gfp_t":   true,
+	"dev_t":   true,
+	"umode_t": true,
+}
+
+// isSignedType guesses the sign of a C type, for the kernels that don't give
+// the 'signed' property.
+func isSignedType(field *field) bool {
+	if field.flags&fieldFlagPointer != 0 {
+		return false
+	}
+	if strings.HasPrefix(field.typ, "unsigned") || unsignedTypes[field.typ] {
+		return false
+	}
+	return true
+}
+
 func parseField(field string, out *field) error {
 	parts := strings.Split(field, ";")
 
@@ -277,26 +435,38 @@ func parseField(field string, out *field) error {
 			len(parts), field)
 	}
 
-	for i := range parts {
-		parts[i] = strings.TrimSpace(parts[i])
+	hasOffset, hasSize, hasSign := false, false, false
+	for i, part := range parts {
+		part = strings.TrimSpace(part)
+
+		var err error
+		switch {
+		case i == 0:
+			err = parseFieldTypeName(part, out)
+		case strings.HasPrefix(part, "offset:"):
+			err = parseFieldOffset(part, out)
+			hasOffset = true
+		case strings.HasPrefix(part, "size:"):
+			err = parseFieldSize(part, out)
+			hasSize = true
+		case strings.HasPrefix(part, "signed:"):
+			err = parseFieldSign(part, out)
+			hasSign = true
+		}
+		// Unknown properties are ignored.
+		if err != nil {
+			return err
+		}
 	}
 
-	if err := parseFieldTypeName(parts[0], out); err != nil {
-		return err
+	if !hasOffset || !hasSize {
+		return fmt.Errorf("format: missing offset or size in %s", field)
 	}
-	if err := parseFieldOffset(parts[1], out); err != nil {
-		return err
+	if out.offset < 0 || out.size < 0 {
+		return fmt.Errorf("format: invalid offset or size in %s", field)
 	}
-	if err := parseFieldSize(parts[2], out); err != nil {
-		return err
-	}
-
-	if len(parts) == 3 {
-		return nil
-	}
-
-	if err := parseFieldSign(parts[3], out); err != nil {
-		return err
+	if !hasSign {
+		out.signed = isSignedType(out)
 	}
 
 	return nil
@@ -318,21 +488,39 @@ func parseField(field string, out *field) error {
 //   	field:pid_t child_pid;	offset:44;	size:4;	signed:1;
 //
 //   print fmt: "comm=%s pid=%d child_comm=%s child_pid=%d", REC->parent_comm, REC->parent_pid, REC->child_comm, REC->child_pid
+//
+// Fields that can't be parsed are skipped, initFromReader only fails if no
+// field at all could be parsed.
 func (f *format) initFromReader(r io.Reader) error {
 	ctx := formatParseContext{
 		state: stateStart,
 	}
+	f.arch = HostArch
 	scanner := bufio.NewScanner(r)
+	// print fmt lines can be long.
+	scanner.Buffer(make([]byte, 4096), 1024*1024)
 
-	for scanner.Scan() && ctx.state != stateEnd {
-		if err := scanner.Err(); err != nil {
-			return err
-		}
+	var fieldErr error
 
-		line := scanner.Text()
+	for ctx.state != stateEnd && scanner.Scan() {
+		line := strings.TrimRightFunc(scanner.Text(), unicode.IsSpace)
+
+		// The name and ID of the event come before the format section.
+		if ctx.state == stateStart {
+			if strings.HasPrefix(line, "name:") {
+				f.name = strings.TrimSpace(line[len("name:"):])
+				continue
+			}
+			if strings.HasPrefix(line, "ID:") {
+				if err := parseFieldNumber(line, "ID:", &f.id); err != nil {
+					return err
+				}
+				continue
+			}
+		}
 
 		// Scan for /^format:\n$/.
-		if line == "format:" {
+		if strings.TrimSpace(line) == "format:" {
 			if ctx.state != stateStart {
 				return errors.New("format: unexpected format marker")
 			}
@@ -354,21 +542,47 @@ func (f *format) initFromReader(r io.Reader) error {
 			}
 		}
 
+		// Some kernels don't have the empty line before print fmt.
+		if strings.HasPrefix(strings.TrimSpace(line), "print fmt:") {
+			ctx.state = stateEnd
+			continue
+		}
+
 		// Parse a field.
 		if ctx.state == stateCommonFields || ctx.state == stateFields {
 			field := field{}
-			if err := parseField(line, &field); err != nil {
-				return err
+			if err := parseField(strings.TrimSpace(line), &field); err != nil {
+				if fieldErr == nil {
+					fieldErr = err
+				}
+				continue
+			}
+			// Be lenient with formats not separating common fields from the
+			// per-event fields.
+			if ctx.state == stateCommonFields && !strings.HasPrefix(field.name, "common_") {
+				ctx.state = stateFields
 			}
 			f.fields = append(f.fields, field)
+			if ctx.state == stateCommonFields {
+				f.nCommon++
+			}
 		}
 	}
 
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
 	// We couldn't parse any field :/
 	if len(f.fields) == 0 {
+		if fieldErr != nil {
+			return fieldErr
+		}
 		return errors.New("format: no field found")
 	}
 
+	f.resolveCommonFields()
+
 	return nil
 }
 
@@ -391,33 +605,93 @@ func (f *format) findField(name string) *field {
 	return nil
 }
 
-// XXX: if we ever need to support bigEndian machines, this won't be true!
-var nativeEndian = binary.LittleEndian
+func decodeIntInternal(data []byte, field *field, order binary.ByteOrder) (int, error) {
+	if field.offset+field.size > len(data) {
+		return 0, fmt.Errorf("field '%s' is beyond data end", field.name)
+	}
+
+	return decodeIntAt(data[field.offset:], field.size, field.signed, order)
+}
 
-func decodeIntInternal(data []byte, field *field) (int, error) {
-	switch field.size {
+func decodeIntAt(data []byte, size int, signed bool, order binary.ByteOrder) (int, error) {
+	switch size {
 	case 1:
-		if field.signed {
-			return int(int8(data[field.offset])), nil
+		if signed {
+			return int(int8(data[0])), nil
 		}
-		return int(data[field.offset]), nil
+		return int(data[0]), nil
 	case 2:
-		v := nativeEndian.Uint16(data[field.offset : field.offset+2])
-		if field.signed {
+		v := order.Uint16(data[0:2])
+		if signed {
 			return int(int16(v)), nil
 		}
 		return int(v), nil
 	case 4:
-		v := nativeEndian.Uint32(data[field.offset : field.offset+4])
-		if field.signed {
+		v := order.Uint32(data[0:4])
+		if signed {
 			return int(int32(v)), nil
 		}
 		return int(v), nil
 	case 8:
-		v := nativeEndian.Uint64(data[field.offset : field.offset+8])
+		v := order.Uint64(data[0:8])
 		return int(v), nil
 	default:
-		return 0, fmt.Errorf("unexpected field size: %d", field.size)
+		return 0, fmt.Errorf("unexpected field size: %d", size)
+	}
+}
+
+// dynamicData returns the data a __data_loc or __rel_loc field points at.
+func dynamicData(data []byte, field *field, order binary.ByteOrder) ([]byte, error) {
+	v, err := decodeIntInternal(data, field, order)
+	if err != nil {
+		return nil, err
+	}
+
+	// Dynamic fields points at a location in the raw sample data: length is the
+	// upper 16 bytes, offset, the lower 16 bytes. __rel_loc offsets are relative
+	// to the end of the field.
+	loc := uint32(v)
+	length := int(loc >> 16)
+	offset := int(loc & 0xffff)
+	if field.flags&fieldFlagRelative != 0 {
+		offset += field.offset + field.size
+	}
+	if offset+length > len(data) {
+		return nil, fmt.Errorf("dynamic field is beyond data end")
+	}
+
+	return data[offset : offset+length], nil
+}
+
+// isCharType returns true if t is one of the char types.
+func isCharType(t string) bool {
+	t = strings.TrimPrefix(t, "const ")
+	t = strings.TrimPrefix(t, "unsigned ")
+	t = strings.TrimPrefix(t, "signed ")
+	return t == "char"
+}
+
+// typeSize returns the size of the C type t on arch.
+func typeSize(t string, arch *Arch) int {
+	if strings.HasSuffix(t, "*") {
+		return arch.WordSize
+	}
+	t = strings.TrimPrefix(t, "const ")
+	t = strings.TrimPrefix(t, "unsigned ")
+	t = strings.TrimPrefix(t, "signed ")
+	switch t {
+	case "char", "bool", "u8", "s8", "__u8", "__s8":
+		return 1
+	case "short", "u16", "s16", "__u16", "__s16":
+		return 2
+	case "int", "unsigned", "u32", "s32", "__u32", "__s32", "pid_t":
+		return 4
+	case "long long", "u64", "s64", "__u64", "__s64":
+		return 8
+	case "long", "size_t", "ssize_t":
+		return arch.WordSize
+	default:
+		return 0
 	}
 }
 
@@ -427,7 +701,7 @@ func (f *format) decodeInt(data []byte, name string) (int, error) {
 		return 0, fmt.Errorf("no field named '%s'", name)
 	}
 
-	return decodeIntInternal(data, field)
+	return decodeIntInternal(data, field, f.arch.ByteOrder)
 }
 
 func (f *format) decodeString(data []byte, name string) (string, error) {
@@ -436,24 +710,69 @@ func (f *format) decodeString(data []byte, name string) (string, error) {
 		return "", fmt.Errorf("no field named '%s'", name)
 	}
 
+	return decodeStringInternal(data, field, f.arch.ByteOrder)
+}
+
+// cString converts a nul-terminated C string to a Go string.
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i != -1 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+func decodeStringInternal(data []byte, field *field, order binary.ByteOrder) (string, error) {
 	if field.flags&fieldFlagDynamic != 0 {
-		v, err := decodeIntInternal(data, field)
+		v, err := dynamicData(data, field, order)
 		if err != nil {
 			return "", err
 		}
+		// strings are stored nul-terminated.
+		return cString(v), nil
+	}
 
-		// Dynamic fields points at a location in the raw sample data: length is the
-		// upper 16 bytes, offset, the lower 16 bytes.
-		field := uint32(v)
-		length := field >> 16
-		offset := field & 0xffff
-		if int(offset+length) > len(data) {
-			return "", fmt.Errorf("dynamic field is beyond data end")
+	// char arrays, eg. comm.
+	if field.flags&fieldFlagArray != 0 && isCharType(field.typ) {
+		if field.offset+field.size > len(data) {
+			return "", fmt.Errorf("field '%s' is beyond data end", field.name)
 		}
-		// strings are stored nul-terminated. The -1 is to remove it from the Go
-		// string.
-		return string(data[offset : offset+length-1]), nil
+		return cString(data[field.offset : field.offset+field.size]), nil
+	}
+
+	return "", fmt.Errorf("don't know how to decode '%s' as a string", field.name)
+}
+
+func (f *format) decodeInts(data []byte, name string) ([]int, error) {
+	field := f.findField(name)
+	if field == nil {
+		return nil, fmt.Errorf("no field named '%s'", name)
+	}
+	if field.flags&fieldFlagArray == 0 {
+		return nil, fmt.Errorf("'%s' isn't an array", name)
+	}
+
+	size := typeSize(field.typ, &f.arch)
+	if size == 0 {
+		return nil, fmt.Errorf("unknown size of '%s' elements", name)
+	}
+
+	var array []byte
+	if field.flags&fieldFlagDynamic != 0 {
+		var err error
+		if array, err = dynamicData(data, field, f.arch.ByteOrder); err != nil {
+			return nil, err
+		}
+	} else {
+		if field.offset+field.size > len(data) {
+			return nil, fmt.Errorf("field '%s' is beyond data end", name)
+		}
+		array = data[field.offset : field.offset+field.size]
+	}
+
+	ints := make([]int, len(array)/size)
+	for i := range ints {
+		ints[i], _ = decodeIntAt(array[i*size:], size, field.signed, f.arch.ByteOrder)
 	}
 
-	return "", fmt.Errorf("don't know how to decode '%s' as a string", name)
+	return ints, nil
 }
@@ -0,0 +1,47 @@
+//go:build go1.18
+// +build go1.18
+
+package obs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func FuzzInitFromReader(f *testing.F) {
+	f.Add(forkFormat)
+	f.Add(execFormat)
+	f.Add(oddFormat)
+	filepath.Walk("testdata", func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.Name() != "format" {
+			return err
+		}
+		if data, err := ioutil.ReadFile(path); err == nil {
+			f.Add(string(data))
+		}
+		return nil
+	})
+
+	f.Fuzz(func(t *testing.T, input string) {
+		var tf format
+
+		if err := tf.initFromReader(strings.NewReader(input)); err != nil {
+			return
+		}
+		if len(tf.fields) == 0 {
+			t.Fatal("no error but no field either")
+		}
+
+		// Decoding a blob of data must not crash, whatever the format says.
+		data := make([]byte, 64)
+		for i := range tf.fields {
+			name := tf.fields[i].name
+			tf.decodeInt(data, name)
+			tf.decodeString(data, name)
+			tf.decodeInts(data, name)
+		}
+	})
+}
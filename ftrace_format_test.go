@@ -1,6 +1,9 @@
 package obs
 
 import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
@@ -72,6 +75,18 @@ func TestTokenize(t *testing.T) {
 				{"]", tokenTypeOperator},
 				{"filename", tokenTypeIdentifier},
 			},
+		}, {
+			"void (*func)(void *)", Valid, []tokenOutput{
+				{"void", tokenTypeIdentifier},
+				{"(", tokenTypeDelimiter},
+				{"*", tokenTypeOperator},
+				{"func", tokenTypeIdentifier},
+				{")", tokenTypeDelimiter},
+				{"(", tokenTypeDelimiter},
+				{"void", tokenTypeIdentifier},
+				{"*", tokenTypeOperator},
+				{")", tokenTypeDelimiter},
+			},
 		},
 	}
 
@@ -102,8 +117,21 @@ func TestParseFieldTypeName(t *testing.T) {
 		valid    bool
 		expected field
 	}{
-		{"field:unsigned short common_type", Valid, field{name: "common_type", flags: 0}},
-		{"field:__data_loc char[] filename", Valid, field{name: "filename", flags: fieldFlagDynamic | fieldFlagArray}},
+		{"field:unsigned short common_type", Valid, field{name: "common_type", typ: "unsigned short", flags: 0}},
+		{"field:__data_loc char[] filename", Valid, field{name: "filename", typ: "char", flags: fieldFlagDynamic | fieldFlagArray}},
+		{"field:__rel_loc char[] name", Valid, field{name: "name", typ: "char", flags: fieldFlagDynamic | fieldFlagRelative | fieldFlagArray}},
+		{"field:__data_loc unsigned long[] target_cpus", Valid, field{name: "target_cpus", typ: "unsigned long", flags: fieldFlagDynamic | fieldFlagArray}},
+		{"field:char comm[TASK_COMM_LEN]", Valid, field{name: "comm", typ: "char", flags: fieldFlagArray}},
+		{"field:u8 saddr[sizeof(struct sockaddr_in6)]", Valid, field{name: "saddr", typ: "u8", flags: fieldFlagArray}},
+		{"field:const char * reason", Valid, field{name: "reason", typ: "const char *", flags: fieldFlagPointer}},
+		{"field:void (*func)(void *, int (*)(void))", Valid, field{name: "func", typ: "void (*)()", flags: fieldFlagPointer}},
+		{"field special:struct ftrace_graph_ent graph_ent", Valid, field{name: "graph_ent", typ: "struct ftrace_graph_ent"}},
+		{"unsigned short common_type", Invalid, field{}},
+		{"field:", Invalid, field{}},
+		{"field:char *", Invalid, field{}},
+		{"field:void (func)(void)", Invalid, field{}},
+		{"field:void (*func)(void", Invalid, field{}},
+		{"field:int a, b", Invalid, field{}},
 	}
 
 	for _, test := range tests {
@@ -128,12 +156,28 @@ func TestParseField(t *testing.T) {
 	}{
 		{
 			"	field:unsigned short common_type;	offset:4;	size:2;	signed:1;", valid,
-			field{name: "common_type", offset: 4, size: 2, signed: true},
+			field{name: "common_type", typ: "unsigned short", offset: 4, size: 2, signed: true},
 		},
 		{
 			"	field:char parent_comm[16];	offset:8;	size:16;	signed:1;", valid,
-			field{name: "parent_comm", offset: 8, size: 16, signed: true, flags: fieldFlagArray},
+			field{name: "parent_comm", typ: "char", offset: 8, size: 16, signed: true, flags: fieldFlagArray},
+		},
+		// No 'signed' property, the sign is guessed from the type.
+		{
+			"field:unsigned long ip;	offset:8;	size:8;", valid,
+			field{name: "ip", typ: "unsigned long", offset: 8, size: 8, signed: false},
+		},
+		{
+			"field:long prev_state;	offset:32;	size:8;", valid,
+			field{name: "prev_state", typ: "long", offset: 32, size: 8, signed: true},
+		},
+		{
+			"field:void * function;	offset:16;	size:8;", valid,
+			field{name: "function", typ: "void *", offset: 16, size: 8, flags: fieldFlagPointer},
 		},
+		{"field:int foo;	size:4;	signed:1;", invalid, field{}},
+		{"field:int foo;	offset:-4;	size:4;	signed:1;", invalid, field{}},
+		{"field:int foo;	offset:4;	size:four;	signed:1;", invalid, field{}},
 	}
 
 	for _, test := range tests {
@@ -176,14 +220,14 @@ func TestParseFormat(t *testing.T) {
 			input: forkFormat,
 			valid: Valid,
 			expected: []field{
-				field{name: "common_type", offset: 0, size: 2, signed: false},
-				field{name: "common_flags", offset: 2, size: 1, signed: false},
-				field{name: "common_preempt_count", offset: 3, size: 1, signed: false},
-				field{name: "common_pid", offset: 4, size: 4, signed: true},
-				field{name: "parent_comm", offset: 8, size: 16, signed: true, flags: fieldFlagArray},
-				field{name: "parent_pid", offset: 24, size: 4, signed: true},
-				field{name: "child_comm", offset: 28, size: 16, signed: true, flags: fieldFlagArray},
-				field{name: "child_pid", offset: 44, size: 4, signed: true},
+				field{name: "common_type", typ: "unsigned short", offset: 0, size: 2, signed: false},
+				field{name: "common_flags", typ: "unsigned char", offset: 2, size: 1, signed: false},
+				field{name: "common_preempt_count", typ: "unsigned char", offset: 3, size: 1, signed: false},
+				field{name: "common_pid", typ: "int", offset: 4, size: 4, signed: true},
+				field{name: "parent_comm", typ: "char", offset: 8, size: 16, signed: true, flags: fieldFlagArray},
+				field{name: "parent_pid", typ: "pid_t", offset: 24, size: 4, signed: true},
+				field{name: "child_comm", typ: "char", offset: 28, size: 16, signed: true, flags: fieldFlagArray},
+				field{name: "child_pid", typ: "pid_t", offset: 44, size: 4, signed: true},
 			},
 		},
 	}
@@ -245,3 +289,148 @@ func TestDecodeDynamicString(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, bashCmdline, decoded)
 }
+
+func TestParseFormatCommonFields(t *testing.T) {
+	var f format
+
+	assert.Nil(t, f.initFromReader(strings.NewReader(forkFormat)))
+	assert.Equal(t, 4, len(f.commonFields()))
+	assert.Equal(t, "common_pid", f.commonFields()[3].name)
+	assert.Equal(t, 4, len(f.eventFields()))
+	assert.Equal(t, "parent_comm", f.eventFields()[0].name)
+	assert.Equal(t, "common_flags", f.common.flags.name)
+}
+
+// oddFormat has a format marker with trailing spaces, a field we can't parse
+// and no empty line before print fmt.
+const oddFormat = `name: odd
+ID: 42
+format: 
+	field:unsigned short common_type;	offset:0;	size:2;	signed:0;
+	field:unsigned char common_flags;	offset:2;	size:1;	signed:0;
+	field:unsigned char common_preempt_count;	offset:3;	size:1;	signed:0;
+	field:int common_pid;	offset:4;	size:4;	signed:1;
+	field:int odd(;	offset:8;	size:4;	signed:1;
+	field:__rel_loc char[] name;	offset:12;	size:4;	signed:0;
+	field:void (*fn)(void *);	offset:16;	size:8;
+print fmt: "name=%s fn=%p", __get_rel_str(name), REC->fn
+`
+
+func TestParseOddFormat(t *testing.T) {
+	var f format
+
+	assert.Nil(t, f.initFromReader(strings.NewReader(oddFormat)))
+	assert.Equal(t, "odd", f.name)
+	assert.Equal(t, 42, f.id)
+	assert.Equal(t, 4, f.nCommon)
+	assert.Equal(t, []field{
+		{name: "name", typ: "char", offset: 12, size: 4, flags: fieldFlagArray | fieldFlagDynamic | fieldFlagRelative},
+		{name: "fn", typ: "void (*)()", offset: 16, size: 8, flags: fieldFlagPointer},
+	}, f.eventFields())
+}
+
+func TestParseInvalidFormat(t *testing.T) {
+	tests := []string{
+		"",
+		"name: foo\nID: 1\n",
+		"name: foo\nID: 1\nformat:\n\tfield:int odd(;\toffset:8;\tsize:4;\n",
+		"format:\nformat:\n",
+		"name: foo\nID: bar\n",
+	}
+
+	for _, test := range tests {
+		var f format
+		assert.NotNil(t, f.initFromReader(strings.NewReader(test)), test)
+	}
+}
+
+// TestParseCorpus parses format files from various kernel versions.
+func TestParseCorpus(t *testing.T) {
+	n := 0
+	err := filepath.Walk("testdata", func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || info.Name() != "format" {
+			return err
+		}
+		n++
+
+		var f format
+		assert.Nil(t, f.initFromFile(path), path)
+		assert.Equal(t, filepath.Base(filepath.Dir(path)), f.name, path)
+		assert.True(t, f.nCommon >= 4, path)
+		assert.True(t, len(f.eventFields()) > 0, path)
+		assert.NotNil(t, f.common.pid, path)
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.True(t, n > 10)
+}
+
+func TestDecodeRelLoc(t *testing.T) {
+	var f format
+
+	assert.Nil(t, f.initFromReader(strings.NewReader(oddFormat)))
+	f.arch = Arch{ByteOrder: binary.LittleEndian, WordSize: 8}
+
+	// name is stored right after fn, which ends at offset 24: 24 - (12 + 4) = 8.
+	data := make([]byte, 24, 32)
+	binary.LittleEndian.PutUint32(data[12:], 6<<16|8)
+	data = append(data, "hello\x00"...)
+
+	decoded, err := f.decodeString(data, "name")
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", decoded)
+}
+
+var ipiRaiseData = []byte{
+	0x0a, 0x01, 0x00, 0x00, 0xb3, 0x01, 0x00, 0x00, 0x18, 0x00, 0x10, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0xef, 0xbe, 0xad, 0xde, 0x00, 0x00, 0x00, 0x00, 0x05, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x80, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+}
+
+func TestDecodeInts(t *testing.T) {
+	var f format
+
+	assert.Nil(t, f.initFromFile("testdata/formats/4.19/ipi/ipi_raise/format"))
+	f.arch = Arch{ByteOrder: binary.LittleEndian, WordSize: 8}
+
+	// Dynamic bitmask, 2 unsigned longs.
+	cpus, err := f.decodeInts(ipiRaiseData, "target_cpus")
+	assert.Nil(t, err)
+	assert.Equal(t, []int{5, 0x80}, cpus)
+
+	// Not an array.
+	_, err = f.decodeInts(ipiRaiseData, "reason")
+	assert.NotNil(t, err)
+
+	// The same data, recorded on a 32-bit machine, is 4 unsigned longs.
+	f.arch.WordSize = 4
+	cpus, err = f.decodeInts(ipiRaiseData, "target_cpus")
+	assert.Nil(t, err)
+	assert.Equal(t, []int{5, 0, 0x80, 0}, cpus)
+}
+
+func TestDecodeCharArray(t *testing.T) {
+	var f format
+
+	assert.Nil(t, f.initFromReader(strings.NewReader(forkFormat)))
+	data := make([]byte, 48)
+	copy(data[28:], "bash\x00garbage")
+
+	decoded, err := f.decodeString(data, "child_comm")
+	assert.Nil(t, err)
+	assert.Equal(t, "bash", decoded)
+
+	_, err = f.decodeString(data, "child_pid")
+	assert.NotNil(t, err)
+}
+
+func TestDecodeShortData(t *testing.T) {
+	var f format
+
+	assert.Nil(t, f.initFromReader(strings.NewReader(execFormat)))
+
+	_, err := f.decodeInt(execData[:14], "pid")
+	assert.NotNil(t, err)
+	_, err = f.decodeString(execData[:24], "filename")
+	assert.NotNil(t, err)
+}
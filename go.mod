@@ -3,6 +3,8 @@ module github.com/dlespiau/obs
 go 1.12
 
 require (
+	github.com/pkg/errors v0.9.1
 	github.com/stretchr/testify v1.3.0
+	github.com/ulikunitz/xz v0.5.12
 	golang.org/x/sys v0.0.0-20190318195719-6c81ef8f67ca
 )
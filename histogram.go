@@ -0,0 +1,227 @@
+package obs
+
+import (
+	"bytes"
+	"fmt"
+	"math/bits"
+	"strings"
+)
+
+// histogramBuckets is the number of buckets of a Histogram: one bucket for 0
+// and one bucket per power of 2.
+const histogramBuckets = 65
+
+// Histogram is a log2 histogram: values are counted in buckets which bounds
+// are powers of 2, bucket n counting the values in [2^(n-1), 2^n). It's
+// cheap enough to record every scheduling event of a host while keeping a
+// precision good enough for latencies.
+//
+// The zero value is an empty histogram ready to use.
+type Histogram struct {
+	buckets [histogramBuckets]uint64
+	count   uint64
+	sum     uint64
+	min     uint64
+	max     uint64
+}
+
+// HistogramBucket is a non-empty bucket of a Histogram.
+type HistogramBucket struct {
+	// Low and High are the bounds of the bucket, High being excluded.
+	// High is 0 for the last bucket, which has no upper bound.
+	Low, High uint64
+	// Count is the number of values in the bucket.
+	Count uint64
+}
+
+// histogramBucket returns the index of the bucket v belongs to.
+func histogramBucket(v uint64) int {
+	return bits.Len64(v)
+}
+
+// bucketBounds returns the bounds of the bucket i, see HistogramBucket.
+func bucketBounds(i int) (low, high uint64) {
+	if i == 0 {
+		return 0, 1
+	}
+	low = 1 << uint(i-1)
+	if i < histogramBuckets-1 {
+		high = 1 << uint(i)
+	}
+	return
+}
+
+// Add records the value v.
+func (h *Histogram) Add(v uint64) {
+	h.buckets[histogramBucket(v)]++
+	if h.count == 0 || v < h.min {
+		h.min = v
+	}
+	if v > h.max {
+		h.max = v
+	}
+	h.count++
+	h.sum += v
+}
+
+// Merge adds the values recorded by other to h.
+func (h *Histogram) Merge(other *Histogram) {
+	if other.count == 0 {
+		return
+	}
+	for i, n := range other.buckets {
+		h.buckets[i] += n
+	}
+	if h.count == 0 || other.min < h.min {
+		h.min = other.min
+	}
+	if other.max > h.max {
+		h.max = other.max
+	}
+	h.count += other.count
+	h.sum += other.sum
+}
+
+// Reset empties the histogram.
+func (h *Histogram) Reset() {
+	*h = Histogram{}
+}
+
+// Count returns the number of values recorded.
+func (h *Histogram) Count() uint64 {
+	return h.count
+}
+
+// Sum returns the sum of the values recorded.
+func (h *Histogram) Sum() uint64 {
+	return h.sum
+}
+
+// Min returns the smallest value recorded, 0 if the histogram is empty.
+func (h *Histogram) Min() uint64 {
+	return h.min
+}
+
+// Max returns the largest value recorded, 0 if the histogram is empty.
+func (h *Histogram) Max() uint64 {
+	return h.max
+}
+
+// Mean returns the mean of the values recorded, 0 if the histogram is empty.
+func (h *Histogram) Mean() uint64 {
+	if h.count == 0 {
+		return 0
+	}
+	return h.sum / h.count
+}
+
+// Percentile returns an estimation of the p-th percentile, p being between 0
+// and 100. The values are assumed to be evenly spread in their bucket, the
+// estimation is always within the bounds of the bucket the percentile falls
+// into, and within the smallest and largest values recorded.
+func (h *Histogram) Percentile(p float64) uint64 {
+	if h.count == 0 {
+		return 0
+	}
+	if p <= 0 {
+		return h.min
+	}
+	if p >= 100 {
+		return h.max
+	}
+
+	rank := p / 100 * float64(h.count)
+	var seen uint64
+	for i, n := range h.buckets {
+		if n == 0 || float64(seen+n) < rank {
+			seen += n
+			continue
+		}
+
+		low, high := bucketBounds(i)
+		if low < h.min {
+			low = h.min
+		}
+		if high == 0 || high > h.max {
+			high = h.max
+		}
+		v := low + uint64(float64(high-low)*(rank-float64(seen))/float64(n))
+		if v > h.max {
+			v = h.max
+		}
+		return v
+	}
+
+	return h.max
+}
+
+// Buckets returns the buckets from the first to the last non-empty ones.
+func (h *Histogram) Buckets() []HistogramBucket {
+	first, last := -1, -1
+	for i, n := range h.buckets {
+		if n == 0 {
+			continue
+		}
+		if first == -1 {
+			first = i
+		}
+		last = i
+	}
+	if first == -1 {
+		return nil
+	}
+
+	buckets := make([]HistogramBucket, 0, last-first+1)
+	for i := first; i <= last; i++ {
+		low, high := bucketBounds(i)
+		buckets = append(buckets, HistogramBucket{
+			Low:   low,
+			High:  high,
+			Count: h.buckets[i],
+		})
+	}
+	return buckets
+}
+
+// histogramBarWidth is the width of the largest bar drawn by String.
+const histogramBarWidth = 40
+
+// String draws the histogram, one line per bucket:
+//
+//	[512, 1K)            12 |@@@@                                    |
+//	[1K, 2K)            103 |@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@|
+func (h *Histogram) String() string {
+	buckets := h.Buckets()
+
+	var largest uint64
+	for _, b := range buckets {
+		if b.Count > largest {
+			largest = b.Count
+		}
+	}
+
+	var buf bytes.Buffer
+	for _, b := range buckets {
+		high := "..."
+		if b.High != 0 {
+			high = formatPower2(b.High)
+		}
+		width := int(b.Count * histogramBarWidth / largest)
+		fmt.Fprintf(&buf, "%-16s %8d |%-*s|\n",
+			"["+formatPower2(b.Low)+", "+high+")", b.Count,
+			histogramBarWidth, strings.Repeat("@", width))
+	}
+	return buf.String()
+}
+
+// formatPower2 formats the bucket bound v with the K, M, G... suffixes, which
+// are powers of 1024.
+func formatPower2(v uint64) string {
+	suffixes := []string{"", "K", "M", "G", "T", "P", "E"}
+	i := 0
+	for v >= 1024 && v%1024 == 0 {
+		v /= 1024
+		i++
+	}
+	return fmt.Sprintf("%d%s", v, suffixes[i])
+}
@@ -0,0 +1,82 @@
+package obs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistogram(t *testing.T) {
+	var h Histogram
+	assert.Equal(t, uint64(0), h.Percentile(50))
+	assert.Nil(t, h.Buckets())
+
+	for _, v := range []uint64{0, 1, 3, 5, 6, 7, 100} {
+		h.Add(v)
+	}
+
+	assert.Equal(t, uint64(7), h.Count())
+	assert.Equal(t, uint64(122), h.Sum())
+	assert.Equal(t, uint64(0), h.Min())
+	assert.Equal(t, uint64(100), h.Max())
+	assert.Equal(t, uint64(17), h.Mean())
+	assert.Equal(t, []HistogramBucket{
+		{Low: 0, High: 1, Count: 1},
+		{Low: 1, High: 2, Count: 1},
+		{Low: 2, High: 4, Count: 1},
+		{Low: 4, High: 8, Count: 3},
+		{Low: 8, High: 16, Count: 0},
+		{Low: 16, High: 32, Count: 0},
+		{Low: 32, High: 64, Count: 0},
+		{Low: 64, High: 128, Count: 1},
+	}, h.Buckets())
+
+	tests := []struct {
+		p        float64
+		expected uint64
+	}{
+		{0, 0},
+		{10, 0},
+		{50, 4},
+		{99, 97},
+		{100, 100},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, h.Percentile(test.p), "p%v", test.p)
+	}
+}
+
+func TestHistogramMerge(t *testing.T) {
+	var a, b, empty Histogram
+	a.Add(10)
+	b.Add(2)
+	b.Add(1000)
+
+	a.Merge(&empty)
+	assert.Equal(t, uint64(1), a.Count())
+
+	a.Merge(&b)
+	assert.Equal(t, uint64(3), a.Count())
+	assert.Equal(t, uint64(1012), a.Sum())
+	assert.Equal(t, uint64(2), a.Min())
+	assert.Equal(t, uint64(1000), a.Max())
+
+	a.Reset()
+	assert.Equal(t, uint64(0), a.Count())
+}
+
+func TestHistogramString(t *testing.T) {
+	var h Histogram
+	h.Add(600)
+	for i := 0; i < 4; i++ {
+		h.Add(1500)
+	}
+
+	assert.Equal(t, `[512, 1K)               1 |@@@@@@@@@@                              |
+[1K, 2K)                4 |@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@|
+`, h.String())
+
+	h.Reset()
+	h.Add(1 << 63)
+	assert.Equal(t, "[8E, ...)               1 |@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@|\n", h.String())
+}
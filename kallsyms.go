@@ -0,0 +1,300 @@
+package obs
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	kallsymsPath = "/proc/kallsyms"
+	modulesPath  = "/proc/modules"
+)
+
+// KernelSymbol is a symbol of the running kernel or of one of its modules.
+type KernelSymbol struct {
+	// Address is the address of the symbol.
+	Address uint64
+	// Type is the symbol type, as displayed by nm, eg. 'T' for a global
+	// symbol in the text section or 't' for a local one.
+	Type byte
+	// Name is the symbol name.
+	Name string
+	// Module is the name of the module the symbol belongs to, "" for the
+	// kernel image.
+	Module string
+}
+
+// KernelSymbols resolves kernel addresses to symbols.
+type KernelSymbols struct {
+	// symbols is sorted by address.
+	symbols []KernelSymbol
+	// start is the address of the kernel image, text and end the end of its
+	// text section and of the image. They are 0 when unknown.
+	start, text, end uint64
+	// modules are the end addresses of the modules, indexed by name.
+	modules map[string]uint64
+	// restricted is true when the kernel has hidden the addresses.
+	restricted bool
+}
+
+// ReadKernelSymbols reads the kernel symbols from /proc/kallsyms and the
+// address ranges of the modules from /proc/modules.
+//
+// Reading the addresses of the symbols is usually restricted to privileged
+// users by the kernel.kptr_restrict sysctl. When addresses are hidden, no
+// address can be resolved and Restricted returns true.
+func ReadKernelSymbols() (*KernelSymbols, error) {
+	f, err := os.Open(kallsymsPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	k, err := ParseKernelSymbols(f)
+	if err != nil {
+		return nil, err
+	}
+
+	// Kernels built without module support don't have /proc/modules.
+	m, err := os.Open(modulesPath)
+	if os.IsNotExist(err) {
+		return k, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer m.Close()
+
+	if k.modules, err = parseModules(m); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// parseModules returns the end addresses of the modules listed in the
+// /proc/modules format:
+//
+//	foo 16384 0 - Live 0xffffffffc0a01000
+func parseModules(r io.Reader) (map[string]uint64, error) {
+	modules := make(map[string]uint64)
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 6 {
+			return nil, fmt.Errorf("modules: invalid line '%s'", line)
+		}
+		size, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("modules: invalid size '%s'", fields[1])
+		}
+		addr, err := strconv.ParseUint(strings.TrimPrefix(fields[5], "0x"), 16, 64)
+		if err != nil {
+			return nil, fmt.Errorf("modules: invalid address '%s'", fields[5])
+		}
+		// Zeroed addresses are hidden by kptr_restrict.
+		if addr == 0 {
+			continue
+		}
+		modules[fields[0]] = addr + size
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return modules, nil
+}
+
+// ParseKernelSymbols parses symbols in the /proc/kallsyms format:
+//
+//	ffffffff81000000 T _stext
+//	ffffffffc0a01000 t foo_init	[foo]
+func ParseKernelSymbols(r io.Reader) (*KernelSymbols, error) {
+	k := &KernelSymbols{}
+	n := 0
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 3 || len(fields[1]) != 1 {
+			return nil, fmt.Errorf("kallsyms: invalid line '%s'", line)
+		}
+		addr, err := strconv.ParseUint(fields[0], 16, 64)
+		if err != nil {
+			return nil, fmt.Errorf("kallsyms: invalid address '%s'", fields[0])
+		}
+		n++
+
+		if len(fields) == 3 && addr != 0 {
+			switch fields[2] {
+			case "_text", "_stext":
+				if k.start == 0 || addr < k.start {
+					k.start = addr
+				}
+			case "_etext":
+				k.text = addr
+			case "_end":
+				k.end = addr
+			}
+		}
+
+		// Absolute symbols aren't addresses, eg. per-cpu variables are
+		// offsets. Zeroed addresses are hidden by kptr_restrict.
+		typ := fields[1][0]
+		if typ == 'a' || typ == 'A' || addr == 0 {
+			continue
+		}
+
+		sym := KernelSymbol{
+			Address: addr,
+			Type:    typ,
+			Name:    fields[2],
+		}
+		if len(fields) > 3 {
+			sym.Module = strings.Trim(fields[3], "[]")
+		}
+		k.symbols = append(k.symbols, sym)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	k.restricted = n > 0 && len(k.symbols) == 0
+	k.index()
+
+	return k, nil
+}
+
+// isGlobal returns true for global symbols, which nm types are upper case.
+func (s *KernelSymbol) isGlobal() bool {
+	return s.Type >= 'A' && s.Type <= 'Z'
+}
+
+// preferSymbol returns true if a is a better name than b for their address:
+// global symbols are preferred to local ones, then names with fewer leading
+// underscores.
+func preferSymbol(a, b *KernelSymbol) bool {
+	if a.isGlobal() != b.isGlobal() {
+		return a.isGlobal()
+	}
+	return leadingUnderscores(a.Name) < leadingUnderscores(b.Name)
+}
+
+// leadingUnderscores returns the number of '_' name starts with. Internal
+// symbols have more of them than their public aliases.
+func leadingUnderscores(name string) int {
+	return len(name) - len(strings.TrimLeft(name, "_"))
+}
+
+// index sorts the symbols by address, only keeping the preferred symbol when
+// several symbols share an address.
+func (k *KernelSymbols) index() {
+	sort.SliceStable(k.symbols, func(i, j int) bool {
+		return k.symbols[i].Address < k.symbols[j].Address
+	})
+
+	symbols := k.symbols[:0]
+	for i := range k.symbols {
+		sym := &k.symbols[i]
+		last := len(symbols) - 1
+		if last >= 0 && symbols[last].Address == sym.Address {
+			if preferSymbol(sym, &symbols[last]) {
+				symbols[last] = *sym
+			}
+			continue
+		}
+		symbols = append(symbols, *sym)
+	}
+	k.symbols = symbols
+}
+
+// Restricted returns true if the kernel has hidden the symbol addresses, see
+// the kernel.kptr_restrict sysctl.
+func (k *KernelSymbols) Restricted() bool {
+	return k.restricted
+}
+
+// Len returns the number of symbols addresses can be resolved to.
+func (k *KernelSymbols) Len() int {
+	return len(k.symbols)
+}
+
+// symbolEnd returns the end address of the symbol i: the address of the next
+// symbol, bounded by the end of the text section, of the kernel image or of
+// the module. The last symbol of a region which end isn't known only covers
+// its address.
+func (k *KernelSymbols) symbolEnd(i int) uint64 {
+	sym := &k.symbols[i]
+
+	var end uint64
+	if sym.Module == "" {
+		if sym.Address < k.text {
+			end = k.text
+		} else if sym.Address < k.end {
+			end = k.end
+		}
+	} else if e := k.modules[sym.Module]; sym.Address < e {
+		end = e
+	}
+
+	if i+1 < len(k.symbols) {
+		next := &k.symbols[i+1]
+		if next.Module == sym.Module && (end == 0 || next.Address < end) {
+			end = next.Address
+		}
+	}
+	if end == 0 {
+		end = sym.Address + 1
+	}
+	return end
+}
+
+// Lookup returns the symbol addr belongs to and the offset of addr in that
+// symbol. Lookup returns nil if addr isn't a kernel address, eg. a user space
+// address, or is past the end of the symbols it follows.
+func (k *KernelSymbols) Lookup(addr uint64) (*KernelSymbol, uint64) {
+	if addr < k.start {
+		return nil, 0
+	}
+	i := sort.Search(len(k.symbols), func(i int) bool {
+		return k.symbols[i].Address > addr
+	})
+	if i == 0 || addr >= k.symbolEnd(i-1) {
+		return nil, 0
+	}
+
+	sym := &k.symbols[i-1]
+	return sym, addr - sym.Address
+}
+
+// Symbolize returns a human readable representation of addr, eg.
+// "do_sys_open+0x1a" or "foo_init+0x10 [foo]" for module symbols. Addresses
+// that can't be resolved are returned in hexadecimal.
+func (k *KernelSymbols) Symbolize(addr uint64) string {
+	sym, offset := k.Lookup(addr)
+	if sym == nil {
+		return fmt.Sprintf("%#x", addr)
+	}
+
+	s := sym.Name
+	if offset != 0 {
+		s += fmt.Sprintf("+%#x", offset)
+	}
+	if sym.Module != "" {
+		s += " [" + sym.Module + "]"
+	}
+	return s
+}
@@ -0,0 +1,137 @@
+package obs
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testKallsyms = `0000000000000000 A fixed_percpu_data
+ffffffff81000000 T _stext
+ffffffff81000000 t __pi__text
+ffffffff81000000 T _text
+ffffffff81001000 T do_sys_open
+ffffffff81001100 t do_sys_openat2
+ffffffff81002000 T kmem_cache_alloc
+ffffffff81003000 T _etext
+ffffffffc0a01000 t foo_init	[foo]
+ffffffffc0a01080 T foo_exit	[foo]
+`
+
+const testKallsymsRestricted = `0000000000000000 T _stext
+0000000000000000 T do_sys_open
+0000000000000000 t foo_init	[foo]
+`
+
+func TestParseKernelSymbols(t *testing.T) {
+	k, err := ParseKernelSymbols(strings.NewReader(testKallsyms))
+	assert.Nil(t, err)
+	assert.False(t, k.Restricted())
+	assert.Equal(t, 7, k.Len())
+
+	tests := []struct {
+		addr     uint64
+		found    bool
+		expected string
+	}{
+		{0xffffffff81000000, true, "_stext"},
+		{0xffffffff81000010, true, "_stext+0x10"},
+		{0xffffffff81001000, true, "do_sys_open"},
+		{0xffffffff8100101a, true, "do_sys_open+0x1a"},
+		{0xffffffff81001104, true, "do_sys_openat2+0x4"},
+		{0xffffffffc0a01010, true, "foo_init+0x10 [foo]"},
+		{0xffffffffc0a01080, true, "foo_exit [foo]"},
+		{0x401000, false, "0x401000"},
+		// User space addresses.
+		{0x7ffd12345678, false, "0x7ffd12345678"},
+		// Past the end of the text section and of the image.
+		{0xffffffff81002fff, true, "kmem_cache_alloc+0xfff"},
+		{0xffffffff81003010, false, "0xffffffff81003010"},
+		{0xffffffff90000000, false, "0xffffffff90000000"},
+		// The end of the foo module isn't known.
+		{0xffffffffc0a01090, false, "0xffffffffc0a01090"},
+	}
+
+	for _, test := range tests {
+		sym, _ := k.Lookup(test.addr)
+		assert.Equal(t, test.found, sym != nil)
+		assert.Equal(t, test.expected, k.Symbolize(test.addr))
+	}
+
+	sym, offset := k.Lookup(0xffffffffc0a01010)
+	assert.Equal(t, &KernelSymbol{Address: 0xffffffffc0a01000, Type: 't', Name: "foo_init", Module: "foo"}, sym)
+	assert.Equal(t, uint64(0x10), offset)
+}
+
+func TestKernelSymbolsModules(t *testing.T) {
+	k, err := ParseKernelSymbols(strings.NewReader(testKallsyms))
+	assert.Nil(t, err)
+	k.modules, err = parseModules(strings.NewReader("foo 4096 0 - Live 0xffffffffc0a01000\n" +
+		"bar 8192 1 foo, Live 0x0000000000000000\n"))
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]uint64{"foo": 0xffffffffc0a02000}, k.modules)
+
+	assert.Equal(t, "foo_exit+0x10 [foo]", k.Symbolize(0xffffffffc0a01090))
+	assert.Equal(t, "foo_exit+0xf7f [foo]", k.Symbolize(0xffffffffc0a01fff))
+	assert.Equal(t, "0xffffffffc0a02000", k.Symbolize(0xffffffffc0a02000))
+
+	_, err = parseModules(strings.NewReader("foo 4096\n"))
+	assert.NotNil(t, err)
+}
+
+func TestParseKernelSymbolsRestricted(t *testing.T) {
+	k, err := ParseKernelSymbols(strings.NewReader(testKallsymsRestricted))
+	assert.Nil(t, err)
+	assert.True(t, k.Restricted())
+	assert.Equal(t, 0, k.Len())
+	assert.Equal(t, "0xffffffff81001000", k.Symbolize(0xffffffff81001000))
+}
+
+func TestParseKernelSymbolsInvalid(t *testing.T) {
+	tests := []string{
+		"ffffffff81000000 T\n",
+		"ffffffff81000000 TT foo\n",
+		"zzzz T foo\n",
+	}
+
+	for _, test := range tests {
+		_, err := ParseKernelSymbols(strings.NewReader(test))
+		assert.NotNil(t, err, test)
+	}
+}
+
+func TestReadKernelSymbols(t *testing.T) {
+	k, err := ReadKernelSymbols()
+	if err != nil {
+		t.Skipf("unable to read kernel symbols: %v", err)
+	}
+	if k.Restricted() {
+		t.Skip("kernel symbol addresses are restricted")
+	}
+	assert.True(t, k.Len() > 0)
+}
+
+const kmallocFormat = `name: kmalloc
+ID: 500
+format:
+	field:unsigned short common_type;	offset:0;	size:2;	signed:0;
+	field:unsigned char common_flags;	offset:2;	size:1;	signed:0;
+	field:unsigned char common_preempt_count;	offset:3;	size:1;	signed:0;
+	field:int common_pid;	offset:4;	size:4;	signed:1;
+
+	field:unsigned long call_site;	offset:8;	size:8;	signed:0;
+	field:const void * ptr;	offset:16;	size:8;	signed:0;
+`
+
+func TestGetKernelSymbol(t *testing.T) {
+	k, err := ParseKernelSymbols(strings.NewReader(testKallsyms))
+	assert.Nil(t, err)
+
+	var data sampleBuilder
+	data.u64(0).u64(0xffffffff81002042).u64(0xffff888100000000)
+	_, e := newTestEvent(t, kmallocFormat, data)
+
+	assert.Equal(t, "kmem_cache_alloc+0x42", e.GetKernelSymbol("call_site", k))
+	assert.Equal(t, "", e.GetKernelSymbol("foo", k))
+}
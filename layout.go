@@ -0,0 +1,141 @@
+package obs
+
+import (
+	"fmt"
+	"io"
+)
+
+// FieldLayout describes a tracepoint field: where it is in the raw data and
+// how to interpret it.
+type FieldLayout struct {
+	// Name is the field name.
+	Name string
+	// Type is the C type of the field, without the array or __data_loc
+	// qualifiers.
+	Type string
+	// Offset is the position of the field in the raw data, in bytes.
+	Offset int
+	// Size is the size of the field, in bytes.
+	Size int
+	// Signed is true for signed integers.
+	Signed bool
+	// Array is true for both fixed size and dynamic arrays.
+	Array bool
+	// Dynamic is true for __data_loc and __rel_loc fields. The field is then a
+	// 32-bit integer pointing at the actual data further in the raw data.
+	Dynamic bool
+	// Relative is true for __rel_loc fields, which offset is relative to the
+	// end of the field.
+	Relative bool
+}
+
+// TracepointFormat describes the raw data of a tracepoint, as found in its
+// format file.
+type TracepointFormat struct {
+	// Name is the event name, without the subsystem.
+	Name string
+	// ID is the perf event ID of the tracepoint.
+	ID int
+	// Fields is the list of fields, common fields included.
+	Fields []FieldLayout
+
+	format *format
+}
+
+// ParseTracepointFormat parses a tracepoint format file.
+func ParseTracepointFormat(r io.Reader) (*TracepointFormat, error) {
+	var f format
+
+	if err := f.initFromReader(r); err != nil {
+		return nil, err
+	}
+
+	return f.export(), nil
+}
+
+// ReadTracepointFormat reads the format of the tracepoint name from the running
+// kernel. name is the tracepoint name as listed by:
+//
+//   $ sudo perf list tracepoint
+func ReadTracepointFormat(name string) (*TracepointFormat, error) {
+	var f format
+
+	if err := f.initFromFile(tracepointPath(name) + "/format"); err != nil {
+		return nil, err
+	}
+
+	return f.export(), nil
+}
+
+func (f *field) export() FieldLayout {
+	return FieldLayout{
+		Name:     f.name,
+		Type:     f.typ,
+		Offset:   f.offset,
+		Size:     f.size,
+		Signed:   f.signed,
+		Array:    f.flags&fieldFlagArray != 0,
+		Dynamic:  f.flags&fieldFlagDynamic != 0,
+		Relative: f.flags&fieldFlagRelative != 0,
+	}
+}
+
+func (f *format) export() *TracepointFormat {
+	tf := &TracepointFormat{
+		Name:   f.name,
+		ID:     f.id,
+		Fields: make([]FieldLayout, len(f.fields)),
+		format: f,
+	}
+	for i := range f.fields {
+		tf.Fields[i] = f.fields[i].export()
+	}
+	return tf
+}
+
+// Event wraps raw tracepoint data, recorded on a machine described by arch, in
+// a TracepointEvent. This allows decoding the fields of tracepoint data saved
+// on another machine, possibly of a different architecture.
+func (f *TracepointFormat) Event(data []byte, arch Arch) *TracepointEvent {
+	tp := &tracepoint{
+		Name:   f.Name,
+		format: *f.format,
+	}
+	tp.format.arch = arch
+
+	return &TracepointEvent{
+		tp:   tp,
+		data: data,
+	}
+}
+
+// checkLayout ensures the fields listed in layout are found in f with the same
+// offset, size, sign and kind.
+func (f *format) checkLayout(layout []FieldLayout) error {
+	for i := range layout {
+		expected := &layout[i]
+		field := f.findField(expected.Name)
+		if field == nil {
+			return fmt.Errorf("layout: no field named '%s'", expected.Name)
+		}
+		got := field.export()
+		for _, attr := range []struct {
+			name          string
+			expected, got interface{}
+		}{
+			{"offset", expected.Offset, got.Offset},
+			{"size", expected.Size, got.Size},
+			{"signed", expected.Signed, got.Signed},
+			{"array", expected.Array, got.Array},
+			{"dynamic", expected.Dynamic, got.Dynamic},
+			{"relative", expected.Relative, got.Relative},
+		} {
+			if attr.expected != attr.got {
+				return fmt.Errorf("layout: field '%s' changed: expected %s:%v, got %s:%v",
+					expected.Name, attr.name, attr.expected, attr.name, attr.got)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,54 @@
+package obs
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTracepointFormat(t *testing.T) {
+	f, err := ParseTracepointFormat(strings.NewReader(execFormat))
+	assert.Nil(t, err)
+	assert.Equal(t, "sched_process_exec", f.Name)
+	assert.Equal(t, 266, f.ID)
+	assert.Equal(t, 7, len(f.Fields))
+	assert.Equal(t, FieldLayout{
+		Name: "filename", Type: "char", Offset: 8, Size: 4, Signed: true,
+		Array: true, Dynamic: true,
+	}, f.Fields[4])
+}
+
+func TestCheckLayout(t *testing.T) {
+	tests := []struct {
+		layout []FieldLayout
+		valid  bool
+	}{
+		{[]FieldLayout{{Name: "pid", Offset: 12, Size: 4, Signed: true}}, Valid},
+		{[]FieldLayout{{Name: "filename", Offset: 8, Size: 4, Signed: true, Array: true, Dynamic: true}}, Valid},
+		{[]FieldLayout{{Name: "pid", Offset: 16, Size: 4, Signed: true}}, Invalid},
+		{[]FieldLayout{{Name: "pid", Offset: 12, Size: 8, Signed: true}}, Invalid},
+		{[]FieldLayout{{Name: "pid", Offset: 12, Size: 4, Signed: false}}, Invalid},
+		{[]FieldLayout{{Name: "filename", Offset: 8, Size: 4, Signed: true, Array: true}}, Invalid},
+		{[]FieldLayout{{Name: "filename", Offset: 8, Size: 4, Signed: true, Dynamic: true}}, Invalid},
+		{[]FieldLayout{{Name: "filename", Offset: 8, Size: 4, Signed: true, Array: true, Dynamic: true, Relative: true}}, Invalid},
+		{[]FieldLayout{{Name: "pid", Offset: 12, Size: 4, Signed: true, Array: true}}, Invalid},
+		{[]FieldLayout{{Name: "nope", Offset: 12, Size: 4}}, Invalid},
+	}
+
+	var f format
+	assert.Nil(t, f.initFromReader(strings.NewReader(execFormat)))
+
+	for _, test := range tests {
+		err := f.checkLayout(test.layout)
+		assert.Equal(t, test.valid, err == nil, "%v", test.layout)
+	}
+}
+
+func TestCheckLayoutError(t *testing.T) {
+	var f format
+	assert.Nil(t, f.initFromReader(strings.NewReader(execFormat)))
+
+	err := f.checkLayout([]FieldLayout{{Name: "pid", Offset: 12, Size: 4, Signed: true, Array: true}})
+	assert.EqualError(t, err, "layout: field 'pid' changed: expected array:true, got array:false")
+}
@@ -0,0 +1,107 @@
+package obs
+
+import (
+	"os"
+	"sort"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// ioctls of the namespace files, see ioctl_ns(2).
+const (
+	nsGetUserNS = 0xb701
+	nsGetParent = 0xb702
+)
+
+// nsInode returns the inode number of the namespace file f.
+func nsInode(f *os.File) (uint64, error) {
+	var stat unix.Stat_t
+	if err := unix.Fstat(int(f.Fd()), &stat); err != nil {
+		return 0, os.NewSyscallError("fstat", err)
+	}
+	return stat.Ino, nil
+}
+
+// nsIoctl issues the namespace ioctl req on f, returning the namespace file
+// it refers to.
+func nsIoctl(f *os.File, req uintptr) (*os.File, error) {
+	fd, _, errno := unix.Syscall(unix.SYS_IOCTL, f.Fd(), req, 0)
+	if errno != 0 {
+		return nil, os.NewSyscallError("ioctl", errno)
+	}
+	return os.NewFile(fd, f.Name()), nil
+}
+
+// NamespaceInfo describes a namespace and the processes in it.
+type NamespaceInfo struct {
+	// Kind is the type of namespace.
+	Kind NamespaceKind
+	// Inode is the inode of the namespace.
+	Inode uint64
+	// Owner is the inode of the user namespace owning the namespace. For
+	// user namespaces, it's the parent user namespace. Owner is 0 when the
+	// owner is outside of the user namespace of the caller or for the
+	// initial user namespace.
+	Owner uint64
+	// Processes are the processes in the namespace, sorted by PID.
+	Processes []*Process
+}
+
+// nsOwner returns the inode of the user namespace owning the namespace kind of
+// p.
+func nsOwner(p *Process, kind NamespaceKind) (uint64, error) {
+	f, err := os.Open(p.procPath("ns/" + nsProcFiles[kind]))
+	if err != nil {
+		return 0, p.error(err)
+	}
+	defer f.Close()
+
+	owner, err := nsIoctl(f, nsGetUserNS)
+	if err != nil {
+		return 0, err
+	}
+	defer owner.Close()
+
+	return nsInode(owner)
+}
+
+// ListNamespaces returns the namespaces of type kind in use on the system,
+// sorted by inode. Processes we can't inspect are skipped.
+func ListNamespaces(kind NamespaceKind) ([]NamespaceInfo, error) {
+	if !kind.valid() {
+		return nil, errors.Errorf("list namespaces: invalid kind %d", int(kind))
+	}
+	processes, err := ListProcesses()
+	if err != nil {
+		return nil, errors.Wrap(err, "list namespaces")
+	}
+
+	namespaces := make(map[uint64]*NamespaceInfo)
+	for _, p := range processes {
+		ns, err := p.Namespace(kind)
+		if err != nil {
+			continue
+		}
+		info, ok := namespaces[ns]
+		if !ok {
+			info = &NamespaceInfo{
+				Kind:  kind,
+				Inode: ns,
+			}
+			// EPERM is returned when the owner is out of reach.
+			info.Owner, _ = nsOwner(p, kind)
+			namespaces[ns] = info
+		}
+		info.Processes = append(info.Processes, p)
+	}
+
+	list := make([]NamespaceInfo, 0, len(namespaces))
+	for _, info := range namespaces {
+		list = append(list, *info)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Inode < list[j].Inode
+	})
+	return list, nil
+}
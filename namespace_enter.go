@@ -0,0 +1,218 @@
+package obs
+
+import (
+	"os"
+	"runtime"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// cloneNewTime is CLONE_NEWTIME, missing from x/sys.
+const cloneNewTime = 0x80
+
+// nsCloneFlags are the CLONE_NEW* flags of the namespace kinds.
+var nsCloneFlags = []int{
+	NetworkNS: unix.CLONE_NEWNET,
+	IPCNS:     unix.CLONE_NEWIPC,
+	UTSNS:     unix.CLONE_NEWUTS,
+	MountNS:   unix.CLONE_NEWNS,
+	PIDNS:     unix.CLONE_NEWPID,
+	UserNS:    unix.CLONE_NEWUSER,
+	CgroupNS:  unix.CLONE_NEWCGROUP,
+	TimeNS:    cloneNewTime,
+}
+
+// nsSwitch is a namespace to enter and the namespace to restore.
+type nsSwitch struct {
+	kind     NamespaceKind
+	target   *os.File
+	original *os.File
+}
+
+func (s *nsSwitch) close() {
+	s.target.Close()
+	s.original.Close()
+}
+
+// Enter runs fn in the namespaces kinds of the process.
+//
+// fn runs on a dedicated OS thread which joins the namespaces of the process
+// with setns(2), and goes back to its original namespaces when fn returns.
+// Goroutines started by fn don't run in those namespaces.
+//
+// Entering the user namespace isn't supported: the kernel doesn't allow
+// multi-threaded processes, as all Go programs are, to change user namespace.
+// Entering the PID and time namespaces only affects the children created by
+// fn.
+//
+// The Go runtime threads share their filesystem attributes, root and current
+// directory, which prevents them from changing mount namespace. Entering the
+// mount namespace unshares those attributes first, and the thread isn't
+// reused by the Go runtime once fn returns. The thread isn't reused either if
+// it can't restore its original namespaces.
+func (p *Process) Enter(kinds []NamespaceKind, fn func() error) error {
+	for _, kind := range kinds {
+		if !kind.valid() {
+			return errors.Errorf("enter: invalid namespace kind %d", int(kind))
+		}
+		if kind == UserNS {
+			return errors.New("enter: can't enter a user namespace")
+		}
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		runtime.LockOSThread()
+
+		tid := unix.Gettid()
+		// The thread is tainted when it has diverged from the other
+		// threads of the process and must not be reused.
+		tainted := false
+		defer func() {
+			if !tainted {
+				runtime.UnlockOSThread()
+			}
+		}()
+
+		switches, err := p.openNamespaces(tid, kinds)
+		if err != nil {
+			done <- err
+			return
+		}
+		defer closeSwitches(switches)
+
+		var fs *fsContext
+		for _, s := range switches {
+			if s.kind != MountNS {
+				continue
+			}
+			if fs, err = saveFSContext(); err != nil {
+				done <- errors.Wrap(err, "enter")
+				return
+			}
+			defer fs.close()
+			if err := unix.Unshare(unix.CLONE_FS); err != nil {
+				done <- errors.Wrap(os.NewSyscallError("unshare", err), "enter")
+				return
+			}
+			tainted = true
+		}
+
+		var entered int
+		for _, s := range switches {
+			if err = setns(s.target, s.kind); err != nil {
+				err = errors.Wrapf(err, "enter: %s namespace", s.kind)
+				break
+			}
+			entered++
+		}
+
+		if err == nil {
+			err = fn()
+		}
+
+		for i := entered - 1; i >= 0; i-- {
+			s := &switches[i]
+			restoreErr := setns(s.original, s.kind)
+			if restoreErr == nil && s.kind == MountNS {
+				// Changing mount namespace moves the thread to the
+				// root of the namespace.
+				restoreErr = fs.restore()
+			}
+			if restoreErr != nil {
+				tainted = true
+				if err == nil {
+					err = errors.Wrapf(restoreErr, "enter: restore %s namespace", s.kind)
+				}
+			}
+		}
+
+		done <- err
+	}()
+
+	return <-done
+}
+
+// openNamespaces opens the namespaces kinds of the process, along with the
+// current namespaces of the thread tid. Namespaces the thread is already in
+// are skipped.
+func (p *Process) openNamespaces(tid int, kinds []NamespaceKind) ([]nsSwitch, error) {
+	self := "/proc/self/task/" + strconv.Itoa(tid) + "/ns/"
+
+	var switches []nsSwitch
+	for _, kind := range kinds {
+		target, err := os.Open(p.procPath("ns/" + nsProcFiles[kind]))
+		if err != nil {
+			closeSwitches(switches)
+			return nil, errors.Wrap(p.error(err), "enter")
+		}
+		original, err := os.Open(self + nsProcFiles[kind])
+		if err != nil {
+			target.Close()
+			closeSwitches(switches)
+			return nil, errors.Wrap(err, "enter")
+		}
+
+		s := nsSwitch{kind, target, original}
+		targetIno, err1 := nsInode(target)
+		originalIno, err2 := nsInode(original)
+		if err1 == nil && err2 == nil && targetIno == originalIno {
+			s.close()
+			continue
+		}
+		switches = append(switches, s)
+	}
+	return switches, nil
+}
+
+func closeSwitches(switches []nsSwitch) {
+	for i := range switches {
+		switches[i].close()
+	}
+}
+
+func setns(f *os.File, kind NamespaceKind) error {
+	if err := unix.Setns(int(f.Fd()), nsCloneFlags[kind]); err != nil {
+		return os.NewSyscallError("setns", err)
+	}
+	return nil
+}
+
+// fsContext is the root and current directories of a thread.
+type fsContext struct {
+	root, cwd *os.File
+}
+
+func saveFSContext() (*fsContext, error) {
+	root, err := os.Open("/")
+	if err != nil {
+		return nil, err
+	}
+	cwd, err := os.Open(".")
+	if err != nil {
+		root.Close()
+		return nil, err
+	}
+	return &fsContext{root, cwd}, nil
+}
+
+// restore makes the thread go back to the saved root and current directories.
+func (fs *fsContext) restore() error {
+	if err := unix.Fchdir(int(fs.root.Fd())); err != nil {
+		return os.NewSyscallError("fchdir", err)
+	}
+	if err := unix.Chroot("."); err != nil {
+		return os.NewSyscallError("chroot", err)
+	}
+	if err := unix.Fchdir(int(fs.cwd.Fd())); err != nil {
+		return os.NewSyscallError("fchdir", err)
+	}
+	return nil
+}
+
+func (fs *fsContext) close() {
+	fs.root.Close()
+	fs.cwd.Close()
+}
@@ -0,0 +1,119 @@
+package obs
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// startInNamespaces starts a shell script in new namespaces, waiting for it to
+// have written ready in its current directory.
+func startInNamespaces(t *testing.T, flags []string, script string) (*exec.Cmd, string) {
+	dir, err := ioutil.TempDir("", "obs-enter")
+	assert.NoError(t, err)
+
+	args := append(flags, "sh", "-c", script+" && touch "+dir+"/ready && exec sleep 10")
+	cmd := exec.Command("unshare", args...)
+	if err := cmd.Start(); err != nil {
+		os.RemoveAll(dir)
+		t.Skipf("unable to run unshare: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := os.Stat(dir + "/ready"); err == nil {
+			return cmd, dir
+		}
+		if time.Now().After(deadline) {
+			cmd.Process.Kill()
+			cmd.Wait()
+			os.RemoveAll(dir)
+			t.Skip("unable to create namespaces")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func stopInNamespaces(cmd *exec.Cmd, dir string) {
+	cmd.Process.Kill()
+	cmd.Wait()
+	os.RemoveAll(dir)
+}
+
+func TestEnterUTS(t *testing.T) {
+	cmd, dir := startInNamespaces(t, []string{"--uts"}, "hostname obs-enter-test")
+	defer stopInNamespaces(cmd, dir)
+
+	hostname, err := os.Hostname()
+	assert.NoError(t, err)
+	assert.NotEqual(t, "obs-enter-test", hostname)
+
+	var inside string
+	err = NewProcess(cmd.Process.Pid).Enter([]NamespaceKind{UTSNS}, func() error {
+		var err error
+		inside, err = os.Hostname()
+		return err
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "obs-enter-test", inside)
+
+	// We're back in our namespace.
+	after, err := os.Hostname()
+	assert.NoError(t, err)
+	assert.Equal(t, hostname, after)
+}
+
+func TestEnterMount(t *testing.T) {
+	mnt, err := ioutil.TempDir("", "obs-enter-mnt")
+	assert.NoError(t, err)
+	defer os.RemoveAll(mnt)
+
+	cmd, dir := startInNamespaces(t, []string{"--mount", "--propagation", "private"},
+		"mount -t tmpfs none "+mnt+" && echo foo > "+mnt+"/marker")
+	defer stopInNamespaces(cmd, dir)
+
+	var data []byte
+	err = NewProcess(cmd.Process.Pid).Enter([]NamespaceKind{MountNS, UTSNS}, func() error {
+		var err error
+		data, err = ioutil.ReadFile(mnt + "/marker")
+		return err
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "foo\n", string(data))
+
+	_, err = os.Stat(mnt + "/marker")
+	assert.True(t, os.IsNotExist(err))
+	// The thread has gone back to its original directories.
+	cwd, err := os.Getwd()
+	assert.NoError(t, err)
+	procCwd, err := NewProcess(os.Getpid()).Cwd()
+	assert.NoError(t, err)
+	assert.Equal(t, cwd, procCwd)
+}
+
+func TestEnterErrors(t *testing.T) {
+	self := NewProcess(os.Getpid())
+	called := false
+	fn := func() error {
+		called = true
+		return nil
+	}
+
+	assert.Error(t, self.Enter([]NamespaceKind{UserNS}, fn))
+	assert.Error(t, self.Enter([]NamespaceKind{NamespaceKind(42)}, fn))
+	assert.False(t, called)
+
+	err := NewProcess(5000000).Enter([]NamespaceKind{NetworkNS}, fn)
+	assert.True(t, IsProcessGone(err))
+	assert.False(t, called)
+
+	// Entering our own namespaces is a no-op, errors of fn are returned.
+	err = self.Enter([]NamespaceKind{NetworkNS, MountNS}, func() error {
+		return os.ErrInvalid
+	})
+	assert.Equal(t, os.ErrInvalid, err)
+}
@@ -0,0 +1,71 @@
+package obs
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNamespaceKindString(t *testing.T) {
+	assert.Equal(t, "net", NetworkNS.String())
+	assert.Equal(t, "mnt", MountNS.String())
+	assert.Equal(t, "time", TimeNS.String())
+	assert.Equal(t, "NamespaceKind(42)", NamespaceKind(42).String())
+	assert.Equal(t, "NamespaceKind(-1)", NamespaceKind(-1).String())
+}
+
+func TestNamespaces(t *testing.T) {
+	self := NewProcess(os.Getpid())
+
+	_, err := self.Namespace(NamespaceKind(42))
+	assert.Error(t, err)
+	_, err = self.Namespace(NamespaceKind(-1))
+	assert.Error(t, err)
+
+	namespaces, err := self.Namespaces()
+	assert.NoError(t, err)
+	for kind, ns := range namespaces {
+		expected, err := self.Namespace(kind)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, ns, kind.String())
+	}
+	assert.Contains(t, namespaces, NetworkNS)
+	assert.Contains(t, namespaces, PIDNS)
+
+	_, err = NewProcess(5000000).Namespaces()
+	assert.True(t, IsProcessGone(err))
+}
+
+func TestListNamespaces(t *testing.T) {
+	self := NewProcess(os.Getpid())
+	netns, err := self.Namespace(NetworkNS)
+	assert.NoError(t, err)
+	userns, err := self.Namespace(UserNS)
+	assert.NoError(t, err)
+
+	namespaces, err := ListNamespaces(NetworkNS)
+	assert.NoError(t, err)
+	found := false
+	for i, ns := range namespaces {
+		if i > 0 {
+			assert.True(t, namespaces[i-1].Inode < ns.Inode)
+		}
+		assert.Equal(t, NetworkNS, ns.Kind)
+		assert.NotEmpty(t, ns.Processes)
+		if ns.Inode != netns {
+			continue
+		}
+		found = true
+		assert.Equal(t, userns, ns.Owner)
+		pids := make([]int, len(ns.Processes))
+		for i, p := range ns.Processes {
+			pids[i] = p.PID()
+		}
+		assert.Contains(t, pids, os.Getpid())
+	}
+	assert.True(t, found)
+
+	_, err = ListNamespaces(NamespaceKind(42))
+	assert.Error(t, err)
+}
@@ -0,0 +1,393 @@
+package obs
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// defaultRescanInterval is the default interval between two scans of
+	// /proc when the namespace watcher can't use tracepoints.
+	defaultRescanInterval = time.Second
+
+	sysExitUnshare = "syscalls:sys_exit_unshare"
+	sysExitSetns   = "syscalls:sys_exit_setns"
+)
+
+// NamespaceEvent is an event reported by a NamespaceWatcher:
+// *NamespaceCreated, *NamespaceDestroyed or *ProcessJoinedNamespace.
+type NamespaceEvent interface {
+	// Namespace returns the kind and inode of the namespace the event is
+	// about.
+	Namespace() (NamespaceKind, uint64)
+}
+
+// NamespaceCreated is sent when a namespace appears.
+type NamespaceCreated struct {
+	Kind  NamespaceKind
+	Inode uint64
+	// PID is the first process seen in the namespace.
+	PID int
+}
+
+// Namespace implements NamespaceEvent.
+func (e *NamespaceCreated) Namespace() (NamespaceKind, uint64) {
+	return e.Kind, e.Inode
+}
+
+// NamespaceDestroyed is sent when the last process of a namespace has exited
+// or left it. Note the kernel may keep the namespace alive, eg. when it's
+// bind mounted or a file descriptor refers to it.
+type NamespaceDestroyed struct {
+	Kind  NamespaceKind
+	Inode uint64
+}
+
+// Namespace implements NamespaceEvent.
+func (e *NamespaceDestroyed) Namespace() (NamespaceKind, uint64) {
+	return e.Kind, e.Inode
+}
+
+// ProcessJoinedNamespace is sent when a process moves to another namespace,
+// with setns(2) or unshare(2). PID is a thread ID when a thread of a
+// multi-threaded process changes namespaces on its own.
+type ProcessJoinedNamespace struct {
+	PID   int
+	Kind  NamespaceKind
+	Inode uint64
+	// Previous is the inode of the namespace the process has left.
+	Previous uint64
+}
+
+// Namespace implements NamespaceEvent.
+func (e *ProcessJoinedNamespace) Namespace() (NamespaceKind, uint64) {
+	return e.Kind, e.Inode
+}
+
+// nsKey identifies a namespace.
+type nsKey struct {
+	kind  NamespaceKind
+	inode uint64
+}
+
+// NamespaceWatcher reports namespaces being created and destroyed, and
+// processes moving between namespaces.
+//
+// The watcher follows the fork and exit side-band records, see SideBandTask,
+// as well as the syscalls:sys_exit_unshare and syscalls:sys_exit_setns
+// tracepoints, and inspects the namespaces of the processes involved. When
+// those tracepoints aren't available, the watcher periodically scans /proc
+// instead, see Polling.
+type NamespaceWatcher struct {
+	interval time.Duration
+	// tracepoints is true if the watcher follows tracepoints, false if it
+	// periodically scans /proc.
+	tracepoints bool
+	// polling is the reason the watcher scans /proc.
+	polling error
+
+	observer  *Observer
+	unshares  EventSource
+	setns     EventSource
+	fields    struct{ unshareRet, setnsRet FieldHandle }
+	events    chan NamespaceEvent
+	close     chan interface{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+
+	mu sync.Mutex
+	// tasks are the namespaces of the tasks we know about, indexed by PID.
+	tasks map[int]map[NamespaceKind]uint64
+	// namespaces are the number of tasks in each namespace.
+	namespaces map[nsKey]int
+}
+
+// NewNamespaceWatcher creates a NamespaceWatcher. rescanInterval is the
+// interval between two scans of /proc when tracepoints can't be used, 1s if
+// rescanInterval is 0.
+func NewNamespaceWatcher(rescanInterval time.Duration) *NamespaceWatcher {
+	if rescanInterval == 0 {
+		rescanInterval = defaultRescanInterval
+	}
+	return &NamespaceWatcher{
+		interval:    rescanInterval,
+		tracepoints: true,
+		events:      make(chan NamespaceEvent, 256),
+		close:       make(chan interface{}),
+		tasks:       make(map[int]map[NamespaceKind]uint64),
+		namespaces:  make(map[nsKey]int),
+	}
+}
+
+// Open starts watching namespaces. Namespaces existing before Open aren't
+// reported.
+func (w *NamespaceWatcher) Open() error {
+	if w.tracepoints {
+		if err := w.openObserver(); err != nil {
+			w.tracepoints = false
+			w.polling = err
+		}
+	}
+
+	// Record the existing namespaces, once we're following the tracepoints
+	// to not miss any change.
+	if err := w.scan(false); err != nil {
+		if w.observer != nil {
+			w.observer.Close()
+		}
+		return err
+	}
+
+	w.wg.Add(1)
+	if w.tracepoints {
+		go w.read()
+	} else {
+		go w.rescan()
+	}
+
+	return nil
+}
+
+func (w *NamespaceWatcher) openObserver() error {
+	for _, name := range []string{sysExitUnshare, sysExitSetns} {
+		if !TracepointExists(name) {
+			return errors.Errorf("namespace watcher: no %s tracepoint", name)
+		}
+	}
+
+	o := NewObserver()
+	o.AddSoftwareEvent(Dummy, WithSideBand(SideBandTask))
+	w.unshares = o.AddTracepoint(sysExitUnshare)
+	w.setns = o.AddTracepoint(sysExitSetns)
+	if err := o.Open(); err != nil {
+		return errors.Wrap(err, "namespace watcher")
+	}
+
+	var err error
+	if w.fields.unshareRet, err = o.Field(w.unshares, "ret"); err == nil {
+		w.fields.setnsRet, err = o.Field(w.setns, "ret")
+	}
+	if err != nil {
+		o.Close()
+		return errors.Wrap(err, "namespace watcher")
+	}
+
+	w.observer = o
+	return nil
+}
+
+// Polling returns the reason the watcher periodically scans /proc instead of
+// following the kernel events, nil when it follows them. The mode is chosen
+// by Open.
+func (w *NamespaceWatcher) Polling() error {
+	return w.polling
+}
+
+// Events returns the channel the namespace events are sent on. The channel is
+// closed by Close. Events are sent as they're discovered, the caller needs to
+// receive them for the watcher to make progress.
+func (w *NamespaceWatcher) Events() <-chan NamespaceEvent {
+	return w.events
+}
+
+func (w *NamespaceWatcher) send(events []NamespaceEvent) {
+	for _, e := range events {
+		select {
+		case w.events <- e:
+		case <-w.close:
+			return
+		}
+	}
+}
+
+func (w *NamespaceWatcher) read() {
+	defer w.wg.Done()
+
+	for {
+		event, err := w.observer.ReadEvent()
+		if err != nil || event == nil {
+			// The observer has been closed.
+			return
+		}
+
+		switch e := event.(type) {
+		case *ForkEvent:
+			// Threads share the namespaces of their process.
+			if !e.Thread() {
+				w.send(w.inspect(e.PID))
+			}
+		case *ExitEvent:
+			// Threads that have changed namespaces on their own are
+			// known by their TID.
+			w.mu.Lock()
+			events := w.remove(e.TID)
+			w.mu.Unlock()
+			w.send(events)
+		case *TracepointEvent:
+			ret := w.fields.unshareRet
+			if e.GetSource() == w.setns {
+				ret = w.fields.setnsRet
+			}
+			if e.IntAt(ret) != 0 {
+				continue
+			}
+			w.send(w.inspect(e.CommonPID()))
+		}
+	}
+}
+
+// inspect reads the namespaces of the task tid, returning the events their
+// changes trigger.
+func (w *NamespaceWatcher) inspect(tid int) []NamespaceEvent {
+	namespaces, err := NewProcess(tid).Namespaces()
+	if err != nil {
+		// The task has exited already.
+		return nil
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.update(tid, namespaces)
+}
+
+// rescan periodically scans /proc.
+func (w *NamespaceWatcher) rescan() {
+	defer w.wg.Done()
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.close:
+			return
+		case <-ticker.C:
+		}
+
+		w.scan(true)
+	}
+}
+
+// scan reads the namespaces of all the processes, reporting the changes since
+// the last scan if report is true.
+func (w *NamespaceWatcher) scan(report bool) error {
+	pids, err := readPIDs("/proc")
+	if err != nil {
+		return errors.Wrap(err, "namespace watcher")
+	}
+
+	var events []NamespaceEvent
+	alive := make(map[int]bool, len(pids))
+	for _, pid := range pids {
+		namespaces, err := NewProcess(pid).Namespaces()
+		if err != nil {
+			// The process has exited, zombies don't have namespaces
+			// anymore.
+			continue
+		}
+		alive[pid] = true
+		w.mu.Lock()
+		events = append(events, w.update(pid, namespaces)...)
+		w.mu.Unlock()
+	}
+
+	// When following tracepoints, the tasks we don't find in /proc are
+	// threads that have changed namespaces or processes that have started
+	// after we've listed /proc.
+	if !w.tracepoints {
+		w.mu.Lock()
+		for pid := range w.tasks {
+			if !alive[pid] {
+				events = append(events, w.remove(pid)...)
+			}
+		}
+		w.mu.Unlock()
+	}
+
+	if report {
+		w.send(events)
+	}
+	return nil
+}
+
+// update records the namespaces of the task pid, returning the events the
+// changes trigger. w.mu must be held.
+func (w *NamespaceWatcher) update(pid int, namespaces map[NamespaceKind]uint64) []NamespaceEvent {
+	var events []NamespaceEvent
+
+	previous := w.tasks[pid]
+	for i := range nsProcFiles {
+		kind := NamespaceKind(i)
+		ns, ok := namespaces[kind]
+		prev, known := previous[kind]
+		if !ok {
+			// Exiting tasks lose their namespaces.
+			if known {
+				events = append(events, w.leave(kind, prev)...)
+			}
+			continue
+		}
+		if known && prev == ns {
+			continue
+		}
+
+		key := nsKey{kind, ns}
+		if w.namespaces[key] == 0 {
+			events = append(events, &NamespaceCreated{Kind: kind, Inode: ns, PID: pid})
+		}
+		w.namespaces[key]++
+
+		if known {
+			events = append(events, &ProcessJoinedNamespace{PID: pid, Kind: kind, Inode: ns, Previous: prev})
+			events = append(events, w.leave(kind, prev)...)
+		}
+	}
+	w.tasks[pid] = namespaces
+
+	return events
+}
+
+// leave records that a task has left a namespace, returning a
+// NamespaceDestroyed event if it was the last task in it. w.mu must be held.
+func (w *NamespaceWatcher) leave(kind NamespaceKind, ns uint64) []NamespaceEvent {
+	key := nsKey{kind, ns}
+	w.namespaces[key]--
+	if w.namespaces[key] > 0 {
+		return nil
+	}
+	delete(w.namespaces, key)
+	return []NamespaceEvent{&NamespaceDestroyed{Kind: kind, Inode: ns}}
+}
+
+// remove forgets about the task pid, returning the events its exit triggers.
+// w.mu must be held.
+func (w *NamespaceWatcher) remove(pid int) []NamespaceEvent {
+	namespaces, ok := w.tasks[pid]
+	if !ok {
+		return nil
+	}
+	delete(w.tasks, pid)
+
+	var events []NamespaceEvent
+	for i := range nsProcFiles {
+		kind := NamespaceKind(i)
+		if ns, ok := namespaces[kind]; ok {
+			events = append(events, w.leave(kind, ns)...)
+		}
+	}
+	return events
+}
+
+// Close stops watching namespaces and closes the Events channel.
+func (w *NamespaceWatcher) Close() {
+	w.closeOnce.Do(func() {
+		close(w.close)
+		if w.observer != nil {
+			w.observer.Close()
+		}
+		w.wg.Wait()
+		close(w.events)
+	})
+}
@@ -0,0 +1,131 @@
+package obs
+
+import (
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNamespaceWatcherUpdate(t *testing.T) {
+	w := NewNamespaceWatcher(0)
+
+	// Existing processes.
+	w.update(1, map[NamespaceKind]uint64{NetworkNS: 100, PIDNS: 200})
+	w.update(2, map[NamespaceKind]uint64{NetworkNS: 100, PIDNS: 200})
+
+	// A process unshares its network namespace.
+	events := w.update(2, map[NamespaceKind]uint64{NetworkNS: 101, PIDNS: 200})
+	assert.Equal(t, []NamespaceEvent{
+		&NamespaceCreated{Kind: NetworkNS, Inode: 101, PID: 2},
+		&ProcessJoinedNamespace{PID: 2, Kind: NetworkNS, Inode: 101, Previous: 100},
+	}, events)
+
+	// A new process in that namespace.
+	events = w.update(3, map[NamespaceKind]uint64{NetworkNS: 101, PIDNS: 200})
+	assert.Len(t, events, 0)
+
+	// Nothing changes.
+	events = w.update(3, map[NamespaceKind]uint64{NetworkNS: 101, PIDNS: 200})
+	assert.Len(t, events, 0)
+
+	// 3 joins the namespace of 1.
+	events = w.update(3, map[NamespaceKind]uint64{NetworkNS: 100, PIDNS: 200})
+	assert.Equal(t, []NamespaceEvent{
+		&ProcessJoinedNamespace{PID: 3, Kind: NetworkNS, Inode: 100, Previous: 101},
+	}, events)
+
+	// The last process of 101 exits.
+	events = w.remove(2)
+	assert.Equal(t, []NamespaceEvent{
+		&NamespaceDestroyed{Kind: NetworkNS, Inode: 101},
+	}, events)
+	kind, inode := events[0].Namespace()
+	assert.Equal(t, NetworkNS, kind)
+	assert.Equal(t, uint64(101), inode)
+
+	assert.Len(t, w.remove(2), 0)
+	assert.Len(t, w.remove(3), 0)
+	assert.Equal(t, []NamespaceEvent{
+		&NamespaceDestroyed{Kind: NetworkNS, Inode: 100},
+		&NamespaceDestroyed{Kind: PIDNS, Inode: 200},
+	}, w.remove(1))
+	assert.Len(t, w.namespaces, 0)
+}
+
+func testNamespaceWatcher(t *testing.T, w *NamespaceWatcher) {
+	if err := w.Open(); err != nil {
+		t.Skipf("unable to open the namespace watcher: %v", err)
+	}
+	defer w.Close()
+
+	cmd := exec.Command("unshare", "--uts", "sleep", "0.5")
+	if err := cmd.Start(); err != nil {
+		t.Skipf("unable to run unshare: %v", err)
+	}
+	pid := cmd.Process.Pid
+
+	var created, destroyed *uint64
+	timeout := time.After(5 * time.Second)
+	for destroyed == nil {
+		var event NamespaceEvent
+		select {
+		case event = <-w.Events():
+		case <-timeout:
+			cmd.Wait()
+			t.Fatal("namespace events not received")
+		}
+
+		switch e := event.(type) {
+		case *NamespaceCreated:
+			if e.Kind == UTSNS && e.PID == pid {
+				created = &e.Inode
+			}
+		case *NamespaceDestroyed:
+			if created != nil && e.Kind == UTSNS && e.Inode == *created {
+				destroyed = &e.Inode
+			}
+		}
+	}
+	assert.NoError(t, cmd.Wait())
+}
+
+func TestNamespaceWatcher(t *testing.T) {
+	if !TracepointExists(sysExitSetns) {
+		t.Skip("kernel built without syscall tracepoints")
+	}
+	w := NewNamespaceWatcher(0)
+	testNamespaceWatcher(t, w)
+	assert.True(t, w.tracepoints)
+	assert.NoError(t, w.Polling())
+}
+
+func TestNamespaceWatcherRescan(t *testing.T) {
+	w := NewNamespaceWatcher(50 * time.Millisecond)
+	w.tracepoints = false
+	testNamespaceWatcher(t, w)
+}
+
+func TestNamespaceWatcherExiting(t *testing.T) {
+	w := NewNamespaceWatcher(0)
+
+	w.update(1, map[NamespaceKind]uint64{NetworkNS: 100, PIDNS: 200})
+	// Zombies still have their PID namespace.
+	events := w.update(1, map[NamespaceKind]uint64{PIDNS: 200})
+	assert.Equal(t, []NamespaceEvent{
+		&NamespaceDestroyed{Kind: NetworkNS, Inode: 100},
+	}, events)
+	assert.Equal(t, []NamespaceEvent{
+		&NamespaceDestroyed{Kind: PIDNS, Inode: 200},
+	}, w.remove(1))
+}
+
+func TestNamespaceWatcherClose(t *testing.T) {
+	w := NewNamespaceWatcher(0)
+	w.Close()
+	// Closing a watcher twice is harmless.
+	w.Close()
+	_, ok := <-w.Events()
+	assert.False(t, ok)
+}
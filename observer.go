@@ -1,8 +1,14 @@
 package obs
 
 import (
+	"errors"
+	"fmt"
 	"sync"
 	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
 )
 
 // Observer is the object that will observe the system. An observer is first
@@ -10,10 +16,19 @@ import (
 // hit. Then events can be read from the observer when they occur.
 type Observer struct {
 	nextEventSource uint32
-	tracepoints     []tracepointData
-	close           chan interface{}
-	events          chan Event
-	wg              sync.WaitGroup
+	// received counts the events received with ReadEvent.
+	received       uint32
+	tracepoints    []tracepointData
+	softwareEvents []softwareEventData
+	// opened is true once Open has been called.
+	opened    bool
+	close     chan interface{}
+	closeOnce sync.Once
+	// stop makes the readers return once they've read the pending events.
+	stop     chan interface{}
+	stopOnce sync.Once
+	events   chan Event
+	wg       sync.WaitGroup
 }
 
 // tracepointData is the per-tracepoint data the observer keeps around.
@@ -22,29 +37,119 @@ type tracepointData struct {
 	tp     *tracepoint
 }
 
+// pollTimeout is the maximum time, in milliseconds, the readers wait for
+// events before checking if the observer is being closed.
+const pollTimeout = 100
+
+// drainTimeout is how long drain waits for an event to be received before
+// dropping the pending events.
+const drainTimeout = time.Second
+
+// softwareEventData is the per-software event data the observer keeps around.
+type softwareEventData struct {
+	source EventSource
+	event  *softwareEvent
+}
+
 // NewObserver creates an Observer.
 func NewObserver() *Observer {
 	return &Observer{
 		close:  make(chan interface{}),
+		stop:   make(chan interface{}),
 		events: make(chan Event),
 	}
 }
 
 // AddTracepoint adds a tracepoint to watch for.
-func (o *Observer) AddTracepoint(name string) EventSource {
+func (o *Observer) AddTracepoint(name string, opts ...EventOption) EventSource {
+	options := newEventOptions(opts)
 	source := atomic.AddUint32(&o.nextEventSource, 1)
 	o.tracepoints = append(o.tracepoints, tracepointData{
 		source: EventSource(source),
-		tp:     newTracepoint(name),
+		tp:     newTracepoint(name, options),
+	})
+	return EventSource(source)
+}
+
+// AddSoftwareEvent adds a software event to watch for. Software events are
+// sampled at 4000 Hz by default, this can be changed with WithSamplePeriod and
+// WithSampleFrequency. WithCounting makes the event counted instead of
+// sampled.
+func (o *Observer) AddSoftwareEvent(kind SoftwareEventKind, opts ...EventOption) EventSource {
+	options := newEventOptions(opts)
+	source := atomic.AddUint32(&o.nextEventSource, 1)
+	o.softwareEvents = append(o.softwareEvents, softwareEventData{
+		source: EventSource(source),
+		event:  newSoftwareEvent(kind, options),
 	})
 	return EventSource(source)
 }
 
+// readEvents receives the samples of perf and sends the events created by
+// newEvent to the events channel. Side-band records are decoded and sent as
+// well.
+func (o *Observer) readEvents(source EventSource, perf *perfSystemEvent, newEvent func(sample *perfSampleRecord, cpu int) Event) {
+	o.wg.Add(1)
+	go func() {
+		defer o.wg.Done()
+
+		receive := func(msg *perfEventSample, cpu int) {
+			sample, err := parseSample(perf.sampleType, msg.record()[unsafe.Sizeof(msg.perfEventHeader):])
+			if err != nil {
+				return
+			}
+			select {
+			case o.events <- newEvent(&sample, cpu):
+			case <-o.close:
+			}
+		}
+		record := func(record []byte, cpu int) {
+			event, err := parseSideBand(source, perf.sampleType, record, cpu)
+			if err != nil || event == nil {
+				return
+			}
+			select {
+			case o.events <- event:
+			case <-o.close:
+			}
+		}
+
+		for {
+			select {
+			case <-o.close:
+				return
+			case <-o.stop:
+				// Read what's left in the ring buffers, including the
+				// ones of the events that have hung up.
+				perf.readAll(receive, nil, record)
+				return
+			default:
+			}
+
+			// Closing the perf events doesn't wake up epoll_wait(), poll
+			// with a timeout to notice the observer is being closed.
+			nFds, err := perf.poll(pollTimeout)
+			if err == unix.EINTR {
+				continue
+			}
+			if err != nil {
+				return
+			}
+			if nFds == 0 {
+				continue
+			}
+			perf.read(receive, nil, record)
+		}
+	}()
+}
+
 // Open finish initializing the observer. From then on, events can be received
 // with ReadEvent().
 func (o *Observer) Open() error {
 	var err error
 
+	o.opened = true
+
 	defer func() {
 		if err != nil {
 			o.Close()
@@ -58,53 +163,161 @@ func (o *Observer) Open() error {
 		if err = tp.open(); err != nil {
 			return err
 		}
-		o.wg.Add(1)
-		go func(tp *tracepoint, source EventSource) {
-			// TODO(damien): should we hide the implementation details into the
-			// tracepoint object and have it provide a channel?
-			for {
-				var nFds int
-				nFds, err = tp.perf.poll(-1)
-				if err != nil {
-					break
-				}
-				if nFds == 0 {
-					break
-				}
-				tp.perf.read(func(msg *perfEventSample, cpu int) {
-					event := &TracepointEvent{
-						baseEvent: baseEvent{
-							source: source,
-						},
-						tp:   tp,
-						data: msg.DataCopy(),
-					}
-					o.events <- event
-				}, nil)
+		// TODO(damien): should we hide the implementation details into the
+		// tracepoint object and have it provide a channel?
+		o.readEvents(source, tp.perf, func(sample *perfSampleRecord, cpu int) Event {
+			return &TracepointEvent{
+				baseEvent: baseEvent{
+					source:    source,
+					timestamp: sample.time,
+				},
+				tp:        tp,
+				data:      append([]byte(nil), sample.raw...),
+				cpu:       cpu,
+				tgid:      int(sample.pid),
+				callchain: sample.callchain,
 			}
-			o.wg.Done()
-		}(tp, source)
+		})
+	}
 
+	for _, data := range o.softwareEvents {
+		event := data.event
+		source := data.source
+
+		if err = event.open(); err != nil {
+			return err
+		}
+		if event.options.counting {
+			continue
+		}
+		o.readEvents(source, event.perf, func(sample *perfSampleRecord, cpu int) Event {
+			e := &SoftwareEvent{
+				baseEvent: baseEvent{
+					source:    source,
+					timestamp: sample.time,
+				},
+				IP:  sample.ip,
+				PID: int(sample.pid),
+				TID: int(sample.tid),
+				CPU: int(sample.cpu),
+			}
+			if sample.raw != nil {
+				e.Data = append([]byte(nil), sample.raw...)
+			}
+			return e
+		})
 	}
 
 	return nil
 }
 
+// Count returns the value of the counting event source, the number of events
+// counted since Open. Only the sources added with the WithCounting option can
+// be read with Count.
+func (o *Observer) Count(source EventSource) (uint64, error) {
+	for _, data := range o.softwareEvents {
+		if data.source != source {
+			continue
+		}
+		if !data.event.options.counting {
+			return 0, errors.New("observer: not a counting source")
+		}
+		if data.event.perf == nil {
+			return 0, errors.New("observer: Count called before Open")
+		}
+		return data.event.perf.count()
+	}
+
+	return 0, fmt.Errorf("observer: unknown counting source %d", source)
+}
+
+// Field resolves the field name of the tracepoint source. The returned handle
+// can then be given to TracepointEvent.IntAt and TracepointEvent.StringAt to
+// decode the field without looking it up by name for every event. Field can
+// only be called once the observer is opened, as the tracepoint formats are
+// read by Open.
+func (o *Observer) Field(source EventSource, name string) (FieldHandle, error) {
+	for i := range o.tracepoints {
+		data := &o.tracepoints[i]
+		if data.source != source {
+			continue
+		}
+		if len(data.tp.format.fields) == 0 {
+			return FieldHandle{}, errors.New("observer: Field called before Open")
+		}
+		field := data.tp.format.findField(name)
+		if field == nil {
+			return FieldHandle{}, fmt.Errorf("observer: %s has no field named '%s'",
+				data.tp.Name, name)
+		}
+		return FieldHandle{
+			source: source,
+			field:  *field,
+		}, nil
+	}
+
+	return FieldHandle{}, fmt.Errorf("observer: unknown tracepoint source %d", source)
+}
+
 // ReadEvent returns one event. This call blocks until an event is received.
 func (o *Observer) ReadEvent() (Event, error) {
 	select {
 	case <-o.close:
 		return nil, nil
 	case event := <-o.events:
+		atomic.AddUint32(&o.received, 1)
 		return event, nil
 	}
 }
 
+// drain stops the observer once the pending events have been received. The
+// pending events are dropped if none has been received for drainTimeout.
+func (o *Observer) drain() {
+	o.stopOnce.Do(func() {
+		close(o.stop)
+	})
+
+	done := make(chan struct{})
+	go func() {
+		o.wg.Wait()
+		close(done)
+	}()
+
+	ticker := time.NewTicker(drainTimeout)
+	defer ticker.Stop()
+
+	received := atomic.LoadUint32(&o.received)
+	for {
+		select {
+		case <-done:
+			o.Close()
+			return
+		case <-ticker.C:
+		}
+
+		last := received
+		received = atomic.LoadUint32(&o.received)
+		if received == last {
+			// Nobody is receiving the events, closing the observer
+			// makes the readers drop them.
+			o.Close()
+			return
+		}
+	}
+}
+
 // Close frees precious resources acquired during Open.
 func (o *Observer) Close() {
-	close(o.close)
-	for _, data := range o.tracepoints {
-		data.tp.close()
-	}
-	o.wg.Wait()
+	o.closeOnce.Do(func() {
+		close(o.close)
+		// Wait for the readers to stop before closing the file
+		// descriptors they use.
+		o.wg.Wait()
+		for _, data := range o.tracepoints {
+			data.tp.close()
+		}
+		for _, data := range o.softwareEvents {
+			data.event.close()
+		}
+	})
 }
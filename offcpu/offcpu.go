@@ -0,0 +1,417 @@
+// Package offcpu measures the time threads spend blocked off-CPU, waiting for
+// I/O, locks, timers or any other event, and who wakes them up.
+//
+// The analyzer follows the sched:sched_switch and sched:sched_wakeup
+// tracepoints: a thread is blocked from the moment it's switched out in a
+// sleeping state until it's switched back in. Threads preempted while still
+// runnable aren't blocked, the time they wait for a CPU is scheduler latency.
+//
+// Wakeups are reported with their wakeup chain: a thread woken up works on
+// behalf of its waker until it blocks again, so the threads it wakes up in
+// the meantime are reported with the waker of their waker, and so on.
+package offcpu
+
+import (
+	"encoding/binary"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dlespiau/obs"
+)
+
+// wakeupChainDepth is the maximum number of wakers followed up a wakeup
+// chain.
+const wakeupChainDepth = 4
+
+// Option configures an Analyzer.
+type Option func(*Analyzer)
+
+// WithCallchains records the stacks of the threads when they're switched out
+// and of their wakers, to report the blocked time per stack.
+func WithCallchains() Option {
+	return func(a *Analyzer) {
+		a.callchains = true
+	}
+}
+
+// Thread is the blocked time of a thread.
+type Thread struct {
+	// TID is the ID of the thread.
+	TID int
+	// Comm is the name of the thread.
+	Comm string
+	// Blocked is the total time the thread has been blocked.
+	Blocked time.Duration
+	// Histogram is the distribution of the blocked durations, in
+	// nanoseconds.
+	Histogram obs.Histogram
+}
+
+// Stack is the blocked time of a thread blocked in a given stack.
+type Stack struct {
+	// TID is the ID of the thread, PID the ID of its process.
+	TID int
+	PID int
+	// Comm is the name of the thread.
+	Comm string
+	// Kernel and User are the stacks of the thread when it was switched out,
+	// leaf first.
+	Kernel, User []uint64
+	// Blocked is the total time spent blocked in that stack.
+	Blocked time.Duration
+	// Count is the number of times the thread blocked in that stack.
+	Count uint64
+}
+
+// Waker is a thread that has woken another thread up.
+type Waker struct {
+	TID  int
+	Comm string
+}
+
+// Wakeup is the blocked time of a thread woken up by a given waker, through a
+// given wakeup chain.
+type Wakeup struct {
+	// WakerTID and WakerComm identify the thread that woke the wakee up. The
+	// waker is the thread that was running when the wakeup happened, the
+	// idle task (TID 0) for wakeups from interrupts on idle CPUs.
+	WakerTID  int
+	WakerComm string
+	// WakerKernel and WakerUser are the stacks of the waker, leaf first,
+	// when the analyzer records callchains.
+	WakerKernel, WakerUser []uint64
+	// Chain are the threads that have woken the waker up, transitively, most
+	// recent first: Chain[0] woke the waker up, Chain[1] woke Chain[0] up
+	// and so on. Chains are followed through the wakeups seen since Open,
+	// up to 4 wakers.
+	Chain []Waker
+	// TID and Comm identify the wakee.
+	TID  int
+	Comm string
+	// Blocked is the total time the wakee has been blocked before being
+	// woken up by the waker.
+	Blocked time.Duration
+	// Count is the number of wakeups.
+	Count uint64
+}
+
+// Report is the result of an off-CPU analysis.
+type Report struct {
+	// Start and End are the times of the first and last events analyzed,
+	// in nanoseconds of the perf clock.
+	Start, End uint64
+	// Blocked is the total time threads have been blocked.
+	Blocked time.Duration
+	// Histogram is the distribution of the blocked durations of all the
+	// threads, in nanoseconds.
+	Histogram obs.Histogram
+	// Threads is the blocked time per thread, most blocked first.
+	Threads []Thread
+	// Stacks is the blocked time per stack, most blocked first. Stacks are
+	// only recorded by analyzers created with WithCallchains.
+	Stacks []Stack
+	// Wakeups is the blocked time per waker and wakee, most blocked first.
+	Wakeups []Wakeup
+}
+
+// blockedThread is a thread currently blocked.
+type blockedThread struct {
+	pid          int
+	since        uint64
+	kernel, user []uint64
+	// woken is true once the thread has been woken up, by waker.
+	woken       bool
+	waker       int
+	wakerKernel []uint64
+	wakerUser   []uint64
+	// wakerChain is the wakeup chain of the waker.
+	wakerChain []int
+}
+
+// Analyzer measures the time threads spend blocked.
+type Analyzer struct {
+	callchains bool
+	tracer     *obs.SchedTracer
+
+	mu sync.Mutex
+	// blocked are the threads currently blocked, indexed by TID.
+	blocked map[int]*blockedThread
+	// comms are the thread names, indexed by TID.
+	comms map[int]string
+	// chains are the wakers of the last wakeup of the threads, most recent
+	// first, indexed by TID.
+	chains map[int][]int
+	report Report
+	// threads, stacks and wakees index the report entries by key.
+	threads map[int]int
+	stacks  map[string]int
+	wakees  map[string]int
+	key     []byte
+}
+
+// NewAnalyzer creates an Analyzer.
+func NewAnalyzer(opts ...Option) *Analyzer {
+	a := &Analyzer{
+		blocked: make(map[int]*blockedThread),
+		comms:   make(map[int]string),
+		chains:  make(map[int][]int),
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	a.reset()
+	return a
+}
+
+// Open starts the analysis.
+func (a *Analyzer) Open() error {
+	a.tracer = &obs.SchedTracer{
+		Switch:    a.sched,
+		Wakeup:    a.wakeup,
+		Callchain: a.callchains,
+		Locker:    &a.mu,
+	}
+	return a.tracer.Open()
+}
+
+// updateTimes extends the time span of the report to t.
+func (a *Analyzer) updateTimes(t uint64) {
+	if a.report.Start == 0 || t < a.report.Start {
+		a.report.Start = t
+	}
+	if t > a.report.End {
+		a.report.End = t
+	}
+}
+
+// sched analyzes a context switch. a.mu must be held.
+func (a *Analyzer) sched(e *obs.SchedSwitch) {
+	a.updateTimes(e.Time)
+	a.comms[e.PrevTID] = e.PrevComm
+	a.comms[e.NextTID] = e.NextComm
+
+	// The idle tasks are never blocked.
+	if e.PrevTID != 0 {
+		if e.PrevExiting() {
+			delete(a.blocked, e.PrevTID)
+			delete(a.comms, e.PrevTID)
+			delete(a.chains, e.PrevTID)
+		} else if !e.PrevRunnable() {
+			a.blocked[e.PrevTID] = &blockedThread{
+				pid:    e.PrevPID,
+				since:  e.Time,
+				kernel: e.Kernel,
+				user:   e.User,
+			}
+		} else {
+			delete(a.blocked, e.PrevTID)
+		}
+	}
+
+	b, ok := a.blocked[e.NextTID]
+	if !ok {
+		return
+	}
+	delete(a.blocked, e.NextTID)
+	// The thread now works on behalf of its waker.
+	if b.woken {
+		chain := append([]int{b.waker}, b.wakerChain...)
+		if len(chain) > wakeupChainDepth {
+			chain = chain[:wakeupChainDepth]
+		}
+		a.chains[e.NextTID] = chain
+	} else {
+		delete(a.chains, e.NextTID)
+	}
+	if e.Time < b.since {
+		return
+	}
+	a.add(e.NextTID, b, time.Duration(e.Time-b.since))
+}
+
+// wakeup analyzes a wakeup. a.mu must be held.
+func (a *Analyzer) wakeup(e *obs.SchedWakeup) {
+	a.updateTimes(e.Time)
+
+	b, ok := a.blocked[e.TID]
+	if !ok || b.woken {
+		return
+	}
+	b.woken = true
+	b.waker = e.WakerTID
+	b.wakerKernel = e.Kernel
+	b.wakerUser = e.User
+	b.wakerChain = a.chains[e.WakerTID]
+}
+
+func appendInt(b []byte, v uint64) []byte {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+// appendStack appends a stack to a key. The stack length is part of the key
+// so kernel and user frames can't be confused.
+func appendStack(key []byte, stack []uint64) []byte {
+	key = appendInt(key, uint64(len(stack)))
+	for _, ip := range stack {
+		key = appendInt(key, ip)
+	}
+	return key
+}
+
+// add records that the thread tid has been blocked for d.
+func (a *Analyzer) add(tid int, b *blockedThread, d time.Duration) {
+	comm := a.comms[tid]
+
+	a.report.Blocked += d
+	a.report.Histogram.Add(uint64(d))
+
+	i, ok := a.threads[tid]
+	if !ok {
+		i = len(a.report.Threads)
+		a.threads[tid] = i
+		a.report.Threads = append(a.report.Threads, Thread{TID: tid})
+	}
+	thread := &a.report.Threads[i]
+	thread.Comm = comm
+	thread.Blocked += d
+	thread.Histogram.Add(uint64(d))
+
+	if a.callchains {
+		key := appendInt(a.key[:0], uint64(tid))
+		key = appendStack(key, b.kernel)
+		key = appendStack(key, b.user)
+		a.key = key
+
+		i, ok := a.stacks[string(key)]
+		if !ok {
+			i = len(a.report.Stacks)
+			a.stacks[string(key)] = i
+			a.report.Stacks = append(a.report.Stacks, Stack{
+				TID:    tid,
+				PID:    b.pid,
+				Kernel: b.kernel,
+				User:   b.user,
+			})
+		}
+		stack := &a.report.Stacks[i]
+		stack.Comm = comm
+		stack.Blocked += d
+		stack.Count++
+	}
+
+	if !b.woken {
+		return
+	}
+	key := appendInt(a.key[:0], uint64(b.waker)<<32|uint64(tid))
+	key = appendStack(key, b.wakerKernel)
+	key = appendStack(key, b.wakerUser)
+	key = appendInt(key, uint64(len(b.wakerChain)))
+	for _, waker := range b.wakerChain {
+		key = appendInt(key, uint64(waker))
+	}
+	a.key = key
+
+	i, ok = a.wakees[string(key)]
+	if !ok {
+		i = len(a.report.Wakeups)
+		a.wakees[string(key)] = i
+		var chain []Waker
+		for _, waker := range b.wakerChain {
+			chain = append(chain, Waker{TID: waker, Comm: a.comms[waker]})
+		}
+		a.report.Wakeups = append(a.report.Wakeups, Wakeup{
+			WakerTID:    b.waker,
+			WakerKernel: b.wakerKernel,
+			WakerUser:   b.wakerUser,
+			Chain:       chain,
+			TID:         tid,
+		})
+	}
+	wakeup := &a.report.Wakeups[i]
+	wakeup.WakerComm = a.comms[b.waker]
+	wakeup.Comm = comm
+	wakeup.Blocked += d
+	wakeup.Count++
+}
+
+func (a *Analyzer) reset() {
+	a.report = Report{}
+	a.threads = make(map[int]int)
+	a.stacks = make(map[string]int)
+	a.wakees = make(map[string]int)
+}
+
+// snapshot returns a copy of the report. a.mu must be held.
+func (a *Analyzer) snapshot() *Report {
+	r := a.report
+	r.Threads = append([]Thread(nil), a.report.Threads...)
+	r.Stacks = append([]Stack(nil), a.report.Stacks...)
+	r.Wakeups = append([]Wakeup(nil), a.report.Wakeups...)
+
+	sort.SliceStable(r.Threads, func(i, j int) bool {
+		return r.Threads[i].Blocked > r.Threads[j].Blocked
+	})
+	sort.SliceStable(r.Stacks, func(i, j int) bool {
+		return r.Stacks[i].Blocked > r.Stacks[j].Blocked
+	})
+	sort.SliceStable(r.Wakeups, func(i, j int) bool {
+		return r.Wakeups[i].Blocked > r.Wakeups[j].Blocked
+	})
+	return &r
+}
+
+// Report returns the blocked times measured since Open or the last Flush.
+// Events are analyzed with a small delay, to process them in order, the
+// report doesn't include the most recent events.
+func (a *Analyzer) Report() *Report {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.snapshot()
+}
+
+// Flush returns the blocked times measured since Open or the last Flush and
+// starts a new report. The threads blocked when Flush is called are
+// accounted for in the report of the time window they're woken up in.
+func (a *Analyzer) Flush() *Report {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	r := a.snapshot()
+	a.reset()
+	return r
+}
+
+// Close stops the analysis. The pending events are analyzed, the report can
+// still be retrieved after Close.
+func (a *Analyzer) Close() {
+	if a.tracer != nil {
+		a.tracer.Close()
+	}
+}
+
+// Profile returns the blocked time per stack of r as a profile, one sample
+// per microsecond blocked. The profile can be written with an
+// obs.ProfileEncoder to build off-CPU flame graphs.
+func (r *Report) Profile() *obs.Profile {
+	p := &obs.Profile{
+		Period: uint64(time.Microsecond),
+		Start:  r.Start,
+		End:    r.End,
+	}
+	for i := range r.Stacks {
+		s := &r.Stacks[i]
+		p.Samples = append(p.Samples, obs.ProfileSample{
+			PID:    s.PID,
+			TID:    s.TID,
+			Comm:   s.Comm,
+			Kernel: s.Kernel,
+			User:   s.User,
+			Count:  uint64(s.Blocked / time.Microsecond),
+		})
+	}
+	return p
+}
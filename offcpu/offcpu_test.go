@@ -0,0 +1,156 @@
+package offcpu
+
+import (
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/dlespiau/obs"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	taskRunning       = 0
+	taskInterruptible = 1
+	taskPreempted     = 0x100
+	exitDead          = 0x10
+)
+
+func TestAnalyzer(t *testing.T) {
+	a := NewAnalyzer(WithCallchains())
+
+	stackA := []uint64{0xffffffff81000010}
+	stackB := []uint64{0xffffffff81000020}
+	waker := []uint64{0xffffffff81000030}
+
+	// foo (10) blocks in stackA twice, 100ns then 300ns, the first time woken
+	// up by bar (20), the second time by an interrupt on an idle CPU.
+	a.sched(&obs.SchedSwitch{Time: 1000, PrevTID: 10, PrevPID: 10, PrevComm: "foo", PrevState: taskInterruptible, NextTID: 20, NextComm: "bar", Kernel: stackA})
+	a.wakeup(&obs.SchedWakeup{Time: 1050, WakerTID: 20, TID: 10, Kernel: waker})
+	// Only the first wakeup counts.
+	a.wakeup(&obs.SchedWakeup{Time: 1060, WakerTID: 30, TID: 10})
+	a.sched(&obs.SchedSwitch{Time: 1100, PrevTID: 20, PrevComm: "bar", PrevState: taskPreempted, NextTID: 10, NextComm: "foo"})
+	a.sched(&obs.SchedSwitch{Time: 2000, PrevTID: 10, PrevPID: 10, PrevComm: "foo", PrevState: taskInterruptible, NextTID: 0, NextComm: "swapper/0", Kernel: stackA})
+	a.wakeup(&obs.SchedWakeup{Time: 2250, WakerTID: 0, TID: 10})
+	a.sched(&obs.SchedSwitch{Time: 2300, PrevTID: 0, PrevComm: "swapper/0", PrevState: taskRunning, NextTID: 10, NextComm: "foo"})
+	// bar (20) was preempted, it's not blocked.
+	a.sched(&obs.SchedSwitch{Time: 2400, PrevTID: 10, PrevComm: "foo", PrevState: taskRunning, NextTID: 20, NextComm: "bar"})
+	// bar blocks in stackB for 1000ns, without being woken up by a
+	// sched_wakeup we've seen.
+	a.sched(&obs.SchedSwitch{Time: 3000, PrevTID: 20, PrevPID: 10, PrevComm: "bar", PrevState: taskInterruptible, NextTID: 10, NextComm: "foo", Kernel: stackB})
+	a.sched(&obs.SchedSwitch{Time: 4000, PrevTID: 10, PrevComm: "foo", PrevState: exitDead, NextTID: 20, NextComm: "bar"})
+
+	r := a.Report()
+	assert.Equal(t, uint64(1000), r.Start)
+	assert.Equal(t, uint64(4000), r.End)
+	assert.Equal(t, 1400*time.Nanosecond, r.Blocked)
+	assert.Equal(t, uint64(3), r.Histogram.Count())
+
+	assert.Len(t, r.Threads, 2)
+	assert.Equal(t, 20, r.Threads[0].TID)
+	assert.Equal(t, "bar", r.Threads[0].Comm)
+	assert.Equal(t, 1000*time.Nanosecond, r.Threads[0].Blocked)
+	assert.Equal(t, 10, r.Threads[1].TID)
+	assert.Equal(t, 400*time.Nanosecond, r.Threads[1].Blocked)
+	assert.Equal(t, uint64(2), r.Threads[1].Histogram.Count())
+
+	assert.Equal(t, []Stack{
+		{TID: 20, PID: 10, Comm: "bar", Kernel: stackB, Blocked: 1000, Count: 1},
+		{TID: 10, PID: 10, Comm: "foo", Kernel: stackA, Blocked: 400, Count: 2},
+	}, r.Stacks)
+
+	assert.Equal(t, []Wakeup{
+		{WakerTID: 0, WakerComm: "swapper/0", TID: 10, Comm: "foo", Blocked: 300, Count: 1},
+		{WakerTID: 20, WakerComm: "bar", WakerKernel: waker, TID: 10, Comm: "foo", Blocked: 100, Count: 1},
+	}, r.Wakeups)
+
+	// The exited thread has been forgotten.
+	_, ok := a.comms[10]
+	assert.False(t, ok)
+
+	p := r.Profile()
+	assert.Len(t, p.Samples, 2)
+	// Threads are sampled as part of their process.
+	assert.Equal(t, 10, p.Samples[0].PID)
+	assert.Equal(t, 20, p.Samples[0].TID)
+	assert.Equal(t, uint64(1000), p.Period)
+
+	r = a.Flush()
+	assert.Len(t, r.Threads, 2)
+	r = a.Report()
+	assert.Len(t, r.Threads, 0)
+}
+
+func TestAnalyzerChains(t *testing.T) {
+	a := NewAnalyzer()
+
+	// An interrupt wakes baz (30) up, which wakes bar (20) up, which wakes
+	// foo (10) up.
+	a.sched(&obs.SchedSwitch{Time: 1000, PrevTID: 10, PrevComm: "foo", PrevState: taskInterruptible})
+	a.sched(&obs.SchedSwitch{Time: 1000, PrevTID: 20, PrevComm: "bar", PrevState: taskInterruptible})
+	a.sched(&obs.SchedSwitch{Time: 1000, PrevTID: 30, PrevComm: "baz", PrevState: taskInterruptible, NextTID: 0, NextComm: "swapper/0"})
+	a.wakeup(&obs.SchedWakeup{Time: 1100, WakerTID: 0, TID: 30})
+	a.sched(&obs.SchedSwitch{Time: 1200, PrevTID: 0, PrevComm: "swapper/0", PrevState: taskRunning, NextTID: 30, NextComm: "baz"})
+	a.wakeup(&obs.SchedWakeup{Time: 1300, WakerTID: 30, TID: 20})
+	a.sched(&obs.SchedSwitch{Time: 1400, PrevTID: 30, PrevComm: "baz", PrevState: taskInterruptible, NextTID: 20, NextComm: "bar"})
+	a.wakeup(&obs.SchedWakeup{Time: 1500, WakerTID: 20, TID: 10})
+	a.sched(&obs.SchedSwitch{Time: 1600, PrevTID: 20, PrevComm: "bar", PrevState: taskInterruptible, NextTID: 10, NextComm: "foo"})
+
+	r := a.Report()
+	assert.Equal(t, []Wakeup{
+		{WakerTID: 20, WakerComm: "bar", Chain: []Waker{{30, "baz"}, {0, "swapper/0"}}, TID: 10, Comm: "foo", Blocked: 600, Count: 1},
+		{WakerTID: 30, WakerComm: "baz", Chain: []Waker{{0, "swapper/0"}}, TID: 20, Comm: "bar", Blocked: 400, Count: 1},
+		{WakerTID: 0, WakerComm: "swapper/0", TID: 30, Comm: "baz", Blocked: 200, Count: 1},
+	}, r.Wakeups)
+
+	// bar blocked again without a wakeup we've seen, its chain is gone.
+	a.sched(&obs.SchedSwitch{Time: 1700, PrevTID: 10, PrevComm: "foo", PrevState: taskRunning, NextTID: 20, NextComm: "bar"})
+	_, ok := a.chains[20]
+	assert.False(t, ok)
+}
+
+func TestAnalyzerOutOfOrder(t *testing.T) {
+	a := NewAnalyzer()
+
+	// A switch in older than the switch out is ignored.
+	a.sched(&obs.SchedSwitch{Time: 2000, PrevTID: 10, PrevState: taskInterruptible})
+	a.sched(&obs.SchedSwitch{Time: 1000, NextTID: 10})
+
+	r := a.Report()
+	assert.Len(t, r.Threads, 0)
+	assert.Len(t, a.blocked, 0)
+}
+
+func TestAnalyzerSleep(t *testing.T) {
+	a := NewAnalyzer()
+	if err := a.Open(); err != nil {
+		t.Skipf("unable to open the sched tracepoints: %v", err)
+	}
+
+	tid := make(chan int)
+	go func() {
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+
+		for i := 0; i < 5; i++ {
+			unix.Nanosleep(&unix.Timespec{Nsec: int64(10 * time.Millisecond)}, nil)
+		}
+		tid <- unix.Gettid()
+	}()
+	sleeper := <-tid
+
+	// Let the analyzer catch up with the last events.
+	time.Sleep(200 * time.Millisecond)
+	a.Close()
+
+	r := a.Report()
+	found := false
+	for _, thread := range r.Threads {
+		if thread.TID == sleeper {
+			found = true
+			assert.True(t, thread.Blocked >= 40*time.Millisecond, "blocked %v", thread.Blocked)
+		}
+	}
+	assert.True(t, found)
+}
@@ -0,0 +1,142 @@
+package obs
+
+// EventOption configures an event source when adding it to an Observer.
+type EventOption func(*eventOptions)
+
+// eventOptions is the set of options an event source can be configured with.
+type eventOptions struct {
+	// layout is the tracepoint data layout the user expects.
+	layout []FieldLayout
+	// samplePeriod is the number of events between two samples or, when
+	// sampleFreq is true, the number of samples per second.
+	samplePeriod uint64
+	sampleFreq   bool
+	// counting sources count events instead of sampling them.
+	counting bool
+	// sideBand are the side-band records the source emits.
+	sideBand SideBand
+	// callchain records the stack of the task that hit the tracepoint.
+	callchain bool
+	// cgroup is the path of the cgroup the source is restricted to.
+	cgroup string
+	// pid is the process the source is restricted to, inherit extending
+	// it to the children of the process.
+	pid     int
+	inherit bool
+	// enableOnExec sources only start when pid calls exec().
+	enableOnExec bool
+}
+
+func newEventOptions(opts []EventOption) *eventOptions {
+	options := &eventOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	return options
+}
+
+// WithLayout makes Open fail if the running kernel doesn't lay out the
+// tracepoint data as described by layout. Only the fields listed in layout are
+// checked.
+//
+// This is used by code generated with cmd/tpgen to ensure the generated
+// decoders are still valid.
+func WithLayout(layout []FieldLayout) EventOption {
+	return func(o *eventOptions) {
+		o.layout = layout
+	}
+}
+
+// WithSamplePeriod samples one event every period events. By default, software
+// events are sampled at 4000 Hz, see WithSampleFrequency.
+func WithSamplePeriod(period uint64) EventOption {
+	return func(o *eventOptions) {
+		o.samplePeriod = period
+		o.sampleFreq = false
+	}
+}
+
+// WithSampleFrequency samples events at the specified frequency, in Hz. The
+// kernel adjusts the sample period dynamically to reach that frequency.
+func WithSampleFrequency(hz uint64) EventOption {
+	return func(o *eventOptions) {
+		o.samplePeriod = hz
+		o.sampleFreq = true
+	}
+}
+
+// WithCounting makes the source count events instead of sampling them. No
+// event is ever received from a counting source, its value is read with
+// Observer.Count. The sample period and frequency are ignored.
+func WithCounting() EventOption {
+	return func(o *eventOptions) {
+		o.counting = true
+	}
+}
+
+// SideBand is a set of side-band records. Side-band records describe changes in
+// the system, eg. a process being forked or a file mmap'ed, and allow keeping
+// track of the state of the system without racing with /proc.
+type SideBand int
+
+const (
+	// SideBandMmap emits a MmapEvent every time a file is mmap'ed with
+	// PROT_EXEC.
+	SideBandMmap SideBand = 1 << iota
+	// SideBandComm emits a CommEvent every time a process changes its name,
+	// including on exec().
+	SideBandComm
+	// SideBandTask emits a ForkEvent and an ExitEvent when tasks are created
+	// and exit.
+	SideBandTask
+	// SideBandSwitch emits a SwitchEvent on every context switch.
+	SideBandSwitch
+)
+
+// WithSideBand makes the source emit the side-band records on top of its
+// samples. The side-band events are received, as any other event, with
+// Observer.ReadEvent and carry the source ID. As side-band records are system
+// wide, they should only be requested on a single source, eg. a Dummy software
+// event.
+func WithSideBand(records SideBand) EventOption {
+	return func(o *eventOptions) {
+		o.sideBand = records
+	}
+}
+
+// WithCallchain records, along with the tracepoint data, the kernel and user
+// space stacks of the task hitting the tracepoint. They are retrieved with
+// TracepointEvent.Callchain.
+func WithCallchain() EventOption {
+	return func(o *eventOptions) {
+		o.callchain = true
+	}
+}
+
+// WithCgroup restricts the source to the tasks of a cgroup, given as the path
+// of its directory, eg. "/sys/fs/cgroup/kubepods/pod1234". The cgroup is
+// either a cgroup v2 directory or a directory of the perf_event controller
+// hierarchy on cgroup v1 systems. The tasks of the descendants of the cgroup
+// are monitored as well.
+func WithCgroup(path string) EventOption {
+	return func(o *eventOptions) {
+		o.cgroup = path
+	}
+}
+
+// WithPID restricts the source to the process pid. Only the thread pid is
+// monitored, WithInherit extends the source to the threads and processes it
+// creates afterwards. WithPID takes precedence over WithCgroup.
+func WithPID(pid int) EventOption {
+	return func(o *eventOptions) {
+		o.pid = pid
+	}
+}
+
+// WithInherit makes a source restricted to a process with WithPID monitor the
+// threads and children the process creates after Open as well.
+func WithInherit() EventOption {
+	return func(o *eventOptions) {
+		o.inherit = true
+	}
+}
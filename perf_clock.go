@@ -0,0 +1,32 @@
+package obs
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// perfClock is the offset, in nanoseconds, between the wall clock and the
+// clock perf timestamps events with.
+var perfClock struct {
+	once   sync.Once
+	offset int64
+}
+
+// perfTime converts a perf timestamp to a wall clock time.
+//
+// perf timestamps events with the kernel local clock, the same clock the
+// scheduler uses. It's counting nanoseconds since boot and follows
+// CLOCK_MONOTONIC closely, the offset between the two clocks is measured once.
+func perfTime(ts uint64) time.Time {
+	perfClock.once.Do(func() {
+		var mono unix.Timespec
+		now := time.Now()
+		if err := unix.ClockGettime(unix.CLOCK_MONOTONIC, &mono); err != nil {
+			return
+		}
+		perfClock.offset = now.UnixNano() - mono.Nano()
+	})
+	return time.Unix(0, perfClock.offset+int64(ts))
+}
@@ -2,6 +2,7 @@ package obs
 
 import (
 	"fmt"
+	"os"
 	"unsafe"
 
 	"golang.org/x/sys/unix"
@@ -17,19 +18,86 @@ import (
 #include <sys/resource.h>
 #include <stdlib.h>
 
-void create_perf_event_attr(int type, int config, int sample_type,
-			    int wakeup_events, void *attr)
+enum {
+	PARAM_FREQ           = 1 << 0,
+	PARAM_DISABLED       = 1 << 1,
+	PARAM_EXCLUDE_USER   = 1 << 2,
+	PARAM_EXCLUDE_KERNEL = 1 << 3,
+	PARAM_EXCLUDE_HV     = 1 << 4,
+	PARAM_MMAP           = 1 << 5,
+	PARAM_COMM           = 1 << 6,
+	PARAM_TASK           = 1 << 7,
+	PARAM_CONTEXT_SWITCH = 1 << 8,
+	PARAM_INHERIT        = 1 << 9,
+	PARAM_ENABLE_ON_EXEC = 1 << 10,
+};
+
+struct perf_event_params {
+	uint32_t type;
+	uint64_t config;
+	uint64_t config1;
+	uint64_t config2;
+	uint64_t config3;
+	uint64_t sample_type;
+	uint64_t sample_period;
+	uint64_t read_format;
+	uint32_t wakeup_events;
+	uint32_t flags;
+};
+
+// config3 has been added to perf_event_attr in Linux 6.3, the headers we
+// build with may not know about it. perf_event_attr_buf leaves room for it.
+#define PERF_ATTR_SIZE_VER8	136
+#define PERF_ATTR_CONFIG3	128
+
+struct perf_event_attr_buf {
+	struct perf_event_attr attr;
+	uint8_t pad[PERF_ATTR_SIZE_VER8];
+};
+
+void create_perf_event_attr(struct perf_event_params *params, void *attr)
 {
 	struct perf_event_attr *ptr = (struct perf_event_attr *) attr;
 
-	memset(ptr, 0, sizeof(*ptr));
+	memset(ptr, 0, sizeof(struct perf_event_attr_buf));
 
-	ptr->type = type;
+	ptr->type = params->type;
 	ptr->size = sizeof(*ptr);
-	ptr->config = config;
-	ptr->sample_type = sample_type;
-	ptr->sample_period = 1;
-	ptr->wakeup_events = wakeup_events;
+	ptr->config = params->config;
+	ptr->config1 = params->config1;
+	ptr->config2 = params->config2;
+	// Only grow the attr when needed, older kernels reject the sizes they
+	// don't know of.
+	if (params->config3) {
+		memcpy((uint8_t *) attr + PERF_ATTR_CONFIG3, &params->config3,
+		       sizeof(params->config3));
+		if (ptr->size < PERF_ATTR_SIZE_VER8)
+			ptr->size = PERF_ATTR_SIZE_VER8;
+	}
+	ptr->sample_type = params->sample_type;
+	ptr->sample_period = params->sample_period;
+	ptr->read_format = params->read_format;
+	ptr->wakeup_events = params->wakeup_events;
+	ptr->freq = !!(params->flags & PARAM_FREQ);
+	ptr->disabled = !!(params->flags & PARAM_DISABLED);
+	ptr->exclude_user = !!(params->flags & PARAM_EXCLUDE_USER);
+	ptr->exclude_kernel = !!(params->flags & PARAM_EXCLUDE_KERNEL);
+	ptr->exclude_hv = !!(params->flags & PARAM_EXCLUDE_HV);
+	ptr->mmap = ptr->mmap2 = !!(params->flags & PARAM_MMAP);
+	ptr->comm = ptr->comm_exec = !!(params->flags & PARAM_COMM);
+	ptr->task = !!(params->flags & PARAM_TASK);
+	ptr->context_switch = !!(params->flags & PARAM_CONTEXT_SWITCH);
+	ptr->inherit = !!(params->flags & PARAM_INHERIT);
+	ptr->enable_on_exec = !!(params->flags & PARAM_ENABLE_ON_EXEC);
+	ptr->sample_id_all = !!(params->flags & (PARAM_MMAP | PARAM_COMM |
+						 PARAM_TASK | PARAM_CONTEXT_SWITCH));
+
+	// wakeup_events only counts samples, wake up on any record when
+	// side-band records are wanted.
+	if (ptr->sample_id_all) {
+		ptr->watermark = 1;
+		ptr->wakeup_watermark = 1;
+	}
 }
 
 static void dump_data(uint8_t *data, size_t size, int cpu)
@@ -157,6 +225,30 @@ const (
 	perfSampleRegsIntr
 )
 
+// perfFormat is the layout of the data returned when reading a counter.
+type perfFormat uint64
+
+// These constants are linux ABI, defined as PERF_FORMAT_* in
+// <linux/perf_event.h>.
+const (
+	perfFormatTotalTimeEnabled perfFormat = 1 << iota
+	perfFormatTotalTimeRunning
+	perfFormatID
+	perfFormatGroup
+)
+
+// perfFlag are the flags of perf_event_open().
+type perfFlag int
+
+// These constants are linux ABI, defined as PERF_FLAG_* in
+// <linux/perf_event.h>.
+const (
+	perfFlagFDNoGroup perfFlag = 1 << iota
+	perfFlagFDOutput
+	perfFlagPIDCgroup
+	perfFlagFDCloexec
+)
+
 // perfEventHeader is ABI, struct perf_event_header in <linux/perf_event.h>.
 type perfEventHeader struct {
 	kind      uint32
@@ -171,6 +263,11 @@ type perfEventSample struct {
 	data byte // First byte of data blob of size bytes
 }
 
+// record returns the whole sample record, header included.
+func (e *perfEventSample) record() []byte {
+	return (*[1 << 30]byte)(unsafe.Pointer(e))[:int(e.totalSize):int(e.totalSize)]
+}
+
 func (e *perfEventSample) DataDirect() []byte {
 	// http://stackoverflow.com/questions/27532523/how-to-convert-1024c-char-to-1024byte
 	return (*[1 << 30]byte)(unsafe.Pointer(&e.data))[:int(e.size):int(e.size)]
@@ -188,12 +285,48 @@ type perfEventLost struct {
 }
 
 type perfEventConfig struct {
-	nCpus        int
-	nPages       int
-	eventType    perfType
-	config       int
-	sampleType   perfSample
+	nCpus     int
+	nPages    int
+	eventType perfType
+	config    uint64
+	// config1, config2 and config3 extend config for the events that need
+	// more than 64 bits of configuration.
+	config1    uint64
+	config2    uint64
+	config3    uint64
+	sampleType perfSample
+	// samplePeriod is the number of events between two samples or, when
+	// sampleFreq is true, the number of samples per second. 0 means 1 for
+	// sampling events, counting events ignore it.
+	samplePeriod uint64
+	sampleFreq   bool
+	// readFormat is the layout of the data returned by read().
+	readFormat   perfFormat
 	wakeupEvents int
+	// counting events don't sample, they only count events. No ring buffer
+	// is set up for them.
+	counting bool
+	// disabled events are created disabled and need to be explicitly
+	// enabled.
+	disabled bool
+	// exclude* don't count the events happening in user space, kernel space
+	// or in the hypervisor.
+	excludeUser   bool
+	excludeKernel bool
+	excludeHV     bool
+	// sideBand are the side-band records the event emits on top of samples.
+	sideBand SideBand
+	// cgroup is the path of the cgroup directory the event is restricted
+	// to, "" to monitor all tasks.
+	cgroup string
+	// pid is the task the event is restricted to, 0 to monitor all tasks.
+	pid int
+	// inherit extends the event to the children pid creates after the
+	// event is opened.
+	inherit bool
+	// enableOnExec events are created disabled and enabled when pid calls
+	// exec().
+	enableOnExec bool
 }
 
 type perfEvent struct {
@@ -204,21 +337,78 @@ type perfEvent struct {
 	lost     uint64
 	unknown  uint64
 	data     []byte
+	buf      []byte
 }
 
 type perfReceiveFunc func(msg *perfEventSample, cpu int)
 type perfLostFunc func(msg *perfEventLost, cpu int)
 
+// perfRecordFunc receives the records that are neither samples nor lost
+// records. record is the whole record, header included.
+type perfRecordFunc func(record []byte, cpu int)
+
+// perfEventParams translates config into the parameters of the
+// perf_event_attr we give to the kernel.
+func perfEventParams(config *perfEventConfig) C.struct_perf_event_params {
+	params := C.struct_perf_event_params{
+		_type:         C.uint32_t(config.eventType),
+		config:        C.uint64_t(config.config),
+		config1:       C.uint64_t(config.config1),
+		config2:       C.uint64_t(config.config2),
+		config3:       C.uint64_t(config.config3),
+		sample_type:   C.uint64_t(config.sampleType),
+		sample_period: C.uint64_t(config.samplePeriod),
+		read_format:   C.uint64_t(config.readFormat),
+		wakeup_events: C.uint32_t(config.wakeupEvents),
+	}
+	// A sample period turns the event into a sampling event, overflowing
+	// every period events. Counting events must not have one, the
+	// overflow interrupts would end up throttling the event.
+	if params.sample_period == 0 && !config.counting {
+		params.sample_period = 1
+	}
+	if config.sampleFreq {
+		params.flags |= C.PARAM_FREQ
+	}
+	if config.disabled || config.enableOnExec {
+		params.flags |= C.PARAM_DISABLED
+	}
+	if config.inherit {
+		params.flags |= C.PARAM_INHERIT
+	}
+	if config.enableOnExec {
+		params.flags |= C.PARAM_ENABLE_ON_EXEC
+	}
+	if config.excludeUser {
+		params.flags |= C.PARAM_EXCLUDE_USER
+	}
+	if config.excludeKernel {
+		params.flags |= C.PARAM_EXCLUDE_KERNEL
+	}
+	if config.excludeHV {
+		params.flags |= C.PARAM_EXCLUDE_HV
+	}
+	if config.sideBand&SideBandMmap != 0 {
+		params.flags |= C.PARAM_MMAP
+	}
+	if config.sideBand&SideBandComm != 0 {
+		params.flags |= C.PARAM_COMM
+	}
+	if config.sideBand&SideBandTask != 0 {
+		params.flags |= C.PARAM_TASK
+	}
+	if config.sideBand&SideBandSwitch != 0 {
+		params.flags |= C.PARAM_CONTEXT_SWITCH
+	}
+
+	return params
+}
+
 func perfEventOpen(config *perfEventConfig, pid int, cpu int, groupFD int, flags int) (*perfEvent, error) {
-	attr := C.struct_perf_event_attr{}
+	attr := C.struct_perf_event_attr_buf{}
+	params := perfEventParams(config)
 
-	C.create_perf_event_attr(
-		C.int(config.eventType),
-		C.int(config.config),
-		C.int(config.sampleType),
-		C.int(config.wakeupEvents),
-		unsafe.Pointer(&attr),
-	)
+	C.create_perf_event_attr(&params, unsafe.Pointer(&attr))
 
 	ret, _, err := unix.Syscall6(
 		unix.SYS_PERF_EVENT_OPEN,
@@ -234,7 +424,8 @@ func perfEventOpen(config *perfEventConfig, pid int, cpu int, groupFD int, flags
 			fd:  int(ret),
 		}, nil
 	}
-	return nil, fmt.Errorf("Unable to open perf event: %s", err)
+	// Keep the errno around, to tell permission errors apart.
+	return nil, os.NewSyscallError("perf_event_open", err)
 }
 
 func (e *perfEvent) mmap(pageSize int, nPages int) error {
@@ -276,8 +467,13 @@ func (e *perfEvent) disable() error {
 	return nil
 }
 
-func (e *perfEvent) read(receive perfReceiveFunc, lostFn perfLostFunc) {
-	buf := make([]byte, 256)
+func (e *perfEvent) read(receive perfReceiveFunc, lostFn perfLostFunc, recordFn perfRecordFunc) {
+	// Records wrapping around the end of the ring buffer are copied to buf.
+	// The size of a record is a 16-bit value.
+	if e.buf == nil {
+		e.buf = make([]byte, 1<<16)
+	}
+	buf := e.buf
 	state := C.malloc(C.size_t(unsafe.Sizeof(C.struct_read_state{})))
 
 	// Prepare for reading and check if events are available
@@ -308,6 +504,10 @@ func (e *perfEvent) read(receive perfReceiveFunc, lostFn perfLostFunc) {
 			if lostFn != nil {
 				lostFn(lost, e.cpu)
 			}
+		} else if recordFn != nil {
+			var record *perfEventSample
+			C.cast(unsafe.Pointer(msg), unsafe.Pointer(&record))
+			recordFn(record.record(), e.cpu)
 		} else {
 			e.unknown++
 		}
@@ -318,6 +518,56 @@ func (e *perfEvent) read(receive perfReceiveFunc, lostFn perfLostFunc) {
 	C.free(state)
 }
 
+// readCount reads the value of a counting event.
+func (e *perfEvent) readCount() (uint64, error) {
+	var buf [8]byte
+
+	if _, err := unix.Read(e.fd, buf[:]); err != nil {
+		return 0, fmt.Errorf("Unable to read perf event: %v", err)
+	}
+
+	return NativeEndian.Uint64(buf[:]), nil
+}
+
+// readGroup reads the values of the group e is the leader of. e must have been
+// opened with the perfFormatGroup read format. buf must be large enough to
+// hold the whole group.
+func (e *perfEvent) readGroup(buf []byte) ([]byte, error) {
+	n, err := unix.Read(e.fd, buf)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read perf event: %v", err)
+	}
+
+	return buf[:n], nil
+}
+
+// enableGroup enables the group e is the leader of.
+func (e *perfEvent) enableGroup() error {
+	if err := unix.IoctlSetInt(e.fd, unix.PERF_EVENT_IOC_ENABLE, unix.PERF_IOC_FLAG_GROUP); err != nil {
+		return fmt.Errorf("Unable to enable perf event: %v", err)
+	}
+
+	return nil
+}
+
+// disableGroup disables the group e is the leader of.
+func (e *perfEvent) disableGroup() error {
+	if err := unix.IoctlSetInt(e.fd, unix.PERF_EVENT_IOC_DISABLE, unix.PERF_IOC_FLAG_GROUP); err != nil {
+		return fmt.Errorf("Unable to disable perf event: %v", err)
+	}
+
+	return nil
+}
+
+// resetGroup resets the counters of the group e is the leader of.
+func (e *perfEvent) resetGroup() error {
+	if err := unix.IoctlSetInt(e.fd, unix.PERF_EVENT_IOC_RESET, unix.PERF_IOC_FLAG_GROUP); err != nil {
+		return fmt.Errorf("Unable to reset perf event: %v", err)
+	}
+
+	return nil
+}
+
 func (e *perfEvent) close() {
 	unix.Close(e.fd)
 }
@@ -0,0 +1,130 @@
+package obs
+
+import (
+	"errors"
+	"fmt"
+)
+
+// perfSampleRecord is a decoded PERF_RECORD_SAMPLE. Only the fields selected
+// by the sample type of the event are filled.
+type perfSampleRecord struct {
+	ip        uint64
+	pid       uint32
+	tid       uint32
+	time      uint64
+	addr      uint64
+	id        uint64
+	streamID  uint64
+	cpu       uint32
+	period    uint64
+	callchain []uint64
+	raw       []byte
+}
+
+var errShortSample = errors.New("perf: sample record is too short")
+
+// sampleReader reads the successive fields of a sample record.
+type sampleReader struct {
+	data []byte
+	err  error
+}
+
+func (r *sampleReader) u64() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	if len(r.data) < 8 {
+		r.err = errShortSample
+		return 0
+	}
+	v := NativeEndian.Uint64(r.data)
+	r.data = r.data[8:]
+	return v
+}
+
+func (r *sampleReader) u32() uint32 {
+	if r.err != nil {
+		return 0
+	}
+	if len(r.data) < 4 {
+		r.err = errShortSample
+		return 0
+	}
+	v := NativeEndian.Uint32(r.data)
+	r.data = r.data[4:]
+	return v
+}
+
+func (r *sampleReader) bytes(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || len(r.data) < n {
+		r.err = errShortSample
+		return nil
+	}
+	v := r.data[:n]
+	r.data = r.data[n:]
+	return v
+}
+
+// parseSample decodes the body of a PERF_RECORD_SAMPLE, ie. the record
+// without its header, as laid out for sampleType. The fields are laid out in
+// the order described in the perf_event_open(2) man page. The returned record
+// references data.
+func parseSample(sampleType perfSample, data []byte) (perfSampleRecord, error) {
+	var s perfSampleRecord
+	r := sampleReader{data: data}
+
+	if sampleType&^(perfSampleIdentifier|perfSampleIP|perfSampleTID|perfSampleTime|
+		perfSampleAddr|perfSampleID|perfSampleStreamID|perfSampleCPU|perfSamplePeriod|
+		perfSampleCallchain|perfSampleRaw) != 0 {
+		return s, fmt.Errorf("perf: unsupported sample type %#x", sampleType)
+	}
+
+	if sampleType&perfSampleIdentifier != 0 {
+		s.id = r.u64()
+	}
+	if sampleType&perfSampleIP != 0 {
+		s.ip = r.u64()
+	}
+	if sampleType&perfSampleTID != 0 {
+		s.pid = r.u32()
+		s.tid = r.u32()
+	}
+	if sampleType&perfSampleTime != 0 {
+		s.time = r.u64()
+	}
+	if sampleType&perfSampleAddr != 0 {
+		s.addr = r.u64()
+	}
+	if sampleType&perfSampleID != 0 {
+		s.id = r.u64()
+	}
+	if sampleType&perfSampleStreamID != 0 {
+		s.streamID = r.u64()
+	}
+	if sampleType&perfSampleCPU != 0 {
+		s.cpu = r.u32()
+		r.u32() // reserved
+	}
+	if sampleType&perfSamplePeriod != 0 {
+		s.period = r.u64()
+	}
+	if sampleType&perfSampleCallchain != 0 {
+		nr := r.u64()
+		if nr > uint64(len(r.data)/8) {
+			return s, errShortSample
+		}
+		s.callchain = make([]uint64, nr)
+		for i := range s.callchain {
+			s.callchain[i] = r.u64()
+		}
+	}
+	if sampleType&perfSampleRaw != 0 {
+		size := r.u32()
+		s.raw = r.bytes(int(size))
+	}
+
+	return s, r.err
+}
@@ -0,0 +1,68 @@
+package obs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// sampleBuilder builds sample records in the native byte order.
+type sampleBuilder []byte
+
+func (b *sampleBuilder) u64(v uint64) *sampleBuilder {
+	buf := make([]byte, 8)
+	NativeEndian.PutUint64(buf, v)
+	*b = append(*b, buf...)
+	return b
+}
+
+func (b *sampleBuilder) u32(v uint32) *sampleBuilder {
+	buf := make([]byte, 4)
+	NativeEndian.PutUint32(buf, v)
+	*b = append(*b, buf...)
+	return b
+}
+
+func (b *sampleBuilder) bytes(v []byte) *sampleBuilder {
+	*b = append(*b, v...)
+	return b
+}
+
+func TestParseSample(t *testing.T) {
+	var ipTIDTimeCPU, callchainRaw, short sampleBuilder
+
+	ipTIDTimeCPU.u64(0xffffffff81000000).u32(42).u32(43).u64(123456789).u32(3).u32(0)
+	callchainRaw.u64(2).u64(0xfffffffffffffe00).u64(0x400000).u32(4).bytes([]byte{1, 2, 3, 4})
+	short.u64(3).u64(1)
+
+	tests := []struct {
+		sampleType perfSample
+		data       []byte
+		valid      bool
+		expected   perfSampleRecord
+	}{
+		{
+			perfSampleIP | perfSampleTID | perfSampleTime | perfSampleCPU, ipTIDTimeCPU, valid,
+			perfSampleRecord{ip: 0xffffffff81000000, pid: 42, tid: 43, time: 123456789, cpu: 3},
+		}, {
+			perfSampleCallchain | perfSampleRaw, callchainRaw, valid,
+			perfSampleRecord{
+				callchain: []uint64{0xfffffffffffffe00, 0x400000},
+				raw:       []byte{1, 2, 3, 4},
+			},
+		},
+		{perfSampleCallchain, short, invalid, perfSampleRecord{}},
+		{perfSampleIP | perfSampleTID | perfSampleTime | perfSampleCPU, ipTIDTimeCPU[:12], invalid, perfSampleRecord{}},
+		{perfSampleBranchStack, nil, invalid, perfSampleRecord{}},
+	}
+
+	for _, test := range tests {
+		sample, err := parseSample(test.sampleType, test.data)
+		if !test.valid {
+			assert.NotNil(t, err)
+			continue
+		}
+		assert.Nil(t, err)
+		assert.Equal(t, test.expected, sample)
+	}
+}
@@ -9,23 +9,28 @@ import (
 // perfSystemEvent is a system-wide event. perf doesn't allow a single event,
 // one opened with perf_event_open(), to be both: for all pids and for all cpus.
 // perfSystemEvent abstract that detail away, creating a perfEvent listening for
-// all PIDs for each online CPU.
+// all PIDs for each online CPU. The event can be restricted to the tasks of a
+// cgroup, cgroup events being per-CPU as well, or to a task. Task events are
+// opened on each CPU too, the kernel doesn't allow mapping the ring buffer of
+// inherited events following the task on all CPUs.
 type perfSystemEvent struct {
-	cpus      int
-	nPages    int
-	pageSize  int
-	fdToEvent map[int]*perfEvent
-	epoll     epoll
+	cpus       int
+	nPages     int
+	sampleType perfSample
+	pageSize   int
+	fdToEvent  map[int]*perfEvent
+	epoll      epoll
 }
 
 func newPerfSystemEvent(config *perfEventConfig) (*perfSystemEvent, error) {
 	var err error
 
 	e := &perfSystemEvent{
-		cpus:      config.nCpus,
-		nPages:    config.nPages,
-		pageSize:  os.Getpagesize(),
-		fdToEvent: make(map[int]*perfEvent),
+		cpus:       config.nCpus,
+		nPages:     config.nPages,
+		sampleType: config.sampleType,
+		pageSize:   os.Getpagesize(),
+		fdToEvent:  make(map[int]*perfEvent),
 	}
 
 	defer func() {
@@ -38,21 +43,45 @@ func newPerfSystemEvent(config *perfEventConfig) (*perfSystemEvent, error) {
 		return nil, err
 	}
 
-	for cpu := int(0); cpu < e.cpus; cpu++ {
-		event, err := perfEventOpen(config, -1, cpu, -1, 0)
+	// Cgroup events are given a file descriptor of the cgroup directory
+	// instead of a PID. perf holds a reference to the cgroup, the directory
+	// can be closed once the events are opened.
+	pid, flags := -1, 0
+	if config.pid != 0 {
+		pid = config.pid
+	} else if config.cgroup != "" {
+		var cgroup *os.File
+		cgroup, err = os.Open(config.cgroup)
 		if err != nil {
 			return nil, err
 		}
-		e.fdToEvent[event.fd] = event
+		defer cgroup.Close()
+		pid, flags = int(cgroup.Fd()), int(perfFlagPIDCgroup)
+	}
 
-		if err = e.epoll.addFd(event.fd, unix.EPOLLIN); err != nil {
+	for cpu := int(0); cpu < e.cpus; cpu++ {
+		var event *perfEvent
+
+		event, err = perfEventOpen(config, pid, cpu, -1, flags)
+		if err != nil {
 			return nil, err
 		}
+		e.fdToEvent[event.fd] = event
 
-		if err = event.mmap(e.pageSize, e.nPages); err != nil {
-			return nil, err
+		if !config.counting {
+			if err = e.epoll.addFd(event.fd, unix.EPOLLIN); err != nil {
+				return nil, err
+			}
+
+			if err = event.mmap(e.pageSize, e.nPages); err != nil {
+				return nil, err
+			}
 		}
 
+		// The kernel enables enable_on_exec events.
+		if config.enableOnExec {
+			continue
+		}
 		if err = event.enable(); err != nil {
 			return nil, err
 		}
@@ -65,17 +94,47 @@ func (e *perfSystemEvent) poll(timeout int) (int, error) {
 	return e.epoll.poll(timeout)
 }
 
-func (e *perfSystemEvent) read(receive perfReceiveFunc, lost perfLostFunc) error {
+func (e *perfSystemEvent) read(receive perfReceiveFunc, lost perfLostFunc, record perfRecordFunc) error {
 	for i := 0; i < e.epoll.nFds; i++ {
 		fd := int(e.epoll.events[i].Fd)
 		if event, ok := e.fdToEvent[fd]; ok {
-			event.read(receive, lost)
+			event.read(receive, lost, record)
+		}
+		// Task events hang up when the task exits, stop polling them to
+		// not spin on the hang up.
+		if e.epoll.events[i].Events&unix.EPOLLHUP != 0 {
+			e.epoll.removeFd(fd)
 		}
 	}
 
 	return nil
 }
 
+// readAll reads the ring buffers of all the per-CPU events, whether they have
+// been reported ready by poll or not.
+func (e *perfSystemEvent) readAll(receive perfReceiveFunc, lost perfLostFunc, record perfRecordFunc) {
+	for _, event := range e.fdToEvent {
+		if event.data != nil {
+			event.read(receive, lost, record)
+		}
+	}
+}
+
+// count returns the sum of the per-CPU counters of a counting event.
+func (e *perfSystemEvent) count() (uint64, error) {
+	var total uint64
+
+	for _, event := range e.fdToEvent {
+		v, err := event.readCount()
+		if err != nil {
+			return 0, err
+		}
+		total += v
+	}
+
+	return total, nil
+}
+
 func (e *perfSystemEvent) stats() (uint64, uint64) {
 	var lost, unknown uint64
 
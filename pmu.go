@@ -0,0 +1,477 @@
+package obs
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	pmuDevices = "/sys/bus/event_source/devices"
+)
+
+// pmuFormat describes where the value of a term goes in the event config. It
+// is parsed from a file of a PMU format directory, eg. "config:0-7,21".
+type pmuFormat struct {
+	// config is the config word the value goes into: 0 for config, 1 for
+	// config1, 2 for config2 and 3 for config3.
+	config int
+	// mask is the bits of the config word the value is scattered into.
+	mask uint64
+}
+
+// PMU is a Performance Monitoring Unit, as exposed by the kernel in
+// /sys/bus/event_source/devices. The CPU PMU is usually named "cpu" but there
+// are other PMUs, eg. for uncore or software events.
+type PMU struct {
+	// Name is the PMU name.
+	Name string
+	// Type is the perf event type of the PMU.
+	Type uint32
+
+	formats map[string]pmuFormat
+	// invalidFormats are the format terms that couldn't be parsed, eg.
+	// written for config words newer than this package.
+	invalidFormats map[string]error
+	// events maps the event aliases to their terms, eg. "event=0x3c".
+	events map[string]string
+}
+
+// PMURegistry knows the PMUs of a machine and resolves event names to events
+// that can be counted.
+type PMURegistry struct {
+	pmus map[string]*PMU
+	// invalid are the PMUs that couldn't be read, with the reason.
+	invalid map[string]error
+}
+
+// NewPMURegistry reads the PMUs of the running kernel.
+func NewPMURegistry() (*PMURegistry, error) {
+	return ReadPMURegistry(pmuDevices)
+}
+
+// ReadPMURegistry reads the PMUs described in dir, a directory laid out as
+// /sys/bus/event_source/devices. PMUs that can't be read are left out of the
+// registry, the error is only returned when resolving their events.
+func ReadPMURegistry(dir string) (*PMURegistry, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &PMURegistry{
+		pmus:    make(map[string]*PMU),
+		invalid: make(map[string]error),
+	}
+	for _, entry := range entries {
+		pmu, err := readPMU(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			r.invalid[entry.Name()] = err
+			continue
+		}
+		r.pmus[pmu.Name] = pmu
+	}
+
+	return r, nil
+}
+
+func readPMU(dir string) (*PMU, error) {
+	pmu := &PMU{
+		Name:           filepath.Base(dir),
+		formats:        make(map[string]pmuFormat),
+		invalidFormats: make(map[string]error),
+		events:         make(map[string]string),
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "type"))
+	if err != nil {
+		return nil, err
+	}
+	t, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	pmu.Type = uint32(t)
+
+	err = readDirFiles(filepath.Join(dir, "format"), func(name, value string) error {
+		format, err := parsePMUFormat(value)
+		if err != nil {
+			// Only the events using that term can't be resolved.
+			pmu.invalidFormats[name] = err
+			return nil
+		}
+		pmu.formats[name] = format
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = readDirFiles(filepath.Join(dir, "events"), func(name, value string) error {
+		// Skip the .scale, .unit, .per-pkg, ... files.
+		if strings.ContainsRune(name, '.') {
+			return nil
+		}
+		pmu.events[name] = value
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return pmu, nil
+}
+
+// readDirFiles calls fn with the name and trimmed content of each file in dir.
+// A missing dir isn't an error: not all PMUs have formats or events.
+func readDirFiles(dir string, fn func(name, value string) error) error {
+	entries, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return err
+		}
+		if err := fn(entry.Name(), strings.TrimSpace(string(data))); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// parsePMUFormat parses a format description, eg. "config1:0-15" or
+// "config:8-15,24".
+func parsePMUFormat(s string) (pmuFormat, error) {
+	var f pmuFormat
+
+	colon := strings.IndexByte(s, ':')
+	if colon == -1 {
+		return f, fmt.Errorf("invalid format '%s'", s)
+	}
+	switch s[:colon] {
+	case "config":
+		f.config = 0
+	case "config1":
+		f.config = 1
+	case "config2":
+		f.config = 2
+	case "config3":
+		f.config = 3
+	default:
+		return f, fmt.Errorf("unknown config word '%s'", s[:colon])
+	}
+
+	// The bit list has the same syntax as CPU lists.
+	list, err := parseOnlineCPUs(s[colon+1:])
+	if err != nil {
+		return f, fmt.Errorf("invalid bits '%s'", s[colon+1:])
+	}
+	for _, bit := range list {
+		if bit < 0 || bit > 63 {
+			return f, fmt.Errorf("invalid bit %d", bit)
+		}
+		f.mask |= 1 << uint(bit)
+	}
+
+	return f, nil
+}
+
+// apply scatters v into the bits of f.
+func (f pmuFormat) apply(config *[4]uint64, v uint64) error {
+	if bits.Len64(v) > bits.OnesCount64(f.mask) {
+		return fmt.Errorf("value %#x doesn't fit in %d bits", v, bits.OnesCount64(f.mask))
+	}
+
+	word := config[f.config] &^ f.mask
+	for mask := f.mask; mask != 0; mask &= mask - 1 {
+		if v&1 != 0 {
+			word |= mask & -mask
+		}
+		v >>= 1
+	}
+	config[f.config] = word
+
+	return nil
+}
+
+// PMUs returns the PMUs of the registry, sorted by name.
+func (r *PMURegistry) PMUs() []*PMU {
+	pmus := make([]*PMU, 0, len(r.pmus))
+	for _, pmu := range r.pmus {
+		pmus = append(pmus, pmu)
+	}
+	sort.Slice(pmus, func(i, j int) bool {
+		return pmus[i].Name < pmus[j].Name
+	})
+	return pmus
+}
+
+// PMU returns the PMU called name or nil if there's no such PMU.
+func (r *PMURegistry) PMU(name string) *PMU {
+	return r.pmus[name]
+}
+
+// Events returns the names of the events PMU defines, sorted.
+func (pmu *PMU) Events() []string {
+	events := make([]string, 0, len(pmu.events))
+	for name := range pmu.events {
+		events = append(events, name)
+	}
+	sort.Strings(events)
+	return events
+}
+
+// genericEvents are the events perf knows by name, independently of the PMUs
+// of the machine. They are listed by Events and resolved by ParseEvent.
+var genericEvents = map[string]CounterEvent{}
+
+func init() {
+	for i := range hardwareEventNames {
+		genericEvents[hardwareEventNames[i]] = HardwareCounter(HardwareEventKind(i))
+	}
+	for i := range softwareEventNames {
+		genericEvents[softwareEventNames[i]] = SoftwareCounter(SoftwareEventKind(i))
+	}
+	for cache := range hwCacheNames {
+		for op := range hwCacheOpNames {
+			for _, result := range []HWCacheResult{CacheResultAccess, CacheResultMiss} {
+				e := HWCacheCounter(HWCache(cache), HWCacheOp(op), result)
+				genericEvents[e.name] = e
+			}
+		}
+	}
+
+	// perf's aliases.
+	aliases := map[string]string{
+		"cpu-cycles":           "cycles",
+		"branch-instructions":  "branches",
+		"idle-cycles-frontend": "stalled-cycles-frontend",
+		"idle-cycles-backend":  "stalled-cycles-backend",
+		"cs":                   "context-switches",
+		"migrations":           "cpu-migrations",
+		"faults":               "page-faults",
+	}
+	for alias, name := range aliases {
+		e := genericEvents[name]
+		e.name = alias
+		genericEvents[alias] = e
+	}
+}
+
+// Events returns the names of the events that can be given to ParseEvent: the
+// generic events, followed by the events defined by the PMUs, eg.
+// "cpu/mem-loads/".
+func (r *PMURegistry) Events() []string {
+	var generic, pmu []string
+
+	for name := range genericEvents {
+		generic = append(generic, name)
+	}
+	sort.Strings(generic)
+
+	for _, p := range r.PMUs() {
+		for _, name := range p.Events() {
+			pmu = append(pmu, p.Name+"/"+name+"/")
+		}
+	}
+
+	return append(generic, pmu...)
+}
+
+// ParseEvent resolves an event description, as given to perf stat -e, to an
+// event that can be counted. The supported syntaxes are:
+//
+//	cycles                     a generic event
+//	r1a8                       a raw event
+//	cpu/event=0x3c,umask=0x0/  a PMU event, described with its format terms
+//	cpu/mem-loads,ldlat=4/     a PMU event alias, with overridden terms
+//	mem-loads                  a PMU event alias, looked up in all PMUs
+//
+// Events can be followed by modifiers: ":u" counts user space events, ":k"
+// kernel events and ":h" hypervisor events, eg. "cycles:u".
+func (r *PMURegistry) ParseEvent(s string) (CounterEvent, error) {
+	var e CounterEvent
+	var err error
+
+	name, modifiers := s, ""
+	if end := strings.LastIndexByte(s, '/'); end != -1 {
+		if colon := strings.IndexByte(s[end:], ':'); colon != -1 {
+			name, modifiers = s[:end+colon], s[end+colon+1:]
+		}
+	} else if colon := strings.IndexByte(s, ':'); colon != -1 {
+		name, modifiers = s[:colon], s[colon+1:]
+	}
+
+	if slash := strings.IndexByte(name, '/'); slash != -1 {
+		e, err = r.parsePMUEvent(name[:slash], name[slash+1:])
+	} else {
+		e, err = r.parseNamedEvent(name)
+	}
+	if err != nil {
+		return e, fmt.Errorf("event '%s': %v", s, err)
+	}
+	e.name = s
+
+	if err := e.attr.applyModifiers(modifiers); err != nil {
+		return e, fmt.Errorf("event '%s': %v", s, err)
+	}
+
+	return e, nil
+}
+
+func (r *PMURegistry) parseNamedEvent(name string) (CounterEvent, error) {
+	if e, ok := genericEvents[name]; ok {
+		return e, nil
+	}
+
+	if len(name) > 1 && name[0] == 'r' {
+		if config, err := strconv.ParseUint(name[1:], 16, 64); err == nil {
+			return RawCounter(config), nil
+		}
+	}
+
+	for _, pmu := range r.PMUs() {
+		if _, ok := pmu.events[name]; ok {
+			return pmu.event(name)
+		}
+	}
+
+	return CounterEvent{}, errors.New("unknown event")
+}
+
+func (r *PMURegistry) parsePMUEvent(pmuName, rest string) (CounterEvent, error) {
+	pmu := r.pmus[pmuName]
+	if err, ok := r.invalid[pmuName]; ok {
+		return CounterEvent{}, fmt.Errorf("PMU '%s': %v", pmuName, err)
+	}
+	if pmu == nil {
+		return CounterEvent{}, fmt.Errorf("unknown PMU '%s'", pmuName)
+	}
+	if !strings.HasSuffix(rest, "/") {
+		return CounterEvent{}, fmt.Errorf("missing closing '/'")
+	}
+
+	return pmu.event(strings.TrimSuffix(rest, "/"))
+}
+
+// event builds the event described by terms, a comma separated list of terms.
+// A term is either a format term with its value, eg. "event=0x3c", a format
+// term alone, meaning its value is 1, or an event alias.
+func (pmu *PMU) event(terms string) (CounterEvent, error) {
+	var config [4]uint64
+	// unset are the terms of an alias with a "?" value, which need to be
+	// given by the user.
+	unset := make(map[string]bool)
+
+	var apply func(terms string, depth int) error
+	apply = func(terms string, depth int) error {
+		for _, term := range strings.Split(terms, ",") {
+			term = strings.TrimSpace(term)
+			if term == "" {
+				continue
+			}
+
+			name, value := term, "1"
+			if eq := strings.IndexByte(term, '='); eq != -1 {
+				name, value = term[:eq], term[eq+1:]
+			} else if alias, ok := pmu.events[term]; ok && depth == 0 {
+				if err := apply(alias, depth+1); err != nil {
+					return fmt.Errorf("%s: %v", term, err)
+				}
+				continue
+			}
+
+			if value == "?" {
+				unset[name] = true
+				continue
+			}
+			delete(unset, name)
+
+			v, err := strconv.ParseUint(value, 0, 64)
+			if err != nil {
+				return fmt.Errorf("invalid value for term '%s': %s", name, value)
+			}
+
+			switch name {
+			case "config":
+				config[0] = v
+			case "config1":
+				config[1] = v
+			case "config2":
+				config[2] = v
+			case "config3":
+				config[3] = v
+			default:
+				if err, ok := pmu.invalidFormats[name]; ok {
+					return fmt.Errorf("term '%s': %v", name, err)
+				}
+				format, ok := pmu.formats[name]
+				if !ok {
+					return fmt.Errorf("unknown term '%s'", name)
+				}
+				if err := format.apply(&config, v); err != nil {
+					return fmt.Errorf("term '%s': %v", name, err)
+				}
+			}
+		}
+		return nil
+	}
+
+	if err := apply(terms, 0); err != nil {
+		return CounterEvent{}, err
+	}
+	for name := range unset {
+		return CounterEvent{}, fmt.Errorf("term '%s' needs a value", name)
+	}
+
+	return CounterEvent{
+		name: pmu.Name + "/" + terms + "/",
+		attr: perfEventConfig{
+			eventType: perfType(pmu.Type),
+			config:    config[0],
+			config1:   config[1],
+			config2:   config[2],
+			config3:   config[3],
+		},
+	}, nil
+}
+
+// applyModifiers applies perf event modifiers, eg. "u" or "uk". Listing some
+// contexts excludes the others.
+func (c *perfEventConfig) applyModifiers(modifiers string) error {
+	if modifiers == "" {
+		return nil
+	}
+
+	c.excludeUser, c.excludeKernel, c.excludeHV = true, true, true
+	for _, m := range modifiers {
+		switch m {
+		case 'u':
+			c.excludeUser = false
+		case 'k':
+			c.excludeKernel = false
+		case 'h':
+			c.excludeHV = false
+		default:
+			return fmt.Errorf("unknown modifier '%c'", m)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,150 @@
+package obs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func readTestPMURegistry(t *testing.T) *PMURegistry {
+	r, err := ReadPMURegistry("testdata/pmu")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestParsePMUFormat(t *testing.T) {
+	tests := []struct {
+		format   string
+		valid    bool
+		expected pmuFormat
+	}{
+		{"config:0-7", valid, pmuFormat{config: 0, mask: 0xff}},
+		{"config1:0-15", valid, pmuFormat{config: 1, mask: 0xffff}},
+		{"config:8-15,24", valid, pmuFormat{config: 0, mask: 0x100ff00}},
+		{"config2:63", valid, pmuFormat{config: 2, mask: 1 << 63}},
+		{"config", invalid, pmuFormat{}},
+		{"config3:0-7", valid, pmuFormat{config: 3, mask: 0xff}},
+		{"config4:0-7", invalid, pmuFormat{}},
+		{"config:0-64", invalid, pmuFormat{}},
+		{"config:a-b", invalid, pmuFormat{}},
+	}
+
+	for _, test := range tests {
+		f, err := parsePMUFormat(test.format)
+		if !test.valid {
+			assert.NotNil(t, err, test.format)
+			continue
+		}
+		assert.Nil(t, err, test.format)
+		assert.Equal(t, test.expected, f, test.format)
+	}
+}
+
+func TestPMUFormatApply(t *testing.T) {
+	var config [4]uint64
+
+	f := pmuFormat{config: 0, mask: 0x100ff00}
+	assert.Nil(t, f.apply(&config, 0x1ab))
+	assert.Equal(t, uint64(0x100ab00), config[0])
+	assert.Nil(t, f.apply(&config, 0x1))
+	assert.Equal(t, uint64(0x100), config[0])
+	assert.NotNil(t, f.apply(&config, 0x200))
+}
+
+func TestReadPMURegistry(t *testing.T) {
+	r := readTestPMURegistry(t)
+
+	var names []string
+	for _, pmu := range r.PMUs() {
+		names = append(names, pmu.Name)
+	}
+	// The PMU without a type is left out, the PMU with an unknown format
+	// term is kept.
+	assert.Equal(t, []string{"arm_spe_0", "cpu", "msr", "software", "uncore_imc_0"}, names)
+
+	cpu := r.PMU("cpu")
+	assert.Equal(t, uint32(4), cpu.Type)
+	assert.Equal(t, []string{"cache-misses", "cpu-cycles", "cycles-t", "instructions", "mem-loads"}, cpu.Events())
+	assert.Nil(t, r.PMU("foo"))
+
+	events := r.Events()
+	assert.Contains(t, events, "cycles")
+	assert.Contains(t, events, "task-clock")
+	assert.Contains(t, events, "L1-dcache-load-misses")
+	assert.Contains(t, events, "cpu/mem-loads/")
+	assert.Contains(t, events, "uncore_imc_0/cas_count_read/")
+	assert.NotContains(t, events, "uncore_imc_0/cas_count_read.unit/")
+}
+
+func TestParseEvent(t *testing.T) {
+	r := readTestPMURegistry(t)
+
+	tests := []struct {
+		event    string
+		valid    bool
+		expected perfEventConfig
+	}{
+		{"cycles", valid, perfEventConfig{eventType: perfTypeHardware, config: 0}},
+		{"cpu-cycles", valid, perfEventConfig{eventType: perfTypeHardware, config: 0}},
+		{"cache-misses", valid, perfEventConfig{eventType: perfTypeHardware, config: 3}},
+		{"cs", valid, perfEventConfig{eventType: perfTypeSoftware, config: 3}},
+		{"task-clock", valid, perfEventConfig{eventType: perfTypeSoftware, config: 1}},
+		{"LLC-load-misses", valid, perfEventConfig{eventType: perfTypeHWCache, config: 0x10002}},
+		{"r1a8", valid, perfEventConfig{eventType: perfTypeRaw, config: 0x1a8}},
+		{"cpu/event=0x3c/", valid, perfEventConfig{eventType: 4, config: 0x3c}},
+		{"cpu/event=0x3c,umask=0x1,edge,cmask=2/", valid, perfEventConfig{eventType: 4, config: 0x204013c}},
+		{"cpu/config=0x1234,config1=5/", valid, perfEventConfig{eventType: 4, config: 0x1234, config1: 5}},
+		{"cpu/cache-misses/", valid, perfEventConfig{eventType: 4, config: 0x412e}},
+		{"cpu/mem-loads/", valid, perfEventConfig{eventType: 4, config: 0x1cd, config1: 3}},
+		{"cpu/mem-loads,ldlat=10/", valid, perfEventConfig{eventType: 4, config: 0x1cd, config1: 10}},
+		{"mem-loads", valid, perfEventConfig{eventType: 4, config: 0x1cd, config1: 3}},
+		{"cycles-t", valid, perfEventConfig{eventType: 4, config: 0x10000003c}},
+		{"tsc", valid, perfEventConfig{eventType: 10, config: 0}},
+		{"uncore_imc_0/cas_count_read/", valid, perfEventConfig{eventType: 14, config: 0x304}},
+		{"uncore_imc_0/clockticks_sel,umask=2/", valid, perfEventConfig{eventType: 14, config: 0x2ff}},
+		{"cycles:u", valid, perfEventConfig{eventType: perfTypeHardware, excludeKernel: true, excludeHV: true}},
+		{"cpu/event=0x3c/:uk", valid, perfEventConfig{eventType: 4, config: 0x3c, excludeHV: true}},
+		{"arm_spe_0/ts_enable,inv_event_filter=0x10/", valid, perfEventConfig{eventType: 12, config: 1, config3: 0x10}},
+		{"arm_spe_0/config3=5/", valid, perfEventConfig{eventType: 12, config3: 5}},
+		{"arm_spe_0/future=1/", invalid, perfEventConfig{}},
+		{"broken/event=1/", invalid, perfEventConfig{}},
+		{"uncore_imc_0/clockticks_sel/", invalid, perfEventConfig{}},
+		{"cpu/event=0x100/", invalid, perfEventConfig{}},
+		{"cpu/foo=1/", invalid, perfEventConfig{}},
+		{"cpu/event=0x3c", invalid, perfEventConfig{}},
+		{"cpu/event=zz/", invalid, perfEventConfig{}},
+		{"gpu/event=0x3c/", invalid, perfEventConfig{}},
+		{"not-an-event", invalid, perfEventConfig{}},
+		{"cycles:x", invalid, perfEventConfig{}},
+	}
+
+	for _, test := range tests {
+		e, err := r.ParseEvent(test.event)
+		if !test.valid {
+			assert.NotNil(t, err, test.event)
+			continue
+		}
+		assert.Nil(t, err, test.event)
+		assert.Equal(t, test.expected, e.attr, test.event)
+		assert.Equal(t, test.event, e.String())
+	}
+}
+
+func TestNewPMURegistry(t *testing.T) {
+	r, err := NewPMURegistry()
+	if err != nil {
+		t.Skipf("unable to read PMUs: %v", err)
+	}
+
+	software := r.PMU("software")
+	if software == nil {
+		t.Skip("no software PMU")
+	}
+	assert.Equal(t, uint32(perfTypeSoftware), software.Type)
+
+	e, err := r.ParseEvent("software/config=1/")
+	assert.Nil(t, err)
+	assert.Equal(t, SoftwareCounter(TaskClock).attr, e.attr)
+}
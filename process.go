@@ -1,10 +1,14 @@
 package obs
 
 import (
+	"bytes"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/pkg/errors"
 )
@@ -27,9 +31,119 @@ const (
 	UserNS
 	// CgroupNS is the cgroup namespace.
 	CgroupNS
+	// TimeNS is the time namespace.
+	TimeNS
 )
 
-var nsProcFiles = []string{"net", "ipc", "uts", "mnt", "pid", "user", "cgroup"}
+var nsProcFiles = []string{"net", "ipc", "uts", "mnt", "pid", "user", "cgroup", "time"}
+
+func (kind NamespaceKind) valid() bool {
+	return kind >= 0 && int(kind) < len(nsProcFiles)
+}
+
+// String returns the name of the namespace kind, as used by the files of
+// /proc/pid/ns.
+func (kind NamespaceKind) String() string {
+	if !kind.valid() {
+		return fmt.Sprintf("NamespaceKind(%d)", int(kind))
+	}
+	return nsProcFiles[kind]
+}
+
+// ProcessGoneError is returned when the process doesn't exist, or doesn't
+// exist anymore.
+type ProcessGoneError struct {
+	PID int
+}
+
+func (e *ProcessGoneError) Error() string {
+	return fmt.Sprintf("process %d is gone", e.PID)
+}
+
+// PermissionError is returned when the caller isn't allowed to retrieve the
+// information about the process.
+type PermissionError struct {
+	PID int
+	Err error
+}
+
+func (e *PermissionError) Error() string {
+	return fmt.Sprintf("process %d: %v", e.PID, e.Err)
+}
+
+// IsProcessGone returns true if err, or the cause of err, is a
+// ProcessGoneError.
+func IsProcessGone(err error) bool {
+	_, ok := errors.Cause(err).(*ProcessGoneError)
+	return ok
+}
+
+// IsPermission returns true if err, or the cause of err, is a
+// PermissionError.
+func IsPermission(err error) bool {
+	_, ok := errors.Cause(err).(*PermissionError)
+	return ok
+}
+
+// ProcessState is the state of a process, as shown in /proc/pid/stat.
+type ProcessState byte
+
+// Process states, see proc(5).
+const (
+	Running     ProcessState = 'R'
+	Sleeping    ProcessState = 'S'
+	DiskSleep   ProcessState = 'D'
+	Zombie      ProcessState = 'Z'
+	Stopped     ProcessState = 'T'
+	TracingStop ProcessState = 't'
+	Dead        ProcessState = 'X'
+	Idle        ProcessState = 'I'
+)
+
+var processStateNames = map[ProcessState]string{
+	Running:     "running",
+	Sleeping:    "sleeping",
+	DiskSleep:   "disk sleep",
+	Zombie:      "zombie",
+	Stopped:     "stopped",
+	TracingStop: "tracing stop",
+	Dead:        "dead",
+	Idle:        "idle",
+}
+
+func (s ProcessState) String() string {
+	if name, ok := processStateNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown (%c)", s)
+}
+
+// IDs are the user or group IDs of a process.
+type IDs struct {
+	Real, Effective, Saved, FS int
+}
+
+// Capabilities are the capability sets of a process, as bit masks of
+// capability numbers, see capabilities(7).
+type Capabilities struct {
+	Inheritable, Permitted, Effective, Bounding, Ambient uint64
+}
+
+// CPUUsage is the CPU time consumed by a process.
+type CPUUsage struct {
+	// User is the time spent in user mode.
+	User time.Duration
+	// System is the time spent in kernel mode.
+	System time.Duration
+}
+
+// MemoryUsage is the memory used by a process, in bytes.
+type MemoryUsage struct {
+	// Virtual is the size of the virtual address space.
+	Virtual uint64
+	// Resident is the size of the memory resident in RAM.
+	Resident uint64
+}
 
 // Process provides mechanisms to retrieve information about a process.
 type Process struct {
@@ -63,10 +177,13 @@ func parseNS(s string) (uint64, error) {
 
 // Namespace returns the namespace inode for the specified ns.
 func (p *Process) Namespace(kind NamespaceKind) (uint64, error) {
+	if !kind.valid() {
+		return 0, errors.Errorf("namespace: invalid kind %d", int(kind))
+	}
 	f := "/proc/" + strconv.Itoa(p.pid) + "/ns/" + nsProcFiles[kind]
 	link, err := os.Readlink(f)
 	if err != nil {
-		return 0, errors.Wrap(err, "namespace")
+		return 0, errors.Wrap(p.error(err), "namespace")
 	}
 	ns, err := parseNS(link)
 	if err != nil {
@@ -74,3 +191,215 @@ func (p *Process) Namespace(kind NamespaceKind) (uint64, error) {
 	}
 	return ns, nil
 }
+
+// Namespaces returns the inodes of all the namespaces of the process.
+// Namespaces not supported by the kernel, eg. the time namespace before Linux
+// 5.6, are omitted.
+func (p *Process) Namespaces() (map[NamespaceKind]uint64, error) {
+	namespaces := make(map[NamespaceKind]uint64, len(nsProcFiles))
+	for i := range nsProcFiles {
+		kind := NamespaceKind(i)
+		ns, err := p.Namespace(kind)
+		if err == nil {
+			namespaces[kind] = ns
+			continue
+		}
+		if IsProcessGone(err) || IsPermission(err) {
+			return nil, errors.Wrap(errors.Cause(err), "namespaces")
+		}
+	}
+	return namespaces, nil
+}
+
+// error converts errors accessing the /proc files of the process to
+// ProcessGoneError and PermissionError when possible.
+func (p *Process) error(err error) error {
+	if os.IsPermission(err) {
+		return &PermissionError{PID: p.pid, Err: err}
+	}
+	if pathErr, ok := err.(*os.PathError); ok && pathErr.Err == syscall.ESRCH {
+		// The process has exited after its file was opened.
+		return &ProcessGoneError{PID: p.pid}
+	}
+	if os.IsNotExist(err) {
+		// Some files don't exist for all processes, eg. the exe link of
+		// kernel threads.
+		if _, statErr := os.Stat(p.procPath("")); os.IsNotExist(statErr) {
+			return &ProcessGoneError{PID: p.pid}
+		}
+	}
+	return err
+}
+
+func (p *Process) readFile(name string) ([]byte, error) {
+	data, err := ioutil.ReadFile(p.procPath(name))
+	if err != nil {
+		return nil, errors.Wrap(p.error(err), name)
+	}
+	return data, nil
+}
+
+func (p *Process) readLink(name string) (string, error) {
+	link, err := os.Readlink(p.procPath(name))
+	if err != nil {
+		return "", errors.Wrap(p.error(err), name)
+	}
+	return link, nil
+}
+
+func (p *Process) stat() (procStat, error) {
+	s, err := p.readStat()
+	if err != nil {
+		return s, errors.Wrap(p.error(err), "stat")
+	}
+	return s, nil
+}
+
+func (p *Process) status() (map[string]string, error) {
+	data, err := p.readFile("status")
+	if err != nil {
+		return nil, err
+	}
+	status, err := parseStatus(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.Wrap(err, "status")
+	}
+	return status, nil
+}
+
+// Cmdline returns the command line arguments of the process, the first one
+// being the program name. Kernel threads and zombies have an empty command
+// line.
+func (p *Process) Cmdline() ([]string, error) {
+	data, err := p.readFile("cmdline")
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, nil
+	}
+	return strings.Split(string(bytes.TrimSuffix(data, []byte{0})), "\x00"), nil
+}
+
+// Exe returns the path of the executable of the process.
+func (p *Process) Exe() (string, error) {
+	return p.readLink("exe")
+}
+
+// Cwd returns the current working directory of the process.
+func (p *Process) Cwd() (string, error) {
+	return p.readLink("cwd")
+}
+
+// Comm returns the name of the process, truncated to 15 characters by the
+// kernel.
+func (p *Process) Comm() (string, error) {
+	data, err := p.readFile("comm")
+	if err != nil {
+		return "", err
+	}
+	return string(bytes.TrimSuffix(data, []byte{'\n'})), nil
+}
+
+// PPID returns the PID of the parent of the process.
+func (p *Process) PPID() (int, error) {
+	s, err := p.stat()
+	return s.ppid, err
+}
+
+// State returns the state of the process.
+func (p *Process) State() (ProcessState, error) {
+	s, err := p.stat()
+	return ProcessState(s.state), err
+}
+
+// NumThreads returns the number of threads of the process.
+func (p *Process) NumThreads() (int, error) {
+	s, err := p.stat()
+	return s.numThreads, err
+}
+
+// StartTime returns the time the process started at.
+func (p *Process) StartTime() (time.Time, error) {
+	s, err := p.stat()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return ticksToTime(s.startTime)
+}
+
+// CPUUsage returns the CPU time consumed by the process since it started.
+func (p *Process) CPUUsage() (CPUUsage, error) {
+	s, err := p.stat()
+	if err != nil {
+		return CPUUsage{}, err
+	}
+	return CPUUsage{
+		User:   time.Duration(s.utime) * time.Second / userHZ,
+		System: time.Duration(s.stime) * time.Second / userHZ,
+	}, nil
+}
+
+// MemoryUsage returns the memory used by the process.
+func (p *Process) MemoryUsage() (MemoryUsage, error) {
+	s, err := p.stat()
+	if err != nil {
+		return MemoryUsage{}, err
+	}
+	return MemoryUsage{
+		Virtual:  s.vsize,
+		Resident: s.rss * uint64(os.Getpagesize()),
+	}, nil
+}
+
+func (p *Process) ids(name string) (IDs, error) {
+	status, err := p.status()
+	if err != nil {
+		return IDs{}, err
+	}
+	ids, err := parseIDs(status[name])
+	if err != nil {
+		return IDs{}, errors.Wrap(err, "status")
+	}
+	return ids, nil
+}
+
+// UIDs returns the user IDs of the process.
+func (p *Process) UIDs() (IDs, error) {
+	return p.ids("Uid")
+}
+
+// GIDs returns the group IDs of the process.
+func (p *Process) GIDs() (IDs, error) {
+	return p.ids("Gid")
+}
+
+// Groups returns the supplementary group IDs of the process.
+func (p *Process) Groups() ([]int, error) {
+	status, err := p.status()
+	if err != nil {
+		return nil, err
+	}
+	var groups []int
+	for _, field := range strings.Fields(status["Groups"]) {
+		gid, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, errors.Errorf("status: invalid group '%s'", field)
+		}
+		groups = append(groups, gid)
+	}
+	return groups, nil
+}
+
+// Capabilities returns the capability sets of the process.
+func (p *Process) Capabilities() (Capabilities, error) {
+	status, err := p.status()
+	if err != nil {
+		return Capabilities{}, err
+	}
+	caps, err := parseCapabilities(status)
+	if err != nil {
+		return Capabilities{}, errors.Wrap(err, "status")
+	}
+	return caps, nil
+}
@@ -0,0 +1,142 @@
+package obs
+
+import (
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// readPIDs returns the PIDs found in dir, a /proc or /proc/pid/task directory,
+// sorted in increasing order.
+func readPIDs(dir string) ([]int, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	names, err := f.Readdirnames(-1)
+	if err != nil {
+		return nil, err
+	}
+
+	pids := make([]int, 0, len(names))
+	for _, name := range names {
+		pid, err := strconv.Atoi(name)
+		if err != nil {
+			continue
+		}
+		pids = append(pids, pid)
+	}
+	sort.Ints(pids)
+	return pids, nil
+}
+
+// ListProcesses returns the processes running on the system, sorted by PID.
+func ListProcesses() ([]*Process, error) {
+	pids, err := readPIDs("/proc")
+	if err != nil {
+		return nil, errors.Wrap(err, "list processes")
+	}
+	processes := make([]*Process, len(pids))
+	for i, pid := range pids {
+		processes[i] = NewProcess(pid)
+	}
+	return processes, nil
+}
+
+// ListProcessesInNamespace returns the processes in the namespace ns of type
+// kind, sorted by PID. Processes we can't inspect are skipped.
+func ListProcessesInNamespace(kind NamespaceKind, ns uint64) ([]*Process, error) {
+	processes, err := ListProcesses()
+	if err != nil {
+		return nil, err
+	}
+	return FilterByNamespace(processes, kind, ns), nil
+}
+
+// FilterByNamespace returns the processes in the namespace ns of type kind.
+func FilterByNamespace(processes []*Process, kind NamespaceKind, ns uint64) []*Process {
+	var filtered []*Process
+	for _, p := range processes {
+		if pns, err := p.Namespace(kind); err == nil && pns == ns {
+			filtered = append(filtered, p)
+		}
+	}
+	return filtered
+}
+
+// Parent returns the parent of the process, nil for the processes without a
+// parent: init and kthreadd.
+func (p *Process) Parent() (*Process, error) {
+	ppid, err := p.PPID()
+	if err != nil {
+		return nil, errors.Wrap(err, "parent")
+	}
+	if ppid == 0 {
+		return nil, nil
+	}
+	return NewProcess(ppid), nil
+}
+
+// Ancestors returns the ancestors of the process, starting with its parent
+// and ending with the process without a parent, usually init.
+func (p *Process) Ancestors() ([]*Process, error) {
+	var ancestors []*Process
+	seen := map[int]bool{p.pid: true}
+
+	for current := p; ; {
+		parent, err := current.Parent()
+		if err != nil {
+			return nil, errors.Wrap(err, "ancestors")
+		}
+		if parent == nil {
+			return ancestors, nil
+		}
+		if seen[parent.pid] {
+			return nil, errors.Errorf("ancestors: loop at PID %d", parent.pid)
+		}
+		seen[parent.pid] = true
+		ancestors = append(ancestors, parent)
+		current = parent
+	}
+}
+
+// Children returns the children of the process, sorted by PID. Children are
+// found by scanning /proc.
+func (p *Process) Children() ([]*Process, error) {
+	processes, err := ListProcesses()
+	if err != nil {
+		return nil, errors.Wrap(err, "children")
+	}
+
+	var children []*Process
+	for _, child := range processes {
+		ppid, err := child.PPID()
+		if err != nil {
+			// The process has exited since we've listed /proc.
+			continue
+		}
+		if ppid == p.pid {
+			children = append(children, child)
+		}
+	}
+	if len(children) == 0 {
+		// Make sure the process still exists.
+		if _, err := p.stat(); err != nil {
+			return nil, errors.Wrap(err, "children")
+		}
+	}
+	return children, nil
+}
+
+// Threads returns the IDs of the threads of the process, sorted in increasing
+// order. The ID of the main thread is the PID of the process.
+func (p *Process) Threads() ([]int, error) {
+	tids, err := readPIDs(p.procPath("task"))
+	if err != nil {
+		return nil, errors.Wrap(p.error(err), "threads")
+	}
+	return tids, nil
+}
@@ -0,0 +1,99 @@
+package obs
+
+import (
+	"os"
+	"os/exec"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+)
+
+func TestListProcesses(t *testing.T) {
+	processes, err := ListProcesses()
+	assert.NoError(t, err)
+
+	found := false
+	for i, p := range processes {
+		if i > 0 {
+			assert.True(t, processes[i-1].PID() < p.PID())
+		}
+		if p.PID() == os.Getpid() {
+			found = true
+		}
+	}
+	assert.True(t, found)
+}
+
+func TestListProcessesInNamespace(t *testing.T) {
+	self := NewProcess(os.Getpid())
+	ns, err := self.Namespace(PIDNS)
+	assert.NoError(t, err)
+
+	processes, err := ListProcessesInNamespace(PIDNS, ns)
+	assert.NoError(t, err)
+	found := false
+	for _, p := range processes {
+		pns, err := p.Namespace(PIDNS)
+		if err != nil {
+			continue
+		}
+		assert.Equal(t, ns, pns)
+		if p.PID() == os.Getpid() {
+			found = true
+		}
+	}
+	assert.True(t, found)
+
+	assert.Len(t, FilterByNamespace([]*Process{self}, PIDNS, 0), 0)
+}
+
+func TestProcessAncestry(t *testing.T) {
+	self := NewProcess(os.Getpid())
+
+	parent, err := self.Parent()
+	assert.NoError(t, err)
+	assert.Equal(t, os.Getppid(), parent.PID())
+
+	ancestors, err := self.Ancestors()
+	assert.NoError(t, err)
+	assert.True(t, len(ancestors) > 0)
+	assert.Equal(t, os.Getppid(), ancestors[0].PID())
+	root, err := ancestors[len(ancestors)-1].Parent()
+	assert.NoError(t, err)
+	assert.Nil(t, root)
+
+	cmd := exec.Command("sleep", "10")
+	assert.NoError(t, cmd.Start())
+	defer cmd.Wait()
+	defer cmd.Process.Kill()
+
+	children, err := self.Children()
+	assert.NoError(t, err)
+	found := false
+	for _, child := range children {
+		if child.PID() == cmd.Process.Pid {
+			found = true
+		}
+	}
+	assert.True(t, found)
+
+	_, err = NewProcess(5000000).Children()
+	assert.True(t, IsProcessGone(err))
+	_, err = NewProcess(5000000).Ancestors()
+	assert.True(t, IsProcessGone(err))
+}
+
+func TestProcessThreads(t *testing.T) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	threads, err := NewProcess(os.Getpid()).Threads()
+	assert.NoError(t, err)
+	assert.Contains(t, threads, os.Getpid())
+	assert.Contains(t, threads, unix.Gettid())
+
+	_, err = NewProcess(5000000).Threads()
+	assert.True(t, IsProcessGone(err))
+}
@@ -0,0 +1,133 @@
+package obs
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Mapping is a memory mapping of a process, as listed in /proc/pid/maps.
+type Mapping struct {
+	// Start and End are the addresses of the mapping, End being excluded.
+	Start, End uint64
+	// Perms are the access permissions of the mapping, eg. "r-xp".
+	Perms string
+	// Offset is the offset of the mapping in the mapped file.
+	Offset uint64
+	// Major and Minor are the device numbers of the mapped file.
+	Major, Minor uint32
+	// Inode is the inode number of the mapped file, 0 for anonymous
+	// mappings.
+	Inode uint64
+	// Path is the path of the mapped file, as seen by the process, or a
+	// pseudo-path such as "[stack]" or "[vdso]". Path is "" for anonymous
+	// mappings.
+	Path string
+	// Deleted is true if the mapped file has been deleted.
+	Deleted bool
+}
+
+// Executable returns true if the mapping can be executed.
+func (m *Mapping) Executable() bool {
+	return len(m.Perms) > 2 && m.Perms[2] == 'x'
+}
+
+// IsFile returns true if the mapping is backed by a file.
+func (m *Mapping) IsFile() bool {
+	return m.Inode != 0 && strings.HasPrefix(m.Path, "/")
+}
+
+// procPath returns the path of the file name in the /proc directory of the
+// process.
+func (p *Process) procPath(name string) string {
+	return "/proc/" + strconv.Itoa(p.pid) + "/" + name
+}
+
+// Mappings returns the memory mappings of the process, sorted by address.
+func (p *Process) Mappings() ([]Mapping, error) {
+	f, err := os.Open(p.procPath("maps"))
+	if err != nil {
+		return nil, errors.Wrap(err, "mappings")
+	}
+	defer f.Close()
+
+	mappings, err := parseMaps(f)
+	if err != nil {
+		return nil, errors.Wrap(err, "mappings: parse")
+	}
+	return mappings, nil
+}
+
+const deletedSuffix = " (deleted)"
+
+// parseMaps parses the /proc/pid/maps format:
+//
+//	address           perms offset  dev   inode       pathname
+//	00400000-00452000 r-xp 00000000 08:02 173521      /usr/bin/dbus-daemon
+func parseMaps(r io.Reader) ([]Mapping, error) {
+	var mappings []Mapping
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+
+		// The path may contain spaces, split the 5 first fields only.
+		fields := strings.SplitN(line, " ", 6)
+		if len(fields) < 5 {
+			return nil, fmt.Errorf("invalid mapping '%s'", line)
+		}
+
+		var m Mapping
+		var err error
+
+		addrs := strings.SplitN(fields[0], "-", 2)
+		if len(addrs) != 2 {
+			return nil, fmt.Errorf("invalid address range '%s'", fields[0])
+		}
+		if m.Start, err = strconv.ParseUint(addrs[0], 16, 64); err != nil {
+			return nil, fmt.Errorf("invalid address '%s'", addrs[0])
+		}
+		if m.End, err = strconv.ParseUint(addrs[1], 16, 64); err != nil {
+			return nil, fmt.Errorf("invalid address '%s'", addrs[1])
+		}
+		m.Perms = fields[1]
+		if m.Offset, err = strconv.ParseUint(fields[2], 16, 64); err != nil {
+			return nil, fmt.Errorf("invalid offset '%s'", fields[2])
+		}
+		dev := strings.SplitN(fields[3], ":", 2)
+		if len(dev) != 2 {
+			return nil, fmt.Errorf("invalid device '%s'", fields[3])
+		}
+		major, err := strconv.ParseUint(dev[0], 16, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid device '%s'", fields[3])
+		}
+		minor, err := strconv.ParseUint(dev[1], 16, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid device '%s'", fields[3])
+		}
+		m.Major, m.Minor = uint32(major), uint32(minor)
+		if m.Inode, err = strconv.ParseUint(fields[4], 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid inode '%s'", fields[4])
+		}
+		if len(fields) == 6 {
+			m.Path = strings.TrimLeft(fields[5], " ")
+			if strings.HasSuffix(m.Path, deletedSuffix) {
+				m.Path = strings.TrimSuffix(m.Path, deletedSuffix)
+				m.Deleted = true
+			}
+		}
+
+		mappings = append(mappings, m)
+	}
+
+	return mappings, scanner.Err()
+}
@@ -0,0 +1,74 @@
+package obs
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMaps(t *testing.T) {
+	f, err := os.Open("testdata/proc/maps")
+	assert.Nil(t, err)
+	defer f.Close()
+
+	mappings, err := parseMaps(f)
+	assert.Nil(t, err)
+	assert.Len(t, mappings, 9)
+
+	assert.Equal(t, Mapping{
+		Start:  0x55d4a4a28000,
+		End:    0x55d4a4ae9000,
+		Perms:  "r-xp",
+		Offset: 0x28000,
+		Major:  0xfd,
+		Minor:  1,
+		Inode:  1835037,
+		Path:   "/usr/bin/bash",
+	}, mappings[1])
+	assert.True(t, mappings[1].Executable())
+	assert.True(t, mappings[1].IsFile())
+
+	assert.Equal(t, "[heap]", mappings[2].Path)
+	assert.False(t, mappings[2].IsFile())
+
+	assert.Equal(t, "/memfd:shared buffer", mappings[5].Path)
+	assert.True(t, mappings[5].Deleted)
+
+	assert.Equal(t, "", mappings[6].Path)
+	assert.Equal(t, uint64(0), mappings[6].Inode)
+	assert.False(t, mappings[6].Executable())
+}
+
+func TestParseMapsInvalid(t *testing.T) {
+	tests := []string{
+		"55d4a4a00000 r--p 00000000 fd:01 1835037 /usr/bin/bash\n",
+		"55d4a4a00000-zz r--p 00000000 fd:01 1835037 /usr/bin/bash\n",
+		"55d4a4a00000-55d4a4a28000 r--p zz fd:01 1835037 /usr/bin/bash\n",
+		"55d4a4a00000-55d4a4a28000 r--p 00000000 fd01 1835037 /usr/bin/bash\n",
+		"55d4a4a00000-55d4a4a28000 r--p 00000000 fd:01 abc /usr/bin/bash\n",
+		"55d4a4a00000-55d4a4a28000 r--p\n",
+	}
+
+	for _, test := range tests {
+		_, err := parseMaps(strings.NewReader(test))
+		assert.NotNil(t, err, test)
+	}
+}
+
+func TestProcessMappings(t *testing.T) {
+	mappings, err := NewProcess(os.Getpid()).Mappings()
+	assert.Nil(t, err)
+
+	exe, err := os.Executable()
+	assert.Nil(t, err)
+
+	found := false
+	for _, m := range mappings {
+		if m.Path == exe && m.Executable() {
+			found = true
+		}
+	}
+	assert.True(t, found)
+}
@@ -0,0 +1,140 @@
+package obs
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// NSPids returns the PIDs of the process in the nested PID namespaces it
+// belongs to, from the PID namespace of the /proc mount to the PID namespace
+// of the process. The last PID is the PID seen inside a container.
+func (p *Process) NSPids() ([]int, error) {
+	status, err := p.status()
+	if err != nil {
+		return nil, errors.Wrap(err, "nspids")
+	}
+	s, ok := status["NSpid"]
+	if !ok {
+		// Kernels older than 4.1 don't have the NSpid line.
+		return nil, errors.New("nspids: not supported by the kernel")
+	}
+	pids, err := parseNSPids(s)
+	if err != nil {
+		return nil, errors.Wrap(err, "nspids")
+	}
+	return pids, nil
+}
+
+func parseNSPids(s string) ([]int, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("empty NSpid")
+	}
+	pids := make([]int, len(fields))
+	for i, field := range fields {
+		pid, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid NSpid '%s'", s)
+		}
+		pids[i] = pid
+	}
+	return pids, nil
+}
+
+// pidNamespaces returns the inodes of the PID namespaces of the process, from
+// the PID namespace of the caller to the PID namespace of the process.
+// NS_GET_PARENT fails with EPERM when reaching the PID namespace of the
+// caller.
+func (p *Process) pidNamespaces() ([]uint64, error) {
+	f, err := os.Open(p.procPath("ns/pid"))
+	if err != nil {
+		return nil, errors.Wrap(p.error(err), "pid namespaces")
+	}
+
+	var namespaces []uint64
+	for {
+		ns, err := nsInode(f)
+		if err != nil {
+			f.Close()
+			return nil, errors.Wrap(err, "pid namespaces")
+		}
+		namespaces = append([]uint64{ns}, namespaces...)
+
+		parent, err := nsIoctl(f, nsGetParent)
+		f.Close()
+		if err != nil {
+			if err.(*os.SyscallError).Err == unix.EPERM {
+				return namespaces, nil
+			}
+			return nil, errors.Wrap(err, "pid namespaces")
+		}
+		f = parent
+	}
+}
+
+// translatePID translates pid, a PID in the namespace fromNS, to a PID in the
+// namespace toNS. pids and namespaces are the NSpid list and the PID
+// namespaces of a process, ordered from the outermost namespace to the
+// innermost one. The two lists may start at different levels, they're aligned
+// on the innermost namespace. translatePID returns false if the process isn't
+// pid in fromNS or isn't visible in toNS.
+func translatePID(pids []int, namespaces []uint64, pid int, fromNS, toNS uint64) (int, bool) {
+	offset := len(pids) - len(namespaces)
+
+	pidIn := func(ns uint64) (int, bool) {
+		for i := range namespaces {
+			if namespaces[i] == ns && i+offset >= 0 {
+				return pids[i+offset], true
+			}
+		}
+		return 0, false
+	}
+
+	if p, ok := pidIn(fromNS); !ok || p != pid {
+		return 0, false
+	}
+	return pidIn(toNS)
+}
+
+// TranslatePID translates pid, a PID in the PID namespace fromNS, to the PID
+// of the same process in the PID namespace toNS. Namespaces are identified by
+// their inode, see Process.Namespace.
+//
+// The process is searched in /proc, the PID namespace of the caller must be
+// an ancestor of fromNS and toNS.
+func TranslatePID(pid int, fromNS, toNS uint64) (int, error) {
+	processes, err := ListProcesses()
+	if err != nil {
+		return 0, errors.Wrap(err, "translate pid")
+	}
+
+	for _, p := range processes {
+		pids, err := p.NSPids()
+		if err != nil || !containsInt(pids, pid) {
+			continue
+		}
+		namespaces, err := p.pidNamespaces()
+		if err != nil {
+			continue
+		}
+		if translated, ok := translatePID(pids, namespaces, pid, fromNS, toNS); ok {
+			return translated, nil
+		}
+	}
+
+	return 0, errors.Errorf("translate pid: no process %d in PID namespace %d visible in %d", pid, fromNS, toNS)
+}
+
+func containsInt(s []int, v int) bool {
+	for _, e := range s {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,117 @@
+package obs
+
+import (
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseNSPids(t *testing.T) {
+	pids, err := parseNSPids("1234\t56\t1")
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1234, 56, 1}, pids)
+
+	_, err = parseNSPids("")
+	assert.Error(t, err)
+	_, err = parseNSPids("1234 foo")
+	assert.Error(t, err)
+}
+
+func TestTranslatePIDCore(t *testing.T) {
+	const host, container, nested = 100, 200, 300
+
+	tests := []struct {
+		pids          []int
+		namespaces    []uint64
+		pid           int
+		fromNS, toNS  uint64
+		expected      int
+		expectedFound bool
+	}{
+		// Host to container and back.
+		{[]int{1234, 1}, []uint64{host, container}, 1234, host, container, 1, true},
+		{[]int{1234, 1}, []uint64{host, container}, 1, container, host, 1234, true},
+		{[]int{1234, 1}, []uint64{host, container}, 1234, host, host, 1234, true},
+		// Not that process.
+		{[]int{1234, 1}, []uint64{host, container}, 1235, host, container, 0, false},
+		// Not visible in toNS.
+		{[]int{1234}, []uint64{host}, 1234, host, container, 0, false},
+		// The caller lives in the container, /proc is the one of the
+		// host: the lists are aligned on the innermost namespace.
+		{[]int{1234, 56, 1}, []uint64{container, nested}, 56, container, nested, 1, true},
+		{[]int{1234, 56, 1}, []uint64{container, nested}, 1234, host, nested, 0, false},
+	}
+
+	for _, test := range tests {
+		pid, found := translatePID(test.pids, test.namespaces, test.pid, test.fromNS, test.toNS)
+		assert.Equal(t, test.expectedFound, found, "%+v", test)
+		assert.Equal(t, test.expected, pid, "%+v", test)
+	}
+}
+
+func TestNSPids(t *testing.T) {
+	self := NewProcess(os.Getpid())
+	pids, err := self.NSPids()
+	if err != nil {
+		t.Skipf("NSpid not supported: %v", err)
+	}
+	assert.Equal(t, os.Getpid(), pids[len(pids)-1])
+
+	ns, err := self.Namespace(PIDNS)
+	assert.NoError(t, err)
+	namespaces, err := self.pidNamespaces()
+	assert.NoError(t, err)
+	assert.Equal(t, []uint64{ns}, namespaces)
+
+	pid, err := TranslatePID(os.Getpid(), ns, ns)
+	assert.NoError(t, err)
+	assert.Equal(t, os.Getpid(), pid)
+
+	_, err = NewProcess(5000000).NSPids()
+	assert.True(t, IsProcessGone(err))
+}
+
+func TestTranslatePIDNested(t *testing.T) {
+	cmd := exec.Command("unshare", "--pid", "--fork", "sleep", "10")
+	if err := cmd.Start(); err != nil {
+		t.Skipf("unable to run unshare: %v", err)
+	}
+	defer cmd.Wait()
+	defer cmd.Process.Kill()
+
+	// Wait for the sleep process, child of unshare, in its own PID
+	// namespace.
+	var sleep *Process
+	deadline := time.Now().Add(5 * time.Second)
+	for sleep == nil {
+		children, _ := NewProcess(cmd.Process.Pid).Children()
+		for _, child := range children {
+			if comm, _ := child.Comm(); comm == "sleep" {
+				sleep = child
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Skip("unable to create a PID namespace")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	pids, err := sleep.NSPids()
+	assert.NoError(t, err)
+	assert.Equal(t, []int{sleep.PID(), 1}, pids)
+
+	hostNS, _ := NewProcess(os.Getpid()).Namespace(PIDNS)
+	containerNS, err := sleep.Namespace(PIDNS)
+	assert.NoError(t, err)
+	assert.NotEqual(t, hostNS, containerNS)
+
+	pid, err := TranslatePID(1, containerNS, hostNS)
+	assert.NoError(t, err)
+	assert.Equal(t, sleep.PID(), pid)
+	pid, err = TranslatePID(sleep.PID(), hostNS, containerNS)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, pid)
+}
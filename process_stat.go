@@ -0,0 +1,205 @@
+package obs
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// userHZ is the frequency of the clock ticks the /proc files are expressed
+// in, USER_HZ. It's 100 on all architectures.
+const userHZ = 100
+
+// procStat is the content of /proc/pid/stat we're interested in.
+type procStat struct {
+	comm       string
+	state      byte
+	ppid       int
+	utime      uint64
+	stime      uint64
+	numThreads int
+	// startTime is the time the process started, in clock ticks after
+	// boot.
+	startTime uint64
+	vsize     uint64
+	// rss is in pages.
+	rss uint64
+}
+
+// parseStat parses the content of /proc/pid/stat, see proc(5).
+func parseStat(data []byte) (procStat, error) {
+	var s procStat
+
+	// The comm field is between parenthesis and can contain spaces and
+	// parenthesis itself.
+	start := bytes.IndexByte(data, '(')
+	end := bytes.LastIndexByte(data, ')')
+	if start == -1 || end < start {
+		return s, fmt.Errorf("stat: invalid comm")
+	}
+	s.comm = string(data[start+1 : end])
+
+	// Fields are numbered from 1 in proc(5), state being the third one.
+	fields := strings.Fields(string(data[end+1:]))
+	const offset = 3
+	if len(fields) < 24-offset+1 {
+		return s, fmt.Errorf("stat: too few fields")
+	}
+	if len(fields[0]) != 1 {
+		return s, fmt.Errorf("stat: invalid state '%s'", fields[0])
+	}
+	s.state = fields[0][0]
+
+	var err error
+	parse := func(i int) uint64 {
+		if err != nil {
+			return 0
+		}
+		var v uint64
+		v, err = strconv.ParseUint(fields[i-offset], 10, 64)
+		return v
+	}
+	s.ppid = int(parse(4))
+	s.utime = parse(14)
+	s.stime = parse(15)
+	s.numThreads = int(parse(20))
+	s.startTime = parse(22)
+	s.vsize = parse(23)
+	s.rss = parse(24)
+	if err != nil {
+		return s, fmt.Errorf("stat: %v", err)
+	}
+
+	return s, nil
+}
+
+// readStat reads /proc/pid/stat.
+func (p *Process) readStat() (procStat, error) {
+	data, err := ioutil.ReadFile(p.procPath("stat"))
+	if err != nil {
+		return procStat{}, err
+	}
+	return parseStat(data)
+}
+
+var bootTime struct {
+	once sync.Once
+	time time.Time
+	err  error
+}
+
+// getBootTime returns the time the system booted at, read from the btime line
+// of /proc/stat.
+func getBootTime() (time.Time, error) {
+	bootTime.once.Do(func() {
+		f, err := os.Open("/proc/stat")
+		if err != nil {
+			bootTime.err = err
+			return
+		}
+		defer f.Close()
+
+		bootTime.time, bootTime.err = parseBootTime(f)
+	})
+	return bootTime.time, bootTime.err
+}
+
+func parseBootTime(r io.Reader) (time.Time, error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 || fields[0] != "btime" {
+			continue
+		}
+		btime, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid btime '%s'", fields[1])
+		}
+		return time.Unix(btime, 0), nil
+	}
+	if err := scanner.Err(); err != nil {
+		return time.Time{}, err
+	}
+	return time.Time{}, fmt.Errorf("no btime in /proc/stat")
+}
+
+// ticksToTime converts a time in clock ticks after boot to a wall clock time.
+func ticksToTime(ticks uint64) (time.Time, error) {
+	boot, err := getBootTime()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return boot.Add(time.Duration(ticks) * time.Second / userHZ), nil
+}
+
+// parseStatus parses the "Key:\tvalue" lines of /proc/pid/status.
+func parseStatus(r io.Reader) (map[string]string, error) {
+	status := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		colon := strings.IndexByte(line, ':')
+		if colon == -1 {
+			continue
+		}
+		status[line[:colon]] = strings.TrimSpace(line[colon+1:])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// parseIDs parses the Uid and Gid lines of /proc/pid/status.
+func parseIDs(s string) (IDs, error) {
+	var ids IDs
+	fields := strings.Fields(s)
+	if len(fields) != 4 {
+		return ids, fmt.Errorf("invalid IDs '%s'", s)
+	}
+	for i, id := range []*int{&ids.Real, &ids.Effective, &ids.Saved, &ids.FS} {
+		v, err := strconv.ParseUint(fields[i], 10, 32)
+		if err != nil {
+			return ids, fmt.Errorf("invalid ID '%s'", fields[i])
+		}
+		*id = int(v)
+	}
+	return ids, nil
+}
+
+// parseCapabilities parses the capability sets of /proc/pid/status.
+func parseCapabilities(status map[string]string) (Capabilities, error) {
+	var caps Capabilities
+	for _, set := range []struct {
+		name  string
+		value *uint64
+	}{
+		{"CapInh", &caps.Inheritable},
+		{"CapPrm", &caps.Permitted},
+		{"CapEff", &caps.Effective},
+		{"CapBnd", &caps.Bounding},
+		{"CapAmb", &caps.Ambient},
+	} {
+		s, ok := status[set.name]
+		if !ok {
+			// CapAmb only exists since Linux 4.3.
+			if set.name == "CapAmb" {
+				continue
+			}
+			return caps, fmt.Errorf("no %s", set.name)
+		}
+		v, err := strconv.ParseUint(s, 16, 64)
+		if err != nil {
+			return caps, fmt.Errorf("invalid %s '%s'", set.name, s)
+		}
+		*set.value = v
+	}
+	return caps, nil
+}
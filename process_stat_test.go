@@ -0,0 +1,98 @@
+package obs
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseStat(t *testing.T) {
+	data := "1234 (my (weird) comm) S 1 1234 1234 0 -1 4194560 1109 0 0 0 " +
+		"12 34 0 0 20 0 3 0 5678 123456789 2048 18446744073709551615 " +
+		"1 1 0 0 0 0 0 4096 0 0 0 0 17 2 0 0 0 0 0\n"
+
+	s, err := parseStat([]byte(data))
+	assert.NoError(t, err)
+	assert.Equal(t, procStat{
+		comm:       "my (weird) comm",
+		state:      'S',
+		ppid:       1,
+		utime:      12,
+		stime:      34,
+		numThreads: 3,
+		startTime:  5678,
+		vsize:      123456789,
+		rss:        2048,
+	}, s)
+
+	for _, invalid := range []string{
+		"",
+		"1234 comm S 1",
+		"1234 (comm) S 1 2 3",
+		"1234 (comm) S foo 1234 1234 0 -1 4194560 1109 0 0 0 12 34 0 0 20 0 3 0 5678 123456789 2048",
+	} {
+		_, err := parseStat([]byte(invalid))
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestParseBootTime(t *testing.T) {
+	btime, err := parseBootTime(strings.NewReader("cpu 1 2 3\nctxt 42\nbtime 1500000000\nprocesses 10\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, time.Unix(1500000000, 0), btime)
+
+	_, err = parseBootTime(strings.NewReader("cpu 1 2 3\n"))
+	assert.Error(t, err)
+	_, err = parseBootTime(strings.NewReader("btime foo\n"))
+	assert.Error(t, err)
+}
+
+const testStatus = `Name:	bash
+Umask:	0022
+State:	S (sleeping)
+Tgid:	1234
+Pid:	1234
+PPid:	1
+Uid:	1000	1000	1000	1000
+Gid:	100	100	100	100
+Groups:	10 100
+CapInh:	0000000000000000
+CapPrm:	0000003fffffffff
+CapEff:	0000003fffffffff
+CapBnd:	0000003fffffffff
+CapAmb:	0000000000000000
+`
+
+func TestParseStatus(t *testing.T) {
+	status, err := parseStatus(strings.NewReader(testStatus))
+	assert.NoError(t, err)
+	assert.Equal(t, "bash", status["Name"])
+	assert.Equal(t, "S (sleeping)", status["State"])
+	assert.Equal(t, "1234", status["Tgid"])
+
+	uids, err := parseIDs(status["Uid"])
+	assert.NoError(t, err)
+	assert.Equal(t, IDs{1000, 1000, 1000, 1000}, uids)
+	_, err = parseIDs("1000 1000")
+	assert.Error(t, err)
+	_, err = parseIDs("1000 1000 foo 1000")
+	assert.Error(t, err)
+
+	caps, err := parseCapabilities(status)
+	assert.NoError(t, err)
+	assert.Equal(t, Capabilities{
+		Permitted: 0x3fffffffff,
+		Effective: 0x3fffffffff,
+		Bounding:  0x3fffffffff,
+	}, caps)
+
+	// Kernels older than 4.3 don't have ambient capabilities.
+	delete(status, "CapAmb")
+	_, err = parseCapabilities(status)
+	assert.NoError(t, err)
+	delete(status, "CapEff")
+	_, err = parseCapabilities(status)
+	assert.Error(t, err)
+}
@@ -1,7 +1,11 @@
 package obs
 
 import (
+	"os"
+	"path/filepath"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -25,3 +29,96 @@ func TestParseNS(t *testing.T) {
 		assert.Equal(t, test.expected, ns)
 	}
 }
+
+func TestProcessAccessors(t *testing.T) {
+	p := NewProcess(os.Getpid())
+
+	cmdline, err := p.Cmdline()
+	assert.NoError(t, err)
+	assert.Equal(t, os.Args, cmdline)
+
+	exe, err := p.Exe()
+	assert.NoError(t, err)
+	expected, _ := os.Executable()
+	assert.Equal(t, expected, exe)
+
+	cwd, err := p.Cwd()
+	assert.NoError(t, err)
+	expected, _ = os.Getwd()
+	assert.Equal(t, expected, cwd)
+
+	comm, err := p.Comm()
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Base(exe)[:len(comm)], comm)
+
+	ppid, err := p.PPID()
+	assert.NoError(t, err)
+	assert.Equal(t, os.Getppid(), ppid)
+
+	state, err := p.State()
+	assert.NoError(t, err)
+	// The state is the state of the main thread, which may be sleeping.
+	assert.Contains(t, []ProcessState{Running, Sleeping}, state)
+
+	threads, err := p.NumThreads()
+	assert.NoError(t, err)
+	assert.True(t, threads > 1)
+
+	start, err := p.StartTime()
+	assert.NoError(t, err)
+	assert.True(t, start.Before(time.Now()))
+	assert.True(t, time.Since(start) < time.Hour)
+
+	_, err = p.CPUUsage()
+	assert.NoError(t, err)
+	memory, err := p.MemoryUsage()
+	assert.NoError(t, err)
+	assert.True(t, memory.Resident > 0)
+	assert.True(t, memory.Virtual >= memory.Resident)
+
+	uids, err := p.UIDs()
+	assert.NoError(t, err)
+	assert.Equal(t, os.Getuid(), uids.Real)
+	assert.Equal(t, os.Geteuid(), uids.Effective)
+	gids, err := p.GIDs()
+	assert.NoError(t, err)
+	assert.Equal(t, os.Getgid(), gids.Real)
+	groups, err := p.Groups()
+	assert.NoError(t, err)
+	expectedGroups, _ := os.Getgroups()
+	assert.Equal(t, len(expectedGroups), len(groups))
+
+	caps, err := p.Capabilities()
+	assert.NoError(t, err)
+	assert.Equal(t, caps.Permitted&caps.Effective, caps.Effective)
+}
+
+func TestProcessErrors(t *testing.T) {
+	// A PID above the maximum PID the kernel can allocate.
+	p := NewProcess(5000000)
+	_, err := p.Cmdline()
+	assert.True(t, IsProcessGone(err))
+	assert.False(t, IsPermission(err))
+	_, err = p.PPID()
+	assert.True(t, IsProcessGone(err))
+	_, err = p.UIDs()
+	assert.True(t, IsProcessGone(err))
+	_, err = p.Namespace(NetworkNS)
+	assert.True(t, IsProcessGone(err))
+
+	err = p.error(&os.PathError{Op: "open", Path: "/proc/5000000/stat", Err: syscall.ESRCH})
+	assert.True(t, IsProcessGone(err))
+	err = p.error(&os.PathError{Op: "open", Path: "/proc/5000000/environ", Err: syscall.EACCES})
+	assert.True(t, IsPermission(err))
+	assert.False(t, IsProcessGone(err))
+
+	// Files missing for processes that exist aren't gone processes.
+	p = NewProcess(os.Getpid())
+	err = p.error(&os.PathError{Op: "readlink", Path: "/proc/self/exe", Err: syscall.ENOENT})
+	assert.False(t, IsProcessGone(err))
+}
+
+func TestProcessState(t *testing.T) {
+	assert.Equal(t, "zombie", Zombie.String())
+	assert.Equal(t, "unknown (W)", ProcessState('W').String())
+}
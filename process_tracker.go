@@ -0,0 +1,362 @@
+package obs
+
+import (
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultGracePeriod is how long exited processes are remembered by
+	// default.
+	defaultGracePeriod = 30 * time.Second
+
+	// taskCommLen is the size of the comm buffer of the kernel tasks,
+	// TASK_COMM_LEN, nul terminator included.
+	taskCommLen = 16
+
+	schedProcessExec = "sched:sched_process_exec"
+)
+
+// ProcessInfo is what a ProcessTracker knows about a process.
+type ProcessInfo struct {
+	// PID is the process ID.
+	PID int
+	// PPID is the process ID of the parent. It isn't updated when the
+	// parent exits and the process is reparented.
+	PPID int
+	// Comm is the name of the process.
+	Comm string
+	// Exe is the path of the executable.
+	Exe string
+	// Args are the command line arguments, Args[0] included.
+	Args []string
+	// Start is the time the process started.
+	Start time.Time
+	// Exit is the time the process exited, the zero time while it's
+	// running.
+	Exit time.Time
+	// Namespaces are the namespace inodes of the process.
+	Namespaces map[NamespaceKind]uint64
+}
+
+// Exited returns true if the process has exited.
+func (p *ProcessInfo) Exited() bool {
+	return !p.Exit.IsZero()
+}
+
+// ProcessTracker maintains a table of the processes running on the system.
+//
+// Reading /proc when an event is received is racy, short-lived processes may
+// be gone by then. The tracker reads /proc once when opened, and then follows
+// the fork and exit side-band records, see SideBandTask, and the
+// sched:sched_process_exec tracepoint to keep the table current. Exited
+// processes can still be looked up for a grace period.
+//
+// Forks and exits aren't followed with the sched:sched_process_fork and
+// sched:sched_process_exit tracepoints: they only carry thread IDs and can't
+// tell a new process from a new thread, nor the exit of a process from the
+// exit of one of its threads. The side-band records carry both the process and
+// the thread IDs.
+type ProcessTracker struct {
+	gracePeriod time.Duration
+	observer    *Observer
+	execs       EventSource
+	fields      struct{ pid, filename FieldHandle }
+	wg          sync.WaitGroup
+
+	mu        sync.Mutex
+	processes map[int]*ProcessInfo
+	// lastPurge is the last time exited processes have been purged.
+	lastPurge time.Time
+}
+
+// NewProcessTracker creates a ProcessTracker remembering exited processes for
+// gracePeriod, 30s if gracePeriod is 0.
+func NewProcessTracker(gracePeriod time.Duration) *ProcessTracker {
+	if gracePeriod == 0 {
+		gracePeriod = defaultGracePeriod
+	}
+	return &ProcessTracker{
+		gracePeriod: gracePeriod,
+		processes:   make(map[int]*ProcessInfo),
+	}
+}
+
+// Open starts tracking processes.
+func (t *ProcessTracker) Open() error {
+	t.observer = NewObserver()
+	t.observer.AddSoftwareEvent(Dummy, WithSideBand(SideBandTask))
+	t.execs = t.observer.AddTracepoint(schedProcessExec)
+	if err := t.observer.Open(); err != nil {
+		return err
+	}
+
+	var err error
+	for _, f := range []struct {
+		handle *FieldHandle
+		name   string
+	}{
+		{&t.fields.pid, "pid"},
+		{&t.fields.filename, "filename"},
+	} {
+		if *f.handle, err = t.observer.Field(t.execs, f.name); err != nil {
+			t.observer.Close()
+			return err
+		}
+	}
+
+	t.wg.Add(1)
+	go t.read()
+
+	// Scan /proc once we're following the events to not miss any process.
+	if err := t.scan(); err != nil {
+		t.Close()
+		return err
+	}
+	return nil
+}
+
+// scan adds the processes found in /proc to the table. Processes already
+// known through tracepoints are left untouched.
+func (t *ProcessTracker) scan() error {
+	pids, err := readPIDs("/proc")
+	if err != nil {
+		return err
+	}
+
+	for _, pid := range pids {
+		info, err := readProcessInfo(pid)
+		if err != nil {
+			// The process has exited since we've listed /proc.
+			continue
+		}
+
+		t.mu.Lock()
+		if _, ok := t.processes[pid]; !ok {
+			t.processes[pid] = info
+		}
+		t.mu.Unlock()
+	}
+
+	return nil
+}
+
+// readProcessInfo reads what we want to know about the process pid from
+// /proc.
+func readProcessInfo(pid int) (*ProcessInfo, error) {
+	p := NewProcess(pid)
+	stat, err := p.readStat()
+	if err != nil {
+		return nil, err
+	}
+
+	info := &ProcessInfo{
+		PID:        pid,
+		PPID:       stat.ppid,
+		Comm:       stat.comm,
+		Namespaces: readNamespaces(p),
+	}
+	info.Start, _ = ticksToTime(stat.startTime)
+	// Kernel threads don't have an executable nor a command line.
+	info.Exe, _ = p.Exe()
+	info.Args, _ = p.Cmdline()
+
+	return info, nil
+}
+
+// readNamespaces reads the namespaces of p, nil if they can't be read.
+func readNamespaces(p *Process) map[NamespaceKind]uint64 {
+	namespaces, _ := p.Namespaces()
+	return namespaces
+}
+
+func (t *ProcessTracker) read() {
+	defer t.wg.Done()
+
+	for {
+		event, err := t.observer.ReadEvent()
+		if err != nil || event == nil {
+			// The observer has been closed.
+			return
+		}
+
+		switch e := event.(type) {
+		case *ForkEvent:
+			// Threads belong to the process that created them.
+			if !e.Thread() {
+				t.fork(e.PPID, e.PID, perfTime(e.GetTimestamp()))
+			}
+		case *ExitEvent:
+			t.exit(e.PID, e.TID, perfTime(e.GetTimestamp()))
+		case *TracepointEvent:
+			t.exec(e.IntAt(t.fields.pid), e.StringAt(t.fields.filename))
+		}
+	}
+}
+
+// get returns the process pid, creating it if needed. t.mu must be held.
+func (t *ProcessTracker) get(pid int) *ProcessInfo {
+	info, ok := t.processes[pid]
+	if !ok {
+		info = &ProcessInfo{PID: pid}
+		t.processes[pid] = info
+	}
+	return info
+}
+
+// fork records the creation of the process child by the process parent at
+// time at. Events of different CPUs aren't received in order, the child may
+// already be known through an exec or an exit event.
+func (t *ProcessTracker) fork(parent, child int, at time.Time) {
+	// Namespaces can be created when forking, read them before taking the
+	// lock.
+	namespaces := readNamespaces(NewProcess(child))
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.purge()
+
+	info, known := t.processes[child]
+	if known && info.Exited() && info.Exit.Before(at) {
+		// A previous process with that PID, the PID has been reused.
+		known = false
+	}
+	if !known {
+		info = &ProcessInfo{PID: child}
+		t.processes[child] = info
+	}
+	info.PPID = parent
+	info.Start = at
+
+	// The child inherits what it hasn't changed yet from its parent.
+	p := t.processes[parent]
+	if p != nil && info.Comm == "" {
+		info.Comm = p.Comm
+	}
+	if p != nil && info.Exe == "" {
+		info.Exe = p.Exe
+		info.Args = p.Args
+	}
+	if len(namespaces) == 0 && p != nil {
+		namespaces = p.Namespaces
+	}
+	if info.Namespaces == nil {
+		info.Namespaces = namespaces
+	}
+}
+
+// exec records the execution of a new program by pid.
+func (t *ProcessTracker) exec(pid int, filename string) {
+	p := NewProcess(pid)
+	exe, err := p.Exe()
+	if err != nil {
+		exe = filename
+	}
+	args, _ := p.Cmdline()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.purge()
+
+	info := t.get(pid)
+	info.Exe = exe
+	info.Args = args
+	// The kernel names the process after the file being executed.
+	comm := filepath.Base(filename)
+	if len(comm) > taskCommLen-1 {
+		comm = comm[:taskCommLen-1]
+	}
+	info.Comm = comm
+}
+
+// exit records the exit of the task tid of the process pid at time at. The
+// process may not be known yet, when the exit event is received before the
+// fork event. Threads exiting don't end their process.
+func (t *ProcessTracker) exit(pid, tid int, at time.Time) {
+	if pid != tid {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.purge()
+
+	t.get(pid).Exit = at
+}
+
+// purge forgets the processes that have exited more than the grace period
+// ago. The table is purged at most every grace period. t.mu must be held.
+func (t *ProcessTracker) purge() {
+	now := time.Now()
+	if now.Sub(t.lastPurge) < t.gracePeriod {
+		return
+	}
+	t.lastPurge = now
+
+	for pid, info := range t.processes {
+		if info.Exited() && now.Sub(info.Exit) > t.gracePeriod {
+			delete(t.processes, pid)
+		}
+	}
+}
+
+// copyInfo returns a copy of info the caller can keep.
+func copyInfo(info *ProcessInfo) ProcessInfo {
+	c := *info
+	c.Args = append([]string(nil), info.Args...)
+	c.Namespaces = make(map[NamespaceKind]uint64, len(info.Namespaces))
+	for kind, ns := range info.Namespaces {
+		c.Namespaces[kind] = ns
+	}
+	return c
+}
+
+// Lookup returns the process pid. Processes that have exited are returned for
+// the grace period of the tracker.
+func (t *ProcessTracker) Lookup(pid int) (ProcessInfo, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	info, ok := t.processes[pid]
+	if !ok || (info.Exited() && time.Since(info.Exit) > t.gracePeriod) {
+		return ProcessInfo{}, false
+	}
+	return copyInfo(info), true
+}
+
+// Processes returns the processes currently running.
+func (t *ProcessTracker) Processes() []ProcessInfo {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	processes := make([]ProcessInfo, 0, len(t.processes))
+	for _, info := range t.processes {
+		if !info.Exited() {
+			processes = append(processes, copyInfo(info))
+		}
+	}
+	return processes
+}
+
+// Children returns the PIDs of the running children of the process pid.
+func (t *ProcessTracker) Children(pid int) []int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var children []int
+	for _, info := range t.processes {
+		if info.PPID == pid && !info.Exited() {
+			children = append(children, info.PID)
+		}
+	}
+	return children
+}
+
+// Close stops tracking processes.
+func (t *ProcessTracker) Close() {
+	if t.observer != nil {
+		t.observer.Close()
+	}
+	t.wg.Wait()
+}
@@ -0,0 +1,155 @@
+package obs
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// PIDs above the maximum PID the kernel can allocate, 2^22, so /proc doesn't
+// have anything to say about them.
+const (
+	testParentPID = 5000000
+	testChildPID  = 5000001
+)
+
+func TestProcessTracker(t *testing.T) {
+	tracker := NewProcessTracker(time.Hour)
+	tracker.processes[testParentPID] = &ProcessInfo{
+		PID:        testParentPID,
+		PPID:       1,
+		Comm:       "bash",
+		Exe:        "/bin/bash",
+		Args:       []string{"bash"},
+		Namespaces: map[NamespaceKind]uint64{NetworkNS: 4026531993},
+	}
+
+	// The child inherits from its parent until it execs.
+	start := time.Now()
+	tracker.fork(testParentPID, testChildPID, start)
+	info, ok := tracker.Lookup(testChildPID)
+	assert.True(t, ok)
+	assert.Equal(t, testParentPID, info.PPID)
+	assert.Equal(t, "bash", info.Comm)
+	assert.Equal(t, "/bin/bash", info.Exe)
+	assert.Equal(t, uint64(4026531993), info.Namespaces[NetworkNS])
+	assert.Equal(t, start, info.Start)
+	assert.Equal(t, []int{testChildPID}, tracker.Children(testParentPID))
+
+	tracker.exec(testChildPID, "/usr/bin/a-very-long-program-name")
+	info, _ = tracker.Lookup(testChildPID)
+	assert.Equal(t, "/usr/bin/a-very-long-program-name", info.Exe)
+	assert.Equal(t, "a-very-long-pro", info.Comm)
+
+	// Threads exiting don't end their process, nor are tracked.
+	tracker.exit(testChildPID, testChildPID+1, start)
+	info, _ = tracker.Lookup(testChildPID)
+	assert.False(t, info.Exited())
+	_, ok = tracker.Lookup(testChildPID + 1)
+	assert.False(t, ok)
+
+	// Exited processes can still be looked up.
+	exit := start.Add(time.Second)
+	tracker.exit(testChildPID, testChildPID, exit)
+	info, ok = tracker.Lookup(testChildPID)
+	assert.True(t, ok)
+	assert.True(t, info.Exited())
+	assert.Equal(t, exit, info.Exit)
+	assert.Len(t, tracker.Children(testParentPID), 0)
+	for _, p := range tracker.Processes() {
+		assert.NotEqual(t, testChildPID, p.PID)
+	}
+
+	// The PID is reused by a new process.
+	tracker.fork(testParentPID, testChildPID, exit.Add(time.Second))
+	info, _ = tracker.Lookup(testChildPID)
+	assert.False(t, info.Exited())
+	assert.Equal(t, "bash", info.Comm)
+	assert.Equal(t, "/bin/bash", info.Exe)
+
+	// Lookup returns copies.
+	info, _ = tracker.Lookup(testParentPID)
+	info.Args[0] = "foo"
+	info.Namespaces[NetworkNS] = 0
+	info, _ = tracker.Lookup(testParentPID)
+	assert.Equal(t, []string{"bash"}, info.Args)
+	assert.Equal(t, uint64(4026531993), info.Namespaces[NetworkNS])
+}
+
+func TestProcessTrackerGracePeriod(t *testing.T) {
+	tracker := NewProcessTracker(time.Millisecond)
+	tracker.fork(testParentPID, testChildPID, time.Now())
+	tracker.exit(testChildPID, testChildPID, time.Now())
+	time.Sleep(2 * time.Millisecond)
+
+	_, ok := tracker.Lookup(testChildPID)
+	assert.False(t, ok)
+	// The next event purges the table, only leaving the process it's about.
+	tracker.exit(testParentPID, testParentPID, time.Now())
+	assert.Len(t, tracker.processes, 1)
+	_, ok = tracker.processes[testParentPID]
+	assert.True(t, ok)
+}
+
+func TestProcessTrackerOutOfOrder(t *testing.T) {
+	tracker := NewProcessTracker(0)
+
+	// The exec and exit of the child are received before the fork.
+	start := time.Now()
+	tracker.exec(testChildPID, "/bin/true")
+	tracker.exit(testChildPID, testChildPID, start.Add(time.Millisecond))
+	tracker.fork(testParentPID, testChildPID, start)
+
+	info, ok := tracker.Lookup(testChildPID)
+	assert.True(t, ok)
+	assert.Equal(t, testParentPID, info.PPID)
+	assert.Equal(t, "true", info.Comm)
+	assert.Equal(t, "/bin/true", info.Exe)
+	assert.True(t, info.Exited())
+	assert.Equal(t, start, info.Start)
+}
+
+func TestProcessTrackerLive(t *testing.T) {
+	tracker := NewProcessTracker(0)
+	if err := tracker.Open(); err != nil {
+		t.Skipf("unable to open the sched tracepoints: %v", err)
+	}
+	defer tracker.Close()
+
+	// Processes running before Open are found through /proc.
+	info, ok := tracker.Lookup(os.Getpid())
+	assert.True(t, ok)
+	assert.Equal(t, os.Getppid(), info.PPID)
+	assert.False(t, info.Start.IsZero())
+	assert.NotEmpty(t, info.Namespaces)
+
+	before := time.Now()
+	cmd := exec.Command("sleep", "0.1")
+	assert.NoError(t, cmd.Run())
+	after := time.Now()
+	pid := cmd.Process.Pid
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		info, ok = tracker.Lookup(pid)
+		if ok && info.Exited() && info.Comm == "sleep" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("sleep not tracked: %+v", info)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, os.Getpid(), info.PPID)
+	assert.True(t, strings.HasSuffix(info.Exe, "sleep"), info.Exe)
+	assert.Equal(t, []string{"sleep", "0.1"}, info.Args)
+	// The times are the times of the events.
+	slack := 10 * time.Millisecond
+	assert.True(t, info.Start.After(before.Add(-slack)), "started %v, before %v", info.Start, before)
+	assert.True(t, info.Exit.Sub(info.Start) >= 100*time.Millisecond, "ran %v", info.Exit.Sub(info.Start))
+	assert.True(t, info.Exit.Before(after.Add(slack)), "exited %v, after %v", info.Exit, after)
+}
@@ -0,0 +1,451 @@
+package obs
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// kernelMapping is the name given to the kernel image by perf and pprof.
+const kernelMapping = "[kernel.kallsyms]"
+
+// ProfileEncoder writes profiles in formats understood by standard tools. The
+// frames of the stacks are resolved to function names when the encoder is
+// given the symbols to do so, and written as addresses otherwise.
+type ProfileEncoder struct {
+	kernel *KernelSymbols
+	user   *Symbolizer
+}
+
+// NewProfileEncoder creates a ProfileEncoder resolving kernel frames with
+// kernel and user space frames with user. Either can be nil.
+func NewProfileEncoder(kernel *KernelSymbols, user *Symbolizer) *ProfileEncoder {
+	return &ProfileEncoder{
+		kernel: kernel,
+		user:   user,
+	}
+}
+
+// profileFrame is a resolved stack frame.
+type profileFrame struct {
+	addr   uint64
+	kernel bool
+	// name is the function name, "" if unknown.
+	name string
+	// module is the kernel module or the user space file the frame belongs
+	// to.
+	module  string
+	buildID string
+	file    string
+	line    int
+}
+
+// foldedName returns the name of f in folded stacks. Kernel frames are
+// annotated with a "_[k]" suffix, as done by stackcollapse-perf.pl.
+func (f *profileFrame) foldedName() string {
+	name := f.name
+	if name == "" {
+		switch {
+		case f.kernel || f.module == "":
+			name = fmt.Sprintf("%#x", f.addr)
+		case strings.HasPrefix(f.module, "["):
+			// Pseudo-paths, eg. "[vdso]".
+			name = f.module
+		default:
+			name = "[" + f.module + "]"
+		}
+	}
+	if f.kernel {
+		name += "_[k]"
+	}
+	return name
+}
+
+// frameKey identifies a frame in the frame cache. User space addresses are
+// per process. Leaf frames are looked up differently than the other frames,
+// see lookupAddr.
+type frameKey struct {
+	pid  int
+	addr uint64
+	leaf bool
+}
+
+// stackResolver resolves the frames of the samples of a profile, caching the
+// frames seen so far.
+type stackResolver struct {
+	e      *ProfileEncoder
+	frames map[frameKey]*profileFrame
+}
+
+func (e *ProfileEncoder) newStackResolver() *stackResolver {
+	return &stackResolver{
+		e:      e,
+		frames: make(map[frameKey]*profileFrame),
+	}
+}
+
+// lookupAddr returns the address to look up for the i-th frame of a
+// callchain. Frames but the first one are return addresses, pointing after
+// the call instruction, which may be the first instruction of the next
+// function.
+func lookupAddr(addr uint64, i int) uint64 {
+	if i == 0 || addr == 0 {
+		return addr
+	}
+	return addr - 1
+}
+
+func (r *stackResolver) kernelFrame(addr uint64, i int) *profileFrame {
+	key := frameKey{pid: -1, addr: addr, leaf: i == 0}
+	if f, ok := r.frames[key]; ok {
+		return f
+	}
+
+	f := &profileFrame{addr: addr, kernel: true}
+	if r.e.kernel != nil {
+		if sym, _ := r.e.kernel.Lookup(lookupAddr(addr, i)); sym != nil {
+			f.name = sym.Name
+			f.module = sym.Module
+		}
+	}
+	r.frames[key] = f
+	return f
+}
+
+func (r *stackResolver) userFrame(pid int, addr uint64, i int) *profileFrame {
+	key := frameKey{pid: pid, addr: addr, leaf: i == 0}
+	if f, ok := r.frames[key]; ok {
+		return f
+	}
+
+	f := &profileFrame{addr: addr}
+	if r.e.user != nil {
+		// Symbolize returns what it could resolve along with errors.
+		sym, _ := r.e.user.Symbolize(pid, lookupAddr(addr, i))
+		f.name = sym.Name
+		f.module = sym.Module
+		f.buildID = sym.BuildID
+		f.file = sym.File
+		f.line = sym.Line
+	}
+	r.frames[key] = f
+	return f
+}
+
+// resolve returns the frames of s, leaf first.
+func (r *stackResolver) resolve(s *ProfileSample) []*profileFrame {
+	frames := make([]*profileFrame, 0, len(s.Kernel)+len(s.User))
+	for i, addr := range s.Kernel {
+		frames = append(frames, r.kernelFrame(addr, i))
+	}
+	for i, addr := range s.User {
+		frames = append(frames, r.userFrame(s.PID, addr, i))
+	}
+	return frames
+}
+
+// processLabel returns the name of the process of s in folded stacks, eg.
+// "nginx-1234".
+func processLabel(s *ProfileSample) string {
+	if s.Comm == "" {
+		return strconv.Itoa(s.PID)
+	}
+	return s.Comm + "-" + strconv.Itoa(s.PID)
+}
+
+// WriteFolded writes p in the folded stack format used by Brendan Gregg's
+// FlameGraph tools, one line per stack:
+//
+//	nginx-1234;main;ngx_process_events;epoll_wait;do_syscall_64_[k] 42
+//
+// Stacks are rooted at the process name and PID. Kernel frames are suffixed
+// with "_[k]". Threads of a same process sampled with identical stacks are
+// merged.
+func (e *ProfileEncoder) WriteFolded(w io.Writer, p *Profile) error {
+	r := e.newStackResolver()
+	counts := make(map[string]uint64)
+
+	for i := range p.Samples {
+		s := &p.Samples[i]
+		frames := r.resolve(s)
+
+		names := make([]string, 0, len(frames)+1)
+		names = append(names, processLabel(s))
+		for j := len(frames) - 1; j >= 0; j-- {
+			names = append(names, frames[j].foldedName())
+		}
+		counts[strings.Join(names, ";")] += s.Count
+	}
+
+	stacks := make([]string, 0, len(counts))
+	for stack := range counts {
+		stacks = append(stacks, stack)
+	}
+	sort.Strings(stacks)
+
+	bw := bufio.NewWriter(w)
+	for _, stack := range stacks {
+		fmt.Fprintf(bw, "%s %d\n", stack, counts[stack])
+	}
+	return bw.Flush()
+}
+
+// These constants are the field numbers of the profile.proto messages.
+const (
+	// Profile
+	pprofSampleType    = 1
+	pprofSample        = 2
+	pprofMapping       = 3
+	pprofLocation      = 4
+	pprofFunction      = 5
+	pprofStringTable   = 6
+	pprofDurationNanos = 10
+	pprofPeriodType    = 11
+	pprofPeriod        = 12
+
+	// ValueType
+	pprofValueTypeType = 1
+	pprofValueTypeUnit = 2
+
+	// Sample
+	pprofSampleLocationID = 1
+	pprofSampleValue      = 2
+	pprofSampleLabel      = 3
+
+	// Label
+	pprofLabelKey = 1
+	pprofLabelStr = 2
+	pprofLabelNum = 3
+
+	// Mapping
+	pprofMappingID           = 1
+	pprofMappingFilename     = 5
+	pprofMappingBuildID      = 6
+	pprofMappingHasFunctions = 7
+
+	// Location
+	pprofLocationID        = 1
+	pprofLocationMappingID = 2
+	pprofLocationAddress   = 3
+	pprofLocationLine      = 4
+
+	// Line
+	pprofLineFunctionID = 1
+	pprofLineLine       = 2
+
+	// Function
+	pprofFunctionID         = 1
+	pprofFunctionName       = 2
+	pprofFunctionSystemName = 3
+	pprofFunctionFilename   = 4
+)
+
+// pprofMappingKey identifies a mapping of a pprof profile.
+type pprofMappingKey struct {
+	kernel  bool
+	module  string
+	buildID string
+}
+
+// pprofFunctionKey identifies a function of a pprof profile.
+type pprofFunctionKey struct {
+	name string
+	file string
+}
+
+// pprofEncoder builds a profile.proto message.
+type pprofEncoder struct {
+	b       protoBuffer
+	strings map[string]int64
+	// mappings, locations and functions map their keys to their IDs.
+	mappings  map[pprofMappingKey]uint64
+	locations map[*profileFrame]uint64
+	functions map[pprofFunctionKey]uint64
+	// kernelFunctions and userFunctions are true when the kernel and user
+	// space frames are resolved.
+	kernelFunctions, userFunctions bool
+}
+
+// string returns the index of s in the string table, adding s to the table
+// if needed. The string table itself is encoded last.
+func (pe *pprofEncoder) string(s string) int64 {
+	if i, ok := pe.strings[s]; ok {
+		return i
+	}
+	i := int64(len(pe.strings))
+	pe.strings[s] = i
+	return i
+}
+
+func (pe *pprofEncoder) valueType(tag int, typ, unit string) {
+	pe.b.message(tag, func() {
+		pe.b.int64(pprofValueTypeType, pe.string(typ))
+		pe.b.int64(pprofValueTypeUnit, pe.string(unit))
+	})
+}
+
+func (pe *pprofEncoder) mapping(f *profileFrame) uint64 {
+	key := pprofMappingKey{kernel: f.kernel, module: f.module, buildID: f.buildID}
+	if f.kernel {
+		// Kernel modules are mapped in the kernel address space, they're
+		// given as the file name of their functions.
+		key.module = kernelMapping
+	}
+	if id, ok := pe.mappings[key]; ok {
+		return id
+	}
+
+	id := uint64(len(pe.mappings) + 1)
+	pe.mappings[key] = id
+	hasFunctions := pe.userFunctions
+	if f.kernel {
+		hasFunctions = pe.kernelFunctions
+	}
+	pe.b.message(pprofMapping, func() {
+		pe.b.uint64(pprofMappingID, id)
+		pe.b.int64(pprofMappingFilename, pe.string(key.module))
+		pe.b.int64(pprofMappingBuildID, pe.string(key.buildID))
+		pe.b.bool(pprofMappingHasFunctions, hasFunctions)
+	})
+	return id
+}
+
+func (pe *pprofEncoder) function(f *profileFrame) uint64 {
+	file := f.file
+	if f.kernel && f.module != "" {
+		file = "[" + f.module + "]"
+	}
+	key := pprofFunctionKey{name: f.name, file: file}
+	if id, ok := pe.functions[key]; ok {
+		return id
+	}
+
+	id := uint64(len(pe.functions) + 1)
+	pe.functions[key] = id
+	pe.b.message(pprofFunction, func() {
+		pe.b.uint64(pprofFunctionID, id)
+		pe.b.int64(pprofFunctionName, pe.string(key.name))
+		pe.b.int64(pprofFunctionSystemName, pe.string(key.name))
+		pe.b.int64(pprofFunctionFilename, pe.string(key.file))
+	})
+	return id
+}
+
+func (pe *pprofEncoder) location(f *profileFrame) uint64 {
+	if id, ok := pe.locations[f]; ok {
+		return id
+	}
+
+	var functionID uint64
+	if f.name != "" {
+		functionID = pe.function(f)
+	}
+	mappingID := pe.mapping(f)
+
+	id := uint64(len(pe.locations) + 1)
+	pe.locations[f] = id
+	pe.b.message(pprofLocation, func() {
+		pe.b.uint64(pprofLocationID, id)
+		pe.b.uint64(pprofLocationMappingID, mappingID)
+		pe.b.uint64(pprofLocationAddress, f.addr)
+		if functionID != 0 {
+			pe.b.message(pprofLocationLine, func() {
+				pe.b.uint64(pprofLineFunctionID, functionID)
+				pe.b.int64(pprofLineLine, int64(f.line))
+			})
+		}
+	})
+	return id
+}
+
+func (pe *pprofEncoder) label(key string, str string) {
+	pe.b.message(pprofSampleLabel, func() {
+		pe.b.int64(pprofLabelKey, pe.string(key))
+		pe.b.int64(pprofLabelStr, pe.string(str))
+	})
+}
+
+// numLabel adds a numeric label. The value is always encoded, a label
+// without str nor num would read back as an empty label.
+func (pe *pprofEncoder) numLabel(key string, num int64) {
+	pe.b.message(pprofSampleLabel, func() {
+		pe.b.int64(pprofLabelKey, pe.string(key))
+		pe.b.explicitInt64(pprofLabelNum, num)
+	})
+}
+
+// samplePeriod returns the number of CPU clock nanoseconds each sample of p
+// accounts for.
+func samplePeriod(p *Profile) int64 {
+	if p.Frequency != 0 {
+		return int64(1e9 / p.Frequency)
+	}
+	return int64(p.Period)
+}
+
+// WritePprof writes p as a gzipped profile.proto, the format read by go tool
+// pprof. Samples have two values, the number of samples and the CPU time they
+// account for, and are labeled with the "pid", "tid" and "comm" of the
+// sampled thread. Kernel frames belong to the "[kernel.kallsyms]" mapping and
+// the functions of kernel modules have the module name, eg. "[nf_tables]", as
+// file name.
+func (e *ProfileEncoder) WritePprof(w io.Writer, p *Profile) error {
+	r := e.newStackResolver()
+	pe := &pprofEncoder{
+		strings:         map[string]int64{"": 0},
+		mappings:        make(map[pprofMappingKey]uint64),
+		locations:       make(map[*profileFrame]uint64),
+		functions:       make(map[pprofFunctionKey]uint64),
+		kernelFunctions: e.kernel != nil,
+		userFunctions:   e.user != nil,
+	}
+	period := samplePeriod(p)
+
+	pe.valueType(pprofSampleType, "samples", "count")
+	pe.valueType(pprofSampleType, "cpu", "nanoseconds")
+
+	// Locations, mappings and functions are encoded the first time they're
+	// referenced, before the sample referencing them.
+	ids := make([]uint64, 0, 64)
+	for i := range p.Samples {
+		s := &p.Samples[i]
+
+		ids = ids[:0]
+		for _, f := range r.resolve(s) {
+			ids = append(ids, pe.location(f))
+		}
+
+		pe.b.message(pprofSample, func() {
+			pe.b.packedUint64(pprofSampleLocationID, ids)
+			pe.b.packedInt64(pprofSampleValue, []int64{int64(s.Count), int64(s.Count) * period})
+			pe.numLabel("pid", int64(s.PID))
+			pe.numLabel("tid", int64(s.TID))
+			if s.Comm != "" {
+				pe.label("comm", s.Comm)
+			}
+		})
+	}
+
+	pe.valueType(pprofPeriodType, "cpu", "nanoseconds")
+	pe.b.int64(pprofPeriod, period)
+	pe.b.uint64(pprofDurationNanos, p.End-p.Start)
+
+	// The string table is indexed, the empty string first.
+	table := make([]string, len(pe.strings))
+	for s, i := range pe.strings {
+		table[i] = s
+	}
+	for _, s := range table {
+		pe.b.repeatedString(pprofStringTable, s)
+	}
+
+	zw := gzip.NewWriter(w)
+	if _, err := zw.Write(pe.b.data); err != nil {
+		return err
+	}
+	return zw.Close()
+}
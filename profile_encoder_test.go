@@ -0,0 +1,217 @@
+package obs
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testProfile = Profile{
+	Frequency: 100,
+	Start:     1000,
+	End:       3000,
+	Samples: []ProfileSample{
+		{PID: 1, TID: 1, Comm: "foo", Kernel: []uint64{0xffffffff8100101a, 0xffffffff81002010}, User: []uint64{0x401000, 0x402000}, Count: 2},
+		{PID: 1, TID: 2, Comm: "foo", Kernel: []uint64{0xffffffff8100101a, 0xffffffff81002010}, User: []uint64{0x401000, 0x402000}, Count: 1},
+		{PID: 2, TID: 3, Kernel: []uint64{0xffffffffc0a01010}, Count: 1},
+	},
+}
+
+func TestWriteFolded(t *testing.T) {
+	k, err := ParseKernelSymbols(strings.NewReader(testKallsyms))
+	assert.Nil(t, err)
+
+	var buf bytes.Buffer
+	assert.Nil(t, NewProfileEncoder(k, nil).WriteFolded(&buf, &testProfile))
+	assert.Equal(t, `2;foo_init_[k] 1
+foo-1;0x402000;0x401000;kmem_cache_alloc_[k];do_sys_open_[k] 3
+`, buf.String())
+
+	buf.Reset()
+	assert.Nil(t, NewProfileEncoder(nil, nil).WriteFolded(&buf, &testProfile))
+	assert.Equal(t, `2;0xffffffffc0a01010_[k] 1
+foo-1;0x402000;0x401000;0xffffffff81002010_[k];0xffffffff8100101a_[k] 3
+`, buf.String())
+}
+
+// protoField is a decoded protocol buffer field.
+type protoField struct {
+	tag   int
+	value uint64
+	data  []byte
+}
+
+// decodeProto decodes the fields of a protocol buffer message. Length
+// delimited fields are left undecoded.
+func decodeProto(t *testing.T, data []byte) []protoField {
+	var fields []protoField
+
+	varint := func() uint64 {
+		var x uint64
+		for shift := uint(0); ; shift += 7 {
+			if len(data) == 0 {
+				t.Fatal("truncated varint")
+			}
+			b := data[0]
+			data = data[1:]
+			x |= uint64(b&0x7f) << shift
+			if b < 0x80 {
+				return x
+			}
+		}
+	}
+
+	for len(data) > 0 {
+		key := varint()
+		f := protoField{tag: int(key >> 3)}
+		switch key & 7 {
+		case protoWireVarint:
+			f.value = varint()
+		case protoWireBytes:
+			n := int(varint())
+			f.data, data = data[:n], data[n:]
+		default:
+			t.Fatalf("unexpected wire type %d", key&7)
+		}
+		fields = append(fields, f)
+	}
+
+	return fields
+}
+
+// fieldsByTag groups fields by tag.
+func fieldsByTag(fields []protoField) map[int][]protoField {
+	m := make(map[int][]protoField)
+	for _, f := range fields {
+		m[f.tag] = append(m[f.tag], f)
+	}
+	return m
+}
+
+func TestProtoBuffer(t *testing.T) {
+	var b protoBuffer
+	b.uint64(1, 300)
+	b.uint64(2, 0)
+	b.string(3, "foo")
+	b.packedUint64(4, []uint64{1, 2})
+	b.message(5, func() { b.bool(1, true) })
+	b.explicitInt64(6, 0)
+
+	assert.Equal(t, []byte{
+		0x08, 0xac, 0x02,
+		0x1a, 3, 'f', 'o', 'o',
+		0x22, 2, 1, 2,
+		0x2a, 2, 0x08, 1,
+		0x30, 0,
+	}, b.data)
+}
+
+// decodePprof decodes a gzipped pprof profile, returning its fields and its
+// string table.
+func decodePprof(t *testing.T, r io.Reader) (map[int][]protoField, []string) {
+	zr, err := gzip.NewReader(r)
+	assert.Nil(t, err)
+	data, err := ioutil.ReadAll(zr)
+	assert.Nil(t, err)
+
+	profile := fieldsByTag(decodeProto(t, data))
+	var table []string
+	for _, f := range profile[pprofStringTable] {
+		table = append(table, string(f.data))
+	}
+	return profile, table
+}
+
+// pprofLabels returns the labels of a pprof sample.
+func pprofLabels(t *testing.T, sample map[int][]protoField, table []string) map[string]interface{} {
+	labels := make(map[string]interface{})
+	for _, f := range sample[pprofSampleLabel] {
+		label := fieldsByTag(decodeProto(t, f.data))
+		key := table[label[pprofLabelKey][0].value]
+		if str, ok := label[pprofLabelStr]; ok {
+			labels[key] = table[str[0].value]
+		} else if num, ok := label[pprofLabelNum]; ok {
+			labels[key] = num[0].value
+		} else {
+			labels[key] = nil
+		}
+	}
+	return labels
+}
+
+func TestWritePprof(t *testing.T) {
+	k, err := ParseKernelSymbols(strings.NewReader(testKallsyms))
+	assert.Nil(t, err)
+
+	var buf bytes.Buffer
+	assert.Nil(t, NewProfileEncoder(k, nil).WritePprof(&buf, &testProfile))
+
+	profile, table := decodePprof(t, &buf)
+	assert.Equal(t, "", table[0])
+
+	assert.Len(t, profile[pprofSampleType], 2)
+	assert.Len(t, profile[pprofSample], 3)
+	// The kernel and a mapping for the unresolved user space frames.
+	assert.Len(t, profile[pprofMapping], 2)
+	// The leaf kernel frame, two kernel callers, two user frames.
+	assert.Len(t, profile[pprofLocation], 5)
+	assert.Len(t, profile[pprofFunction], 3)
+	assert.Equal(t, uint64(10000000), profile[pprofPeriod][0].value)
+	assert.Equal(t, uint64(2000), profile[pprofDurationNanos][0].value)
+
+	var functions []string
+	for _, f := range profile[pprofFunction] {
+		function := fieldsByTag(decodeProto(t, f.data))
+		name := table[function[pprofFunctionName][0].value]
+		if file, ok := function[pprofFunctionFilename]; ok {
+			name += " " + table[file[0].value]
+		}
+		functions = append(functions, name)
+	}
+	assert.Equal(t, []string{"do_sys_open", "kmem_cache_alloc", "foo_init [foo]"}, functions)
+
+	var mappings []string
+	for _, f := range profile[pprofMapping] {
+		mapping := fieldsByTag(decodeProto(t, f.data))
+		name := ""
+		if filename, ok := mapping[pprofMappingFilename]; ok {
+			name = table[filename[0].value]
+		}
+		mappings = append(mappings, name)
+	}
+	assert.Equal(t, []string{kernelMapping, ""}, mappings)
+
+	// The first sample: values and labels.
+	sample := fieldsByTag(decodeProto(t, profile[pprofSample][0].data))
+	// 2 samples, 20ms.
+	assert.Equal(t, []byte{2, 0x80, 0xda, 0xc4, 0x09}, sample[pprofSampleValue][0].data)
+	assert.Equal(t, map[string]interface{}{
+		"pid":  uint64(1),
+		"tid":  uint64(1),
+		"comm": "foo",
+	}, pprofLabels(t, sample, table))
+}
+
+func TestWritePprofIdle(t *testing.T) {
+	var buf bytes.Buffer
+	assert.Nil(t, NewProfileEncoder(nil, nil).WritePprof(&buf, &Profile{
+		Frequency: 100,
+		Samples: []ProfileSample{
+			{PID: 0, TID: 0, Comm: "swapper", Kernel: []uint64{0xffffffff8100101a}, Count: 1},
+		},
+	}))
+
+	// The idle tasks are labeled with a pid and a tid of 0.
+	profile, table := decodePprof(t, &buf)
+	sample := fieldsByTag(decodeProto(t, profile[pprofSample][0].data))
+	assert.Equal(t, map[string]interface{}{
+		"pid":  uint64(0),
+		"tid":  uint64(0),
+		"comm": "swapper",
+	}, pprofLabels(t, sample, table))
+}
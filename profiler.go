@@ -0,0 +1,418 @@
+package obs
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// These constants are linux ABI, defined as PERF_CONTEXT_* in
+// <linux/perf_event.h>. They are found in callchains, marking the start of
+// the frames of a context.
+const (
+	perfContextHV          = ^uint64(32 - 1)
+	perfContextKernel      = ^uint64(128 - 1)
+	perfContextUser        = ^uint64(512 - 1)
+	perfContextGuest       = ^uint64(2048 - 1)
+	perfContextGuestKernel = ^uint64(2176 - 1)
+	perfContextGuestUser   = ^uint64(2560 - 1)
+	perfContextMax         = ^uint64(4095 - 1)
+)
+
+// These constants are linux ABI, defined as PERF_RECORD_MISC_* in
+// <linux/perf_event.h>.
+const (
+	perfRecordMiscCPUModeMask = 7
+	perfRecordMiscKernel      = 1
+	perfRecordMiscUser        = 2
+)
+
+const (
+	// defaultProfileFrequency is the default sampling frequency of a
+	// Profiler. It's low enough to profile a whole host continuously and
+	// not a multiple of common timer frequencies, to avoid lockstep
+	// sampling.
+	defaultProfileFrequency = 99
+)
+
+// ProfileSample is a stack that has been sampled by a Profiler, with the
+// number of times it has been sampled.
+type ProfileSample struct {
+	// PID is the process ID of the thread the stack was sampled from.
+	PID int
+	// TID is the ID of the thread the stack was sampled from.
+	TID int
+	// Comm is the name of the process the stack was sampled from, "" if
+	// the process had exited before its name could be read.
+	Comm string
+	// Kernel is the list of kernel instruction pointers, leaf first.
+	Kernel []uint64
+	// User is the list of user space instruction pointers, leaf first.
+	User []uint64
+	// Count is the number of times this stack was sampled.
+	Count uint64
+}
+
+// Profile is the result of a profiling session.
+type Profile struct {
+	// Frequency is the sampling frequency, in Hz, when the profiler samples
+	// at a given frequency.
+	Frequency uint64
+	// Period is the number of CPU clock nanoseconds between two samples, when
+	// the profiler samples with a fixed period.
+	Period uint64
+	// Start and End are the times of the first and last samples, in
+	// nanoseconds of the perf clock.
+	Start, End uint64
+	// Samples is the list of sampled stacks, most sampled first.
+	Samples []ProfileSample
+	// Lost is the number of samples the kernel had to drop because they
+	// weren't read fast enough.
+	Lost uint64
+}
+
+// Profiler samples the stacks of all the threads running on all CPUs.
+//
+// Sampling is driven by the CPU clock, the profiler works on machines without
+// a PMU. Sampling is done at 99 Hz by default, this can be changed with the
+// WithSampleFrequency and WithSamplePeriod options.
+type Profiler struct {
+	options   eventOptions
+	perf      *perfSystemEvent
+	close     chan interface{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+
+	mu      sync.Mutex
+	profile Profile
+	// stacks indexes profile.Samples by stack key.
+	stacks map[string]int
+	key    []byte
+	// comms are the process names, indexed by PID.
+	comms map[int]string
+}
+
+// NewProfiler creates a Profiler.
+func NewProfiler(opts ...EventOption) *Profiler {
+	p := &Profiler{
+		options: *newEventOptions(opts),
+		close:   make(chan interface{}),
+	}
+	if p.options.samplePeriod == 0 {
+		p.options.samplePeriod = defaultProfileFrequency
+		p.options.sampleFreq = true
+	}
+	p.reset()
+	return p
+}
+
+// Open starts profiling.
+func (p *Profiler) Open() error {
+	var err error
+
+	config := perfEventConfig{
+		eventType: perfTypeSoftware,
+		config:    uint64(CPUClock),
+		sampleType: perfSampleIP | perfSampleTID | perfSampleTime | perfSampleCPU |
+			perfSampleCallchain,
+		samplePeriod: p.options.samplePeriod,
+		sampleFreq:   p.options.sampleFreq,
+
+		// The names of the processes created while profiling are
+		// followed with side-band records, they may have exited by the
+		// time their samples are read.
+		sideBand: SideBandComm | SideBandTask,
+
+		nCpus:        runtime.NumCPU(),
+		nPages:       8,
+		wakeupEvents: 1,
+	}
+
+	p.perf, err = newPerfSystemEvent(&config)
+	if err != nil {
+		return err
+	}
+
+	p.wg.Add(1)
+	go p.read()
+
+	return nil
+}
+
+// profilerRecord is a sample or a side-band record read from the ring
+// buffers, in the order they were emitted.
+type profilerRecord struct {
+	sample perfSampleRecord
+	misc   uint16
+	// event is the decoded side-band record, nil for samples.
+	event Event
+}
+
+func (p *Profiler) read() {
+	defer p.wg.Done()
+
+	var records []profilerRecord
+	var lost uint64
+
+	for {
+		select {
+		case <-p.close:
+			return
+		default:
+		}
+
+		nFds, err := p.perf.poll(pollTimeout)
+		if err == unix.EINTR {
+			continue
+		}
+		if err != nil {
+			return
+		}
+		if nFds == 0 {
+			continue
+		}
+
+		// Read the ring buffers without holding p.mu, the profile can be
+		// retrieved in the meantime.
+		records, lost = records[:0], 0
+		p.perf.read(func(msg *perfEventSample, cpu int) {
+			sample, err := parseSample(p.perf.sampleType, msg.record()[unsafe.Sizeof(msg.perfEventHeader):])
+			if err != nil {
+				return
+			}
+			records = append(records, profilerRecord{sample: sample, misc: msg.misc})
+		}, func(msg *perfEventLost, cpu int) {
+			lost += msg.lost
+		}, func(record []byte, cpu int) {
+			event, err := parseSideBand(0, p.perf.sampleType, record, cpu)
+			if err != nil || event == nil {
+				return
+			}
+			records = append(records, profilerRecord{event: event})
+		})
+
+		p.readComms(records)
+		p.addRecords(records, lost)
+	}
+}
+
+// addRecords adds the samples of records to the profile and follows the
+// process names of their side-band records.
+func (p *Profiler) addRecords(records []profilerRecord, lost uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for i := range records {
+		r := &records[i]
+		switch e := r.event.(type) {
+		case nil:
+			p.add(&r.sample, r.misc)
+		case *CommEvent:
+			// The process name is the name of its main thread.
+			if e.PID == e.TID {
+				p.comms[e.PID] = e.Comm
+			}
+		case *ForkEvent:
+			// New processes inherit the name of their parent.
+			if comm, ok := p.comms[e.PPID]; ok && !e.Thread() {
+				p.comms[e.PID] = comm
+			}
+		}
+	}
+	p.profile.Lost += lost
+}
+
+// readComms reads the names of the processes sampled in records the profiler
+// doesn't know about yet, from /proc. Processes created while profiling get
+// their names from the comm and fork side-band records instead.
+func (p *Profiler) readComms(records []profilerRecord) {
+	var pids []int
+
+	p.mu.Lock()
+	known := make(map[int]bool)
+	for i := range records {
+		r := &records[i]
+		switch e := r.event.(type) {
+		case nil:
+			pid := int(r.sample.pid)
+			if _, ok := p.comms[pid]; !ok && !known[pid] {
+				pids = append(pids, pid)
+			}
+			known[pid] = true
+		case *CommEvent:
+			known[e.PID] = known[e.PID] || e.PID == e.TID
+		case *ForkEvent:
+			if _, ok := p.comms[e.PPID]; (ok || known[e.PPID]) && !e.Thread() {
+				known[e.PID] = true
+			}
+		}
+	}
+	p.mu.Unlock()
+
+	if len(pids) == 0 {
+		return
+	}
+
+	comms := make([]string, len(pids))
+	for i, pid := range pids {
+		comms[i] = readComm(pid)
+	}
+
+	p.mu.Lock()
+	for i, pid := range pids {
+		if _, ok := p.comms[pid]; !ok {
+			p.comms[pid] = comms[i]
+		}
+	}
+	p.mu.Unlock()
+}
+
+// splitCallchain splits a perf callchain into its kernel and user frames. The
+// context markers, as well as the hypervisor and guest frames, are dropped.
+func splitCallchain(callchain []uint64) (kernel, user []uint64) {
+	var context uint64
+
+	for _, ip := range callchain {
+		if ip >= perfContextMax {
+			context = ip
+			continue
+		}
+		switch context {
+		case perfContextKernel:
+			kernel = append(kernel, ip)
+		case perfContextUser:
+			user = append(user, ip)
+		}
+	}
+
+	return
+}
+
+// add records a sample. misc is the misc field of the sample header.
+func (p *Profiler) add(sample *perfSampleRecord, misc uint16) {
+	kernel, user := splitCallchain(sample.callchain)
+
+	// Without callchain, the IP is the only frame we have.
+	if len(kernel) == 0 && len(user) == 0 {
+		switch misc & perfRecordMiscCPUModeMask {
+		case perfRecordMiscKernel:
+			kernel = []uint64{sample.ip}
+		case perfRecordMiscUser:
+			user = []uint64{sample.ip}
+		}
+	}
+
+	if p.profile.Start == 0 || sample.time < p.profile.Start {
+		p.profile.Start = sample.time
+	}
+	if sample.time > p.profile.End {
+		p.profile.End = sample.time
+	}
+
+	key := p.key[:0]
+	key = appendUint64(key, uint64(sample.pid)<<32|uint64(sample.tid))
+	key = appendUint64(key, uint64(len(kernel)))
+	for _, ip := range kernel {
+		key = appendUint64(key, ip)
+	}
+	for _, ip := range user {
+		key = appendUint64(key, ip)
+	}
+	p.key = key
+
+	if i, ok := p.stacks[string(key)]; ok {
+		p.profile.Samples[i].Count++
+		return
+	}
+
+	p.stacks[string(key)] = len(p.profile.Samples)
+	p.profile.Samples = append(p.profile.Samples, ProfileSample{
+		PID:    int(sample.pid),
+		TID:    int(sample.tid),
+		Comm:   p.comms[int(sample.pid)],
+		Kernel: kernel,
+		User:   user,
+		Count:  1,
+	})
+}
+
+// readComm returns the name of the process pid, "" if it has already exited.
+func readComm(pid int) string {
+	// The idle tasks don't have a /proc entry.
+	if pid == 0 {
+		return "swapper"
+	}
+	data, err := ioutil.ReadFile("/proc/" + strconv.Itoa(pid) + "/comm")
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSuffix(string(data), "\n")
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+func (p *Profiler) reset() {
+	p.profile = Profile{}
+	if p.options.sampleFreq {
+		p.profile.Frequency = p.options.samplePeriod
+	} else {
+		p.profile.Period = p.options.samplePeriod
+	}
+	p.stacks = make(map[string]int)
+	// PIDs are reused, don't keep names from one profile to the next.
+	p.comms = make(map[int]string)
+}
+
+// snapshot returns a copy of the profile. p.mu must be held.
+func (p *Profiler) snapshot() *Profile {
+	profile := p.profile
+	profile.Samples = make([]ProfileSample, len(p.profile.Samples))
+	copy(profile.Samples, p.profile.Samples)
+	sort.SliceStable(profile.Samples, func(i, j int) bool {
+		return profile.Samples[i].Count > profile.Samples[j].Count
+	})
+	return &profile
+}
+
+// Profile returns the stacks sampled since Open or the last Flush.
+func (p *Profiler) Profile() *Profile {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.snapshot()
+}
+
+// Flush returns the stacks sampled since Open or the last Flush and starts a
+// new profile. This is useful to profile continuously, one time window after
+// the other.
+func (p *Profiler) Flush() *Profile {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	profile := p.snapshot()
+	p.reset()
+	return profile
+}
+
+// Close stops profiling. The profile can still be retrieved after Close.
+func (p *Profiler) Close() {
+	p.closeOnce.Do(func() {
+		close(p.close)
+		p.wg.Wait()
+		if p.perf != nil {
+			p.perf.close()
+			p.perf = nil
+		}
+	})
+}
@@ -0,0 +1,139 @@
+package obs
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitCallchain(t *testing.T) {
+	tests := []struct {
+		callchain    []uint64
+		kernel, user []uint64
+	}{
+		{nil, nil, nil},
+		{
+			[]uint64{perfContextKernel, 0xffffffff81000010, 0xffffffff81000020, perfContextUser, 0x401000, 0x402000},
+			[]uint64{0xffffffff81000010, 0xffffffff81000020},
+			[]uint64{0x401000, 0x402000},
+		},
+		{
+			[]uint64{perfContextUser, 0x401000},
+			nil,
+			[]uint64{0x401000},
+		},
+		{
+			[]uint64{perfContextGuestKernel, 0xffffffff81000010, perfContextHV, 0x1000, perfContextUser, 0x401000},
+			nil,
+			[]uint64{0x401000},
+		},
+	}
+
+	for _, test := range tests {
+		kernel, user := splitCallchain(test.callchain)
+		assert.Equal(t, test.kernel, kernel)
+		assert.Equal(t, test.user, user)
+	}
+}
+
+func TestProfilerAggregation(t *testing.T) {
+	p := NewProfiler()
+
+	a := perfSampleRecord{pid: 1, tid: 1, time: 20, callchain: []uint64{perfContextUser, 0x401000, 0x402000}}
+	b := perfSampleRecord{pid: 1, tid: 1, time: 10, callchain: []uint64{perfContextKernel, 0xffffffff81000010, perfContextUser, 0x401000, 0x402000}}
+	c := perfSampleRecord{pid: 2, tid: 3, time: 30, ip: 0xffffffff81000010}
+	p.comms[1] = "foo"
+	p.comms[2] = "bar"
+
+	p.add(&a, perfRecordMiscUser)
+	p.add(&b, perfRecordMiscKernel)
+	p.add(&b, perfRecordMiscKernel)
+	p.add(&c, perfRecordMiscKernel)
+
+	profile := p.Profile()
+	assert.Equal(t, uint64(defaultProfileFrequency), profile.Frequency)
+	assert.Equal(t, uint64(10), profile.Start)
+	assert.Equal(t, uint64(30), profile.End)
+	assert.Equal(t, []ProfileSample{
+		{PID: 1, TID: 1, Comm: "foo", Kernel: []uint64{0xffffffff81000010}, User: []uint64{0x401000, 0x402000}, Count: 2},
+		{PID: 1, TID: 1, Comm: "foo", User: []uint64{0x401000, 0x402000}, Count: 1},
+		{PID: 2, TID: 3, Comm: "bar", Kernel: []uint64{0xffffffff81000010}, Count: 1},
+	}, profile.Samples)
+
+	profile = p.Flush()
+	assert.Len(t, profile.Samples, 3)
+	profile = p.Profile()
+	assert.Len(t, profile.Samples, 0)
+	assert.Equal(t, uint64(defaultProfileFrequency), profile.Frequency)
+}
+
+func TestProfilerComms(t *testing.T) {
+	p := NewProfiler()
+
+	// A process renamed by exec, which forks a child and a thread before
+	// exiting. The idle tasks are known as swapper.
+	records := []profilerRecord{
+		{event: &CommEvent{PID: testParentPID, TID: testParentPID, Comm: "foo", Exec: true}},
+		{sample: perfSampleRecord{pid: testParentPID, tid: testParentPID, ip: 1}, misc: perfRecordMiscUser},
+		{event: &ForkEvent{PID: testChildPID, TID: testChildPID, PPID: testParentPID, PTID: testParentPID}},
+		{event: &CommEvent{PID: testParentPID, TID: testParentPID + 2, Comm: "worker"}},
+		{sample: perfSampleRecord{pid: testChildPID, tid: testChildPID, ip: 2}, misc: perfRecordMiscUser},
+		{sample: perfSampleRecord{pid: testParentPID, tid: testParentPID + 2, ip: 3}, misc: perfRecordMiscUser},
+		{sample: perfSampleRecord{pid: 0, tid: 0, ip: 4}, misc: perfRecordMiscKernel},
+	}
+	p.readComms(records)
+	p.addRecords(records, 2)
+
+	profile := p.Profile()
+	comms := make(map[uint64]string)
+	for _, sample := range profile.Samples {
+		if len(sample.User) > 0 {
+			comms[sample.User[0]] = sample.Comm
+		} else {
+			comms[sample.Kernel[0]] = sample.Comm
+		}
+	}
+	assert.Equal(t, map[uint64]string{1: "foo", 2: "foo", 3: "foo", 4: "swapper"}, comms)
+	assert.Equal(t, uint64(2), profile.Lost)
+}
+
+func TestProfilerPeriod(t *testing.T) {
+	p := NewProfiler(WithSamplePeriod(1000000))
+	profile := p.Profile()
+	assert.Equal(t, uint64(0), profile.Frequency)
+	assert.Equal(t, uint64(1000000), profile.Period)
+}
+
+func TestProfiler(t *testing.T) {
+	p := NewProfiler(WithSampleFrequency(1000))
+	if err := p.Open(); err != nil {
+		t.Skipf("unable to open perf events: %v", err)
+	}
+
+	// Burn some CPU.
+	x := 0
+	for start := time.Now(); time.Since(start) < 200*time.Millisecond; {
+		x++
+	}
+	p.Close()
+
+	profile := p.Profile()
+	found := false
+	for _, sample := range profile.Samples {
+		if sample.PID == os.Getpid() && len(sample.User) > 0 {
+			found = true
+			assert.Equal(t, "obs.test", sample.Comm)
+		}
+	}
+	assert.True(t, found)
+	assert.True(t, profile.End >= profile.Start)
+}
+
+func TestProfilerClose(t *testing.T) {
+	p := NewProfiler()
+	p.Close()
+	// Closing a profiler twice is harmless.
+	p.Close()
+}
@@ -0,0 +1,104 @@
+package obs
+
+// These constants are the protocol buffer wire types.
+const (
+	protoWireVarint = 0
+	protoWireBytes  = 2
+)
+
+// protoBuffer encodes protocol buffer messages. It only knows about what's
+// needed to encode profile.proto. Like proto3, fields with a zero value are
+// omitted.
+type protoBuffer struct {
+	data []byte
+}
+
+func (b *protoBuffer) varint(x uint64) {
+	for x >= 0x80 {
+		b.data = append(b.data, byte(x)|0x80)
+		x >>= 7
+	}
+	b.data = append(b.data, byte(x))
+}
+
+func (b *protoBuffer) key(tag int, wire int) {
+	b.varint(uint64(tag)<<3 | uint64(wire))
+}
+
+func (b *protoBuffer) uint64(tag int, x uint64) {
+	if x == 0 {
+		return
+	}
+	b.key(tag, protoWireVarint)
+	b.varint(x)
+}
+
+func (b *protoBuffer) int64(tag int, x int64) {
+	b.uint64(tag, uint64(x))
+}
+
+// explicitInt64 encodes x even when it's 0, for the fields which presence
+// matters.
+func (b *protoBuffer) explicitInt64(tag int, x int64) {
+	b.key(tag, protoWireVarint)
+	b.varint(uint64(x))
+}
+
+func (b *protoBuffer) bool(tag int, x bool) {
+	if x {
+		b.uint64(tag, 1)
+	}
+}
+
+func (b *protoBuffer) string(tag int, s string) {
+	if s == "" {
+		return
+	}
+	b.key(tag, protoWireBytes)
+	b.varint(uint64(len(s)))
+	b.data = append(b.data, s...)
+}
+
+// repeatedString encodes an element of a repeated string field. Unlike
+// string, empty strings are encoded as the position of the elements matters.
+func (b *protoBuffer) repeatedString(tag int, s string) {
+	b.key(tag, protoWireBytes)
+	b.varint(uint64(len(s)))
+	b.data = append(b.data, s...)
+}
+
+func (b *protoBuffer) packedUint64(tag int, xs []uint64) {
+	if len(xs) == 0 {
+		return
+	}
+	b.message(tag, func() {
+		for _, x := range xs {
+			b.varint(x)
+		}
+	})
+}
+
+func (b *protoBuffer) packedInt64(tag int, xs []int64) {
+	if len(xs) == 0 {
+		return
+	}
+	b.message(tag, func() {
+		for _, x := range xs {
+			b.varint(uint64(x))
+		}
+	})
+}
+
+// message encodes the length delimited field tag, which content is written
+// by encode.
+func (b *protoBuffer) message(tag int, encode func()) {
+	b.key(tag, protoWireBytes)
+	start := len(b.data)
+	encode()
+
+	// Prefix the content with its length.
+	content := append([]byte(nil), b.data[start:]...)
+	b.data = b.data[:start]
+	b.varint(uint64(len(content)))
+	b.data = append(b.data, content...)
+}
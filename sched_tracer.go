@@ -0,0 +1,245 @@
+package obs
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	schedSwitch    = "sched:sched_switch"
+	schedWakeup    = "sched:sched_wakeup"
+	schedWakeupNew = "sched:sched_wakeup_new"
+
+	// schedReorderWindow is how long the scheduler events are held to be
+	// processed in order, see EventSorter.
+	schedReorderWindow = 100 * time.Millisecond
+
+	// taskStateMask selects the sleeping states in the prev_state field of
+	// sched_switch. The bits above are used to flag preemption.
+	taskStateMask = 0xff
+	// taskExitMask selects the states of exiting tasks, EXIT_DEAD and
+	// EXIT_ZOMBIE.
+	taskExitMask = 0x30
+)
+
+// SchedSwitch is a decoded sched:sched_switch event: the task PrevTID is
+// switched out of CPU and NextTID is switched in.
+type SchedSwitch struct {
+	// Time is the time of the switch, in nanoseconds of the perf clock.
+	Time uint64
+	CPU  int
+	// PrevTID, PrevComm and PrevState are the ID, name and state of the
+	// task switched out. PrevPID is the ID of its process.
+	PrevTID   int
+	PrevPID   int
+	PrevComm  string
+	PrevState int
+	// NextTID and NextComm are the ID and name of the task switched in.
+	NextTID  int
+	NextComm string
+	// Kernel and User are the stacks of the task switched out, leaf first,
+	// when the tracer records callchains.
+	Kernel, User []uint64
+}
+
+// PrevExiting returns true if the task switched out is exiting and won't be
+// switched in again.
+func (e *SchedSwitch) PrevExiting() bool {
+	return e.PrevState&taskExitMask != 0
+}
+
+// PrevRunnable returns true if the task switched out is still runnable, it
+// has been preempted rather than blocked.
+func (e *SchedSwitch) PrevRunnable() bool {
+	return e.PrevState&taskStateMask == 0
+}
+
+// SchedWakeup is a decoded sched:sched_wakeup or sched:sched_wakeup_new event:
+// the task TID becomes runnable.
+type SchedWakeup struct {
+	// Time is the time of the wakeup, in nanoseconds of the perf clock.
+	Time uint64
+	CPU  int
+	// WakerTID is the task running when the wakeup happened, the idle task
+	// (TID 0) for wakeups from interrupts on idle CPUs. WakerPID is the ID
+	// of its process.
+	WakerTID int
+	WakerPID int
+	TID      int
+	// New is true for the first wakeup of a newly created task.
+	New bool
+	// Kernel and User are the stacks of the waker, leaf first, when the
+	// tracer records callchains.
+	Kernel, User []uint64
+}
+
+// SchedTracer follows the sched:sched_switch and sched:sched_wakeup
+// tracepoints, and gives the decoded events to Switch and Wakeup in timestamp
+// order. Events are held for a small delay to be ordered, see EventSorter.
+//
+// Switch and Wakeup are called from a goroutine started by Open, with Locker
+// held if it isn't nil.
+type SchedTracer struct {
+	// Switch is called with the sched_switch events.
+	Switch func(e *SchedSwitch)
+	// Wakeup is called with the sched_wakeup events, and with the
+	// sched_wakeup_new events when WakeupNew is true.
+	Wakeup    func(e *SchedWakeup)
+	WakeupNew bool
+	// Callchain records the stacks of the tasks switched out and of the
+	// wakers.
+	Callchain bool
+	// Locker is held while Switch and Wakeup are called.
+	Locker sync.Locker
+
+	observer   *Observer
+	switches   EventSource
+	wakeups    EventSource
+	wakeupsNew EventSource
+	fields     struct {
+		prevComm, prevPID, prevState, nextComm, nextPID FieldHandle
+		wakeePID, newPID                                FieldHandle
+	}
+	sorter *EventSorter
+	wg     sync.WaitGroup
+}
+
+// Open starts following the scheduler tracepoints.
+func (t *SchedTracer) Open() error {
+	var opts []EventOption
+	if t.Callchain {
+		opts = append(opts, WithCallchain())
+	}
+
+	t.sorter = NewEventSorter(schedReorderWindow)
+	t.observer = NewObserver()
+	t.switches = t.observer.AddTracepoint(schedSwitch, opts...)
+	t.wakeups = t.observer.AddTracepoint(schedWakeup, opts...)
+	if t.WakeupNew {
+		t.wakeupsNew = t.observer.AddTracepoint(schedWakeupNew, opts...)
+	}
+	if err := t.observer.Open(); err != nil {
+		return err
+	}
+
+	var err error
+	for _, f := range []struct {
+		handle *FieldHandle
+		source EventSource
+		name   string
+	}{
+		{&t.fields.prevComm, t.switches, "prev_comm"},
+		{&t.fields.prevPID, t.switches, "prev_pid"},
+		{&t.fields.prevState, t.switches, "prev_state"},
+		{&t.fields.nextComm, t.switches, "next_comm"},
+		{&t.fields.nextPID, t.switches, "next_pid"},
+		{&t.fields.wakeePID, t.wakeups, "pid"},
+		{&t.fields.newPID, t.wakeupsNew, "pid"},
+	} {
+		if f.source == 0 {
+			// sched_wakeup_new isn't followed.
+			continue
+		}
+		if *f.handle, err = t.observer.Field(f.source, f.name); err != nil {
+			t.observer.Close()
+			return err
+		}
+	}
+
+	t.wg.Add(1)
+	go t.read()
+
+	return nil
+}
+
+func (t *SchedTracer) lock() {
+	if t.Locker != nil {
+		t.Locker.Lock()
+	}
+}
+
+func (t *SchedTracer) unlock() {
+	if t.Locker != nil {
+		t.Locker.Unlock()
+	}
+}
+
+func (t *SchedTracer) read() {
+	defer t.wg.Done()
+
+	for {
+		event, err := t.observer.ReadEvent()
+		if err != nil || event == nil {
+			// The observer has been closed.
+			return
+		}
+
+		t.lock()
+		t.sorter.Push(event)
+		for e := t.sorter.Pop(); e != nil; e = t.sorter.Pop() {
+			t.handle(e.(*TracepointEvent))
+		}
+		t.unlock()
+	}
+}
+
+// handle decodes a tracepoint event and hands it over.
+func (t *SchedTracer) handle(e *TracepointEvent) {
+	var kernel, user []uint64
+	if t.Callchain {
+		kernel, user = e.Callchain()
+	}
+
+	switch e.GetSource() {
+	case t.switches:
+		if t.Switch == nil {
+			return
+		}
+		t.Switch(&SchedSwitch{
+			Time:      e.GetTimestamp(),
+			CPU:       e.CPU(),
+			PrevTID:   e.IntAt(t.fields.prevPID),
+			PrevPID:   e.TGID(),
+			PrevComm:  e.StringAt(t.fields.prevComm),
+			PrevState: e.IntAt(t.fields.prevState),
+			NextTID:   e.IntAt(t.fields.nextPID),
+			NextComm:  e.StringAt(t.fields.nextComm),
+			Kernel:    kernel,
+			User:      user,
+		})
+	case t.wakeups, t.wakeupsNew:
+		if t.Wakeup == nil {
+			return
+		}
+		pid, isNew := t.fields.wakeePID, e.GetSource() == t.wakeupsNew
+		if isNew {
+			pid = t.fields.newPID
+		}
+		t.Wakeup(&SchedWakeup{
+			Time:     e.GetTimestamp(),
+			CPU:      e.CPU(),
+			WakerTID: e.CommonPID(),
+			WakerPID: e.TGID(),
+			TID:      e.IntAt(pid),
+			New:      isNew,
+			Kernel:   kernel,
+			User:     user,
+		})
+	}
+}
+
+// Close stops following the scheduler tracepoints. The events held to be
+// ordered are handed over before Close returns.
+func (t *SchedTracer) Close() {
+	if t.observer == nil {
+		return
+	}
+	t.observer.Close()
+	t.wg.Wait()
+
+	t.lock()
+	defer t.unlock()
+	for _, e := range t.sorter.Flush() {
+		t.handle(e.(*TracepointEvent))
+	}
+}
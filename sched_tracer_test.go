@@ -0,0 +1,80 @@
+package obs
+
+import (
+	"os"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+)
+
+func TestSchedSwitchState(t *testing.T) {
+	tests := []struct {
+		state             int
+		runnable, exiting bool
+	}{
+		{0x0, true, false},   // TASK_RUNNING
+		{0x100, true, false}, // TASK_REPORT_MAX, preempted
+		{0x1, false, false},  // TASK_INTERRUPTIBLE
+		{0x2, false, false},  // TASK_UNINTERRUPTIBLE
+		{0x10, false, true},  // EXIT_DEAD
+		{0x20, false, true},  // EXIT_ZOMBIE
+	}
+
+	for _, test := range tests {
+		e := SchedSwitch{PrevState: test.state}
+		assert.Equal(t, test.runnable, e.PrevRunnable(), "%#x", test.state)
+		assert.Equal(t, test.exiting, e.PrevExiting(), "%#x", test.state)
+	}
+}
+
+func TestSchedTracer(t *testing.T) {
+	var mu sync.Mutex
+	var last uint64
+	ordered := true
+	switchedOut := make(map[int]bool)
+	pids := make(map[int]int)
+	woken := make(map[int]bool)
+
+	tracer := &SchedTracer{
+		Switch: func(e *SchedSwitch) {
+			ordered = ordered && e.Time >= last
+			last = e.Time
+			switchedOut[e.PrevTID] = true
+			pids[e.PrevTID] = e.PrevPID
+		},
+		Wakeup: func(e *SchedWakeup) {
+			ordered = ordered && e.Time >= last
+			last = e.Time
+			woken[e.TID] = true
+		},
+		Locker: &mu,
+	}
+	if err := tracer.Open(); err != nil {
+		t.Skipf("unable to open the sched tracepoints: %v", err)
+	}
+
+	tid := make(chan int)
+	go func() {
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+
+		unix.Nanosleep(&unix.Timespec{Nsec: int64(10 * time.Millisecond)}, nil)
+		tid <- unix.Gettid()
+	}()
+	sleeper := <-tid
+
+	// Let the tracer catch up with the last events.
+	time.Sleep(2 * schedReorderWindow)
+	tracer.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.True(t, ordered)
+	assert.True(t, switchedOut[sleeper])
+	assert.Equal(t, os.Getpid(), pids[sleeper])
+	assert.True(t, woken[sleeper])
+}
@@ -0,0 +1,329 @@
+// Package schedlat measures the scheduler run-queue latency: the time tasks
+// spend runnable, waiting for a CPU, before running.
+//
+// The latency of a task is measured from the moment it's woken up, or
+// preempted while still runnable, until it's switched in. Latencies are
+// recorded in log2 histograms, for the whole system, per CPU and per cgroup.
+package schedlat
+
+import (
+	"sync"
+	"time"
+
+	"github.com/dlespiau/obs"
+)
+
+// Option configures a Collector.
+type Option func(*Collector)
+
+// WithInterval makes the collector send a snapshot every interval on the
+// Snapshots channel, starting a new measurement window each time. When the
+// previous snapshot hasn't been received yet, the window is extended to the
+// next interval.
+func WithInterval(interval time.Duration) Option {
+	return func(c *Collector) {
+		c.interval = interval
+	}
+}
+
+// WithoutCgroups disables the per-cgroup histograms. Finding the cgroup of a
+// task involves reading its /proc/pid/cgroup file the first time it's seen.
+func WithoutCgroups() Option {
+	return func(c *Collector) {
+		c.cgroupOf = nil
+	}
+}
+
+// Snapshot is the run-queue latency measured over a time window. Histograms
+// are in nanoseconds.
+type Snapshot struct {
+	// Start and End are the times of the first and last events measured,
+	// in nanoseconds of the perf clock.
+	Start, End uint64
+	// Time is the wall clock time the snapshot was taken at.
+	Time time.Time
+	// All is the latency of all the tasks.
+	All obs.Histogram
+	// CPUs is the latency per CPU, the CPU the tasks have been switched in
+	// on.
+	CPUs map[int]*obs.Histogram
+	// Cgroups is the latency per cgroup, indexed by cgroup path. The path is
+	// the cgroup v2 path, or the path in the cpu controller hierarchy on
+	// cgroup v1 systems. Cgroups is nil for collectors created with
+	// WithoutCgroups.
+	Cgroups map[string]*obs.Histogram
+}
+
+// Summary summarizes a latency histogram.
+type Summary struct {
+	Count              uint64
+	Mean               time.Duration
+	P50, P90, P99, Max time.Duration
+}
+
+// Summarize returns the summary of the latency histogram h.
+func Summarize(h *obs.Histogram) Summary {
+	return Summary{
+		Count: h.Count(),
+		Mean:  time.Duration(h.Mean()),
+		P50:   time.Duration(h.Percentile(50)),
+		P90:   time.Duration(h.Percentile(90)),
+		P99:   time.Duration(h.Percentile(99)),
+		Max:   time.Duration(h.Max()),
+	}
+}
+
+// Collector measures the run-queue latency.
+type Collector struct {
+	interval time.Duration
+	// cgroupOf returns the cgroup of a task, nil when the per-cgroup
+	// latencies aren't measured.
+	cgroupOf func(tid int) string
+
+	tracer    *obs.SchedTracer
+	snapshots chan *Snapshot
+	close     chan interface{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+
+	mu sync.Mutex
+	// runnable are the times the tasks have become runnable at, indexed by
+	// TID.
+	runnable map[int]uint64
+	// cgroups caches the cgroups of the tasks, indexed by TID.
+	cgroups  map[int]string
+	snapshot Snapshot
+}
+
+// NewCollector creates a Collector.
+func NewCollector(opts ...Option) *Collector {
+	c := &Collector{
+		cgroupOf:  readCgroup,
+		snapshots: make(chan *Snapshot, 1),
+		close:     make(chan interface{}),
+		runnable:  make(map[int]uint64),
+		cgroups:   make(map[int]string),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.reset()
+	return c
+}
+
+// Open starts measuring.
+func (c *Collector) Open() error {
+	c.tracer = &obs.SchedTracer{
+		Switch:    c.sched,
+		Wakeup:    c.wakeup,
+		WakeupNew: true,
+		Locker:    &c.mu,
+	}
+	if err := c.tracer.Open(); err != nil {
+		return err
+	}
+
+	if c.interval != 0 {
+		c.wg.Add(1)
+		go c.tick()
+	}
+
+	return nil
+}
+
+// tick sends a snapshot every interval.
+func (c *Collector) tick() {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.close:
+			return
+		case <-ticker.C:
+		}
+
+		c.send()
+	}
+}
+
+// send sends a snapshot on the Snapshots channel. When the previous snapshot
+// hasn't been received yet, nothing is sent and the measurement window goes on
+// until the next tick. tick is the only sender, the send can't block.
+func (c *Collector) send() {
+	if len(c.snapshots) == cap(c.snapshots) {
+		return
+	}
+	c.snapshots <- c.Flush()
+}
+
+// wakeup records that a task has become runnable. c.mu must be held.
+func (c *Collector) wakeup(e *obs.SchedWakeup) {
+	if _, ok := c.runnable[e.TID]; !ok {
+		c.runnable[e.TID] = e.Time
+	}
+}
+
+// sched measures a context switch. c.mu must be held.
+func (c *Collector) sched(e *obs.SchedSwitch) {
+	if e.PrevTID != 0 {
+		switch {
+		case e.PrevExiting():
+			delete(c.runnable, e.PrevTID)
+			delete(c.cgroups, e.PrevTID)
+		case e.PrevRunnable():
+			// Preempted tasks go back to the run queue.
+			c.runnable[e.PrevTID] = e.Time
+		default:
+			// The task is blocked, forget about any wakeup seen while
+			// it was running.
+			delete(c.runnable, e.PrevTID)
+		}
+	}
+
+	since, ok := c.runnable[e.NextTID]
+	if !ok {
+		return
+	}
+	delete(c.runnable, e.NextTID)
+	if e.NextTID == 0 || e.Time < since {
+		return
+	}
+	c.add(e, e.Time-since)
+}
+
+// add records the latency of the task switched in by e.
+func (c *Collector) add(e *obs.SchedSwitch, latency uint64) {
+	s := &c.snapshot
+	if s.Start == 0 || e.Time < s.Start {
+		s.Start = e.Time
+	}
+	if e.Time > s.End {
+		s.End = e.Time
+	}
+
+	s.All.Add(latency)
+	histogramOf(s.CPUs, e.CPU).Add(latency)
+
+	if c.cgroupOf == nil {
+		return
+	}
+	cgroup, ok := c.cgroups[e.NextTID]
+	if !ok {
+		cgroup = c.cgroupOf(e.NextTID)
+		c.cgroups[e.NextTID] = cgroup
+	}
+	h, ok := s.Cgroups[cgroup]
+	if !ok {
+		h = &obs.Histogram{}
+		s.Cgroups[cgroup] = h
+	}
+	h.Add(latency)
+}
+
+func histogramOf(m map[int]*obs.Histogram, key int) *obs.Histogram {
+	h, ok := m[key]
+	if !ok {
+		h = &obs.Histogram{}
+		m[key] = h
+	}
+	return h
+}
+
+func (c *Collector) reset() {
+	c.snapshot = Snapshot{
+		CPUs: make(map[int]*obs.Histogram),
+	}
+	if c.cgroupOf != nil {
+		c.snapshot.Cgroups = make(map[string]*obs.Histogram)
+	}
+}
+
+// copySnapshot returns a deep copy of the current snapshot. c.mu must be
+// held.
+func (c *Collector) copySnapshot() *Snapshot {
+	s := c.snapshot
+	s.Time = time.Now()
+	s.CPUs = make(map[int]*obs.Histogram, len(c.snapshot.CPUs))
+	for cpu, h := range c.snapshot.CPUs {
+		copied := *h
+		s.CPUs[cpu] = &copied
+	}
+	if c.snapshot.Cgroups != nil {
+		s.Cgroups = make(map[string]*obs.Histogram, len(c.snapshot.Cgroups))
+		for cgroup, h := range c.snapshot.Cgroups {
+			copied := *h
+			s.Cgroups[cgroup] = &copied
+		}
+	}
+	return &s
+}
+
+// Snapshot returns the latencies measured since Open or the last Flush.
+// Events are measured with a small delay, to process them in order, the
+// snapshot doesn't include the most recent events.
+func (c *Collector) Snapshot() *Snapshot {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.copySnapshot()
+}
+
+// Flush returns the latencies measured since Open or the last Flush and
+// starts a new measurement window.
+func (c *Collector) Flush() *Snapshot {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s := c.copySnapshot()
+	c.reset()
+	return s
+}
+
+// Snapshots returns the channel the snapshots of collectors created with
+// WithInterval are sent on.
+func (c *Collector) Snapshots() <-chan *Snapshot {
+	return c.snapshots
+}
+
+// Close stops measuring. The pending events are measured, the latencies can
+// still be retrieved after Close.
+func (c *Collector) Close() {
+	c.closeOnce.Do(func() {
+		close(c.close)
+		c.wg.Wait()
+		if c.tracer != nil {
+			c.tracer.Close()
+		}
+	})
+}
+
+// readCgroup returns the cgroup of the task tid, "" if it can't be read.
+func readCgroup(tid int) string {
+	cgroups, err := obs.NewProcess(tid).Cgroups()
+	if err != nil {
+		return ""
+	}
+	return cgroupPath(cgroups)
+}
+
+// cgroupPath returns the path in the cpu controller hierarchy or, on cgroup v2
+// only systems, the path in the unified hierarchy.
+func cgroupPath(cgroups []obs.Cgroup) string {
+	var unified string
+	for i := range cgroups {
+		cgroup := &cgroups[i]
+		if cgroup.IsUnified() {
+			unified = cgroup.Path
+			continue
+		}
+		for _, controller := range cgroup.Controllers {
+			if controller == "cpu" {
+				return cgroup.Path
+			}
+		}
+	}
+	return unified
+}
@@ -0,0 +1,167 @@
+package schedlat
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dlespiau/obs"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	taskRunning       = 0
+	taskInterruptible = 1
+	taskPreempted     = 0x100
+	exitDead          = 0x10
+)
+
+func TestCollector(t *testing.T) {
+	c := NewCollector()
+	c.cgroupOf = func(tid int) string {
+		if tid == 10 {
+			return "/foo"
+		}
+		return "/bar"
+	}
+
+	// 10 is woken up and runs 100ns later on CPU 0.
+	c.wakeup(&obs.SchedWakeup{Time: 1000, TID: 10})
+	c.sched(&obs.SchedSwitch{Time: 1100, CPU: 0, PrevTID: 0, NextTID: 10})
+	// 20 is woken up, then woken up again. It runs 300ns after the first
+	// wakeup, on CPU 1.
+	c.wakeup(&obs.SchedWakeup{Time: 1000, TID: 20})
+	c.wakeup(&obs.SchedWakeup{Time: 1200, TID: 20})
+	c.sched(&obs.SchedSwitch{Time: 1300, CPU: 1, PrevTID: 0, NextTID: 20})
+	// 10 is preempted by 30, which wasn't seen being woken up, and runs
+	// again 1000ns later.
+	c.sched(&obs.SchedSwitch{Time: 2000, CPU: 0, PrevTID: 10, PrevState: taskPreempted, NextTID: 30})
+	c.sched(&obs.SchedSwitch{Time: 3000, CPU: 0, PrevTID: 30, PrevState: taskInterruptible, NextTID: 10})
+	// 20 exits.
+	c.sched(&obs.SchedSwitch{Time: 3000, CPU: 1, PrevTID: 20, PrevState: exitDead, NextTID: 0})
+
+	s := c.Snapshot()
+	assert.Equal(t, uint64(1100), s.Start)
+	assert.Equal(t, uint64(3000), s.End)
+	assert.Equal(t, uint64(3), s.All.Count())
+	assert.Equal(t, uint64(1400), s.All.Sum())
+	assert.Equal(t, uint64(2), s.CPUs[0].Count())
+	assert.Equal(t, uint64(1100), s.CPUs[0].Sum())
+	assert.Equal(t, uint64(1), s.CPUs[1].Count())
+	assert.Equal(t, uint64(2), s.Cgroups["/foo"].Count())
+	assert.Equal(t, uint64(300), s.Cgroups["/bar"].Sum())
+	assert.Len(t, c.runnable, 0)
+	assert.Len(t, c.cgroups, 1)
+
+	summary := Summarize(&s.All)
+	assert.Equal(t, uint64(3), summary.Count)
+	assert.Equal(t, 1000*time.Nanosecond, summary.Max)
+	assert.True(t, summary.P50 <= summary.P90 && summary.P90 <= summary.P99)
+
+	// Snapshots are copies.
+	c.wakeup(&obs.SchedWakeup{Time: 4000, TID: 10})
+	c.sched(&obs.SchedSwitch{Time: 4100, CPU: 0, NextTID: 10})
+	assert.Equal(t, uint64(2), s.CPUs[0].Count())
+
+	s = c.Flush()
+	assert.Equal(t, uint64(4), s.All.Count())
+	s = c.Snapshot()
+	assert.Equal(t, uint64(0), s.All.Count())
+	assert.Len(t, s.CPUs, 0)
+}
+
+func TestCollectorBlocked(t *testing.T) {
+	c := NewCollector(WithoutCgroups())
+
+	// A wakeup seen while the task is running doesn't count once the task
+	// has blocked.
+	c.wakeup(&obs.SchedWakeup{Time: 1000, TID: 10})
+	c.sched(&obs.SchedSwitch{Time: 2000, PrevTID: 10, PrevState: taskInterruptible, NextTID: 0})
+	c.sched(&obs.SchedSwitch{Time: 3000, PrevTID: 0, NextTID: 10})
+
+	s := c.Snapshot()
+	assert.Equal(t, uint64(0), s.All.Count())
+	assert.Nil(t, s.Cgroups)
+}
+
+func TestCollectorSend(t *testing.T) {
+	c := NewCollector(WithoutCgroups())
+
+	c.wakeup(&obs.SchedWakeup{Time: 1000, TID: 10})
+	c.sched(&obs.SchedSwitch{Time: 1100, NextTID: 10})
+	c.send()
+
+	// The first snapshot hasn't been received, the next window is extended
+	// instead of being lost.
+	c.wakeup(&obs.SchedWakeup{Time: 2000, TID: 10})
+	c.sched(&obs.SchedSwitch{Time: 2200, NextTID: 10})
+	c.send()
+	c.wakeup(&obs.SchedWakeup{Time: 3000, TID: 10})
+	c.sched(&obs.SchedSwitch{Time: 3300, NextTID: 10})
+
+	s := <-c.Snapshots()
+	assert.Equal(t, uint64(1), s.All.Count())
+	assert.Equal(t, uint64(100), s.All.Sum())
+
+	c.send()
+	s = <-c.Snapshots()
+	assert.Equal(t, uint64(2), s.All.Count())
+	assert.Equal(t, uint64(500), s.All.Sum())
+	assert.Equal(t, uint64(2200), s.Start)
+	assert.Equal(t, uint64(3300), s.End)
+}
+
+func TestCgroupPath(t *testing.T) {
+	tests := []struct {
+		cgroups  []obs.Cgroup
+		expected string
+	}{
+		{[]obs.Cgroup{{Path: "/system.slice/docker.service"}}, "/system.slice/docker.service"},
+		{[]obs.Cgroup{
+			{HierarchyID: 12, Controllers: []string{"cpu", "cpuacct"}, Path: "/docker/1234"},
+			{HierarchyID: 11, Controllers: []string{"memory"}, Path: "/docker/5678"},
+			{Path: "/"},
+		}, "/docker/1234"},
+		{[]obs.Cgroup{
+			{HierarchyID: 3, Controllers: []string{"memory"}, Path: "/foo"},
+			{Path: "/bar"},
+		}, "/bar"},
+		{nil, ""},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, cgroupPath(test.cgroups))
+	}
+}
+
+func TestCollectorLive(t *testing.T) {
+	c := NewCollector(WithInterval(50 * time.Millisecond))
+	if err := c.Open(); err != nil {
+		t.Skipf("unable to open the sched tracepoints: %v", err)
+	}
+	defer c.Close()
+
+	// Sleeping threads are woken up and switched in.
+	deadline := time.After(5 * time.Second)
+	for {
+		time.Sleep(time.Millisecond)
+		select {
+		case s := <-c.Snapshots():
+			if s.All.Count() == 0 {
+				continue
+			}
+			assert.True(t, len(s.CPUs) > 0)
+			assert.True(t, len(s.Cgroups) > 0)
+			return
+		case <-deadline:
+			t.Fatal("no latency measured")
+		default:
+		}
+	}
+}
+
+func TestCollectorClose(t *testing.T) {
+	c := NewCollector(WithInterval(time.Millisecond))
+	c.Close()
+	// Closing a collector twice is harmless.
+	c.Close()
+}
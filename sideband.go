@@ -0,0 +1,278 @@
+package obs
+
+import (
+	"fmt"
+	"unsafe"
+)
+
+// These constants are linux ABI, defined as PERF_RECORD_* in
+// <linux/perf_event.h>.
+const (
+	perfRecordMmap          = 1
+	perfRecordLost          = 2
+	perfRecordComm          = 3
+	perfRecordExit          = 4
+	perfRecordFork          = 7
+	perfRecordSample        = 9
+	perfRecordMmap2         = 10
+	perfRecordSwitch        = 14
+	perfRecordSwitchCPUWide = 15
+)
+
+// These constants are linux ABI, defined as PERF_RECORD_MISC_* in
+// <linux/perf_event.h>. The same bits have different meanings depending on
+// the record kind.
+const (
+	perfRecordMiscCommExec         = 1 << 13
+	perfRecordMiscSwitchOut        = 1 << 13
+	perfRecordMiscSwitchOutPreempt = 1 << 14
+	perfRecordMiscMmapBuildID      = 1 << 14
+)
+
+// perfMmapBuildIDSize is the maximum size of the build IDs reported by
+// PERF_RECORD_MMAP2.
+const perfMmapBuildIDSize = 20
+
+// MmapEvent is fired when a process maps a file, or anonymous memory, with
+// execute permissions. It is emitted by sources opened with SideBandMmap.
+type MmapEvent struct {
+	baseEvent
+	// PID and TID identify the thread that created the mapping.
+	PID, TID int
+	// Addr and Len are the address and length of the mapping.
+	Addr, Len uint64
+	// PgOff is the offset of the mapping in the mapped file.
+	PgOff uint64
+	// Major and Minor are the device numbers of the mapped file. They are
+	// 0 when the kernel has reported BuildID instead.
+	Major, Minor uint32
+	// Inode and InodeGeneration identify the mapped file.
+	Inode, InodeGeneration uint64
+	// BuildID is the build ID of the mapped file, when the kernel reports
+	// it instead of the inode.
+	BuildID []byte
+	// Prot and Flags are the protection and flags given to mmap(2).
+	Prot, Flags uint32
+	// Filename is the path of the mapped file, or a pseudo-path such as
+	// "//anon" or "[vdso]".
+	Filename string
+	// CPU is the CPU the event happened on.
+	CPU int
+}
+
+// CommEvent is fired when a thread changes its name, either explicitly or
+// when executing a new program. It is emitted by sources opened with
+// SideBandComm.
+type CommEvent struct {
+	baseEvent
+	// PID and TID identify the thread that has been renamed.
+	PID, TID int
+	// Comm is the new name of the thread.
+	Comm string
+	// Exec is true when the thread has been renamed by an exec.
+	Exec bool
+	// CPU is the CPU the event happened on.
+	CPU int
+}
+
+// ForkEvent is fired when a task is created. It is emitted by sources opened
+// with SideBandTask.
+type ForkEvent struct {
+	baseEvent
+	// PID and TID identify the new task.
+	PID, TID int
+	// PPID and PTID identify the task that created it.
+	PPID, PTID int
+	// CPU is the CPU the event happened on.
+	CPU int
+}
+
+// Thread returns true if the new task is a thread of an existing process,
+// false if it's a new process.
+func (e *ForkEvent) Thread() bool {
+	return e.PID != e.TID
+}
+
+// ExitEvent is fired when a task exits. It is emitted by sources opened with
+// SideBandTask.
+type ExitEvent struct {
+	baseEvent
+	// PID and TID identify the task that exited.
+	PID, TID int
+	// PPID and PTID identify the parent of the task.
+	PPID, PTID int
+	// CPU is the CPU the event happened on.
+	CPU int
+}
+
+// Thread returns true if the task that exited is a thread of a process, false
+// if it's the process itself, its main thread.
+func (e *ExitEvent) Thread() bool {
+	return e.PID != e.TID
+}
+
+// SwitchEvent is fired when a CPU switches from a task to another. Every
+// context switch is seen twice, once when the previous task is switched out
+// and once when the next one is switched in. It is emitted by sources opened
+// with SideBandSwitch.
+type SwitchEvent struct {
+	baseEvent
+	// Out is true when the task is switched out, false when switched in.
+	Out bool
+	// Preempt is true when the task was switched out while still runnable.
+	Preempt bool
+	// PID and TID identify the task switched in or out.
+	PID, TID int
+	// NextPrevPID and NextPrevTID identify the next task when switching
+	// out, the previous task when switching in.
+	NextPrevPID, NextPrevTID int
+	// CPU is the CPU the event happened on.
+	CPU int
+}
+
+// sampleIDSize returns the size of the sample_id trailer of side-band
+// records for sampleType.
+func sampleIDSize(sampleType perfSample) int {
+	size := 0
+	for _, t := range []perfSample{perfSampleTID, perfSampleTime, perfSampleID,
+		perfSampleStreamID, perfSampleCPU, perfSampleIdentifier} {
+		if sampleType&t != 0 {
+			size += 8
+		}
+	}
+	return size
+}
+
+// parseSampleID decodes the sample_id trailer of a side-band record, laid out
+// for sampleType.
+func parseSampleID(sampleType perfSample, data []byte) (perfSampleRecord, error) {
+	var s perfSampleRecord
+	r := sampleReader{data: data}
+
+	if sampleType&perfSampleTID != 0 {
+		s.pid = r.u32()
+		s.tid = r.u32()
+	}
+	if sampleType&perfSampleTime != 0 {
+		s.time = r.u64()
+	}
+	if sampleType&perfSampleID != 0 {
+		s.id = r.u64()
+	}
+	if sampleType&perfSampleStreamID != 0 {
+		s.streamID = r.u64()
+	}
+	if sampleType&perfSampleCPU != 0 {
+		s.cpu = r.u32()
+		r.u32() // reserved
+	}
+	if sampleType&perfSampleIdentifier != 0 {
+		s.id = r.u64()
+	}
+
+	return s, r.err
+}
+
+// parseSideBand decodes a side-band record, header included, into an event.
+// sampleType is the sample type of the event that emitted the record and
+// cpu is the CPU of its ring buffer. parseSideBand returns a nil event for
+// the record kinds it doesn't know about.
+func parseSideBand(source EventSource, sampleType perfSample, record []byte, cpu int) (Event, error) {
+	headerSize := int(unsafe.Sizeof(perfEventHeader{}))
+	idSize := sampleIDSize(sampleType)
+	if len(record) < headerSize+idSize {
+		return nil, errShortSample
+	}
+
+	kind := NativeEndian.Uint32(record)
+	misc := NativeEndian.Uint16(record[4:])
+	body := record[headerSize : len(record)-idSize]
+	id, err := parseSampleID(sampleType, record[len(record)-idSize:])
+	if err != nil {
+		return nil, err
+	}
+	if sampleType&perfSampleCPU != 0 {
+		cpu = int(id.cpu)
+	}
+	base := baseEvent{
+		source:    source,
+		timestamp: id.time,
+	}
+	r := sampleReader{data: body}
+
+	switch kind {
+	case perfRecordMmap, perfRecordMmap2:
+		e := &MmapEvent{baseEvent: base, CPU: cpu}
+		e.PID = int(r.u32())
+		e.TID = int(r.u32())
+		e.Addr = r.u64()
+		e.Len = r.u64()
+		e.PgOff = r.u64()
+		if kind == perfRecordMmap2 {
+			if misc&perfRecordMiscMmapBuildID != 0 {
+				buildID := r.bytes(24)
+				if r.err == nil {
+					size := int(buildID[0])
+					if size > perfMmapBuildIDSize {
+						return nil, fmt.Errorf("perf: invalid build id size %d", size)
+					}
+					e.BuildID = append([]byte(nil), buildID[4:4+size]...)
+				}
+			} else {
+				e.Major = r.u32()
+				e.Minor = r.u32()
+				e.Inode = r.u64()
+				e.InodeGeneration = r.u64()
+			}
+			e.Prot = r.u32()
+			e.Flags = r.u32()
+		}
+		if r.err != nil {
+			return nil, r.err
+		}
+		e.Filename = cString(r.data)
+		return e, nil
+
+	case perfRecordComm:
+		e := &CommEvent{baseEvent: base, CPU: cpu}
+		e.PID = int(r.u32())
+		e.TID = int(r.u32())
+		if r.err != nil {
+			return nil, r.err
+		}
+		e.Comm = cString(r.data)
+		e.Exec = misc&perfRecordMiscCommExec != 0
+		return e, nil
+
+	case perfRecordFork, perfRecordExit:
+		pid := int(r.u32())
+		ppid := int(r.u32())
+		tid := int(r.u32())
+		ptid := int(r.u32())
+		base.timestamp = r.u64()
+		if r.err != nil {
+			return nil, r.err
+		}
+		if kind == perfRecordFork {
+			return &ForkEvent{baseEvent: base, PID: pid, TID: tid, PPID: ppid, PTID: ptid, CPU: cpu}, nil
+		}
+		return &ExitEvent{baseEvent: base, PID: pid, TID: tid, PPID: ppid, PTID: ptid, CPU: cpu}, nil
+
+	case perfRecordSwitch, perfRecordSwitchCPUWide:
+		e := &SwitchEvent{
+			baseEvent: base,
+			Out:       misc&perfRecordMiscSwitchOut != 0,
+			Preempt:   misc&perfRecordMiscSwitchOutPreempt != 0,
+			PID:       int(id.pid),
+			TID:       int(id.tid),
+			CPU:       cpu,
+		}
+		if kind == perfRecordSwitchCPUWide {
+			e.NextPrevPID = int(r.u32())
+			e.NextPrevTID = int(r.u32())
+		}
+		return e, r.err
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,148 @@
+package obs
+
+import (
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSideBand(t *testing.T) {
+	const sampleType = perfSampleTID | perfSampleTime | perfSampleCPU
+
+	header := func(b *sampleBuilder, kind uint32, misc uint16) *sampleBuilder {
+		return b.u32(kind).u32(uint32(misc))
+	}
+	// The total size of the records isn't looked at by parseSideBand.
+	sampleID := func(b *sampleBuilder) *sampleBuilder {
+		return b.u32(42).u32(43).u64(1000).u32(3).u32(0)
+	}
+
+	var mmap2, mmap2BuildID, comm, fork, exit, switchOut, switchIn sampleBuilder
+	sampleID(header(&mmap2, perfRecordMmap2, perfRecordMiscUser).
+		u32(42).u32(43).u64(0x400000).u64(0x1000).u64(0x2000).
+		u32(8).u32(2).u64(1234).u64(1).u32(5).u32(2).
+		bytes([]byte("/bin/true\x00\x00\x00\x00\x00\x00\x00")))
+	sampleID(header(&mmap2BuildID, perfRecordMmap2, perfRecordMiscMmapBuildID).
+		u32(42).u32(43).u64(0x400000).u64(0x1000).u64(0).
+		bytes([]byte{4, 0, 0, 0, 0xde, 0xad, 0xbe, 0xef}).bytes(make([]byte, 16)).
+		u32(5).u32(2).bytes([]byte("/bin/true\x00\x00\x00\x00\x00\x00\x00")))
+	sampleID(header(&comm, perfRecordComm, perfRecordMiscCommExec).
+		u32(42).u32(43).bytes([]byte("true\x00\x00\x00\x00")))
+	sampleID(header(&fork, perfRecordFork, 0).
+		u32(44).u32(42).u32(44).u32(43).u64(2000))
+	sampleID(header(&exit, perfRecordExit, 0).
+		u32(44).u32(42).u32(44).u32(43).u64(3000))
+	sampleID(header(&switchOut, perfRecordSwitchCPUWide, perfRecordMiscSwitchOut|perfRecordMiscSwitchOutPreempt).
+		u32(0).u32(0))
+	sampleID(header(&switchIn, perfRecordSwitch, 0))
+
+	tests := []struct {
+		record   []byte
+		expected Event
+	}{
+		{mmap2, &MmapEvent{
+			baseEvent: baseEvent{source: 1, timestamp: 1000},
+			PID:       42, TID: 43, Addr: 0x400000, Len: 0x1000, PgOff: 0x2000,
+			Major: 8, Minor: 2, Inode: 1234, InodeGeneration: 1, Prot: 5, Flags: 2,
+			Filename: "/bin/true", CPU: 3,
+		}},
+		{mmap2BuildID, &MmapEvent{
+			baseEvent: baseEvent{source: 1, timestamp: 1000},
+			PID:       42, TID: 43, Addr: 0x400000, Len: 0x1000,
+			BuildID: []byte{0xde, 0xad, 0xbe, 0xef}, Prot: 5, Flags: 2,
+			Filename: "/bin/true", CPU: 3,
+		}},
+		{comm, &CommEvent{
+			baseEvent: baseEvent{source: 1, timestamp: 1000},
+			PID:       42, TID: 43, Comm: "true", Exec: true, CPU: 3,
+		}},
+		{fork, &ForkEvent{
+			baseEvent: baseEvent{source: 1, timestamp: 2000},
+			PID:       44, PPID: 42, TID: 44, PTID: 43, CPU: 3,
+		}},
+		{exit, &ExitEvent{
+			baseEvent: baseEvent{source: 1, timestamp: 3000},
+			PID:       44, PPID: 42, TID: 44, PTID: 43, CPU: 3,
+		}},
+		{switchOut, &SwitchEvent{
+			baseEvent: baseEvent{source: 1, timestamp: 1000},
+			Out:       true, Preempt: true, PID: 42, TID: 43, CPU: 3,
+		}},
+		{switchIn, &SwitchEvent{
+			baseEvent: baseEvent{source: 1, timestamp: 1000},
+			PID:       42, TID: 43, CPU: 3,
+		}},
+	}
+
+	for _, test := range tests {
+		event, err := parseSideBand(1, sampleType, test.record, 0)
+		assert.Nil(t, err)
+		assert.Equal(t, test.expected, event)
+	}
+}
+
+func TestParseSideBandInvalid(t *testing.T) {
+	var unknown, short sampleBuilder
+	unknown.u32(42).u32(0)
+	short.u32(perfRecordComm).u32(0).u32(42)
+
+	event, err := parseSideBand(1, 0, unknown, 0)
+	assert.Nil(t, err)
+	assert.Nil(t, event)
+
+	_, err = parseSideBand(1, 0, short, 0)
+	assert.NotNil(t, err)
+
+	_, err = parseSideBand(1, perfSampleTime, unknown, 0)
+	assert.NotNil(t, err)
+}
+
+func TestSideBandThread(t *testing.T) {
+	assert.False(t, (&ForkEvent{PID: 44, TID: 44, PPID: 42, PTID: 43}).Thread())
+	assert.True(t, (&ForkEvent{PID: 42, TID: 45, PPID: 42, PTID: 43}).Thread())
+	assert.False(t, (&ExitEvent{PID: 44, TID: 44}).Thread())
+	assert.True(t, (&ExitEvent{PID: 42, TID: 45}).Thread())
+}
+
+func TestSideBandTask(t *testing.T) {
+	o := NewObserver()
+	source := o.AddSoftwareEvent(Dummy, WithSideBand(SideBandComm|SideBandTask))
+	openObserver(t, o)
+	defer o.Close()
+
+	cmd := exec.Command("true")
+	assert.Nil(t, cmd.Run())
+	pid := cmd.Process.Pid
+
+	var forked, renamed, exited bool
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for !exited {
+			event, err := o.ReadEvent()
+			if err != nil || event == nil {
+				return
+			}
+			assert.Equal(t, source, event.GetSource())
+			switch e := event.(type) {
+			case *ForkEvent:
+				forked = forked || e.PID == pid
+			case *CommEvent:
+				renamed = renamed || (e.PID == pid && e.Exec && e.Comm == "true")
+			case *ExitEvent:
+				exited = e.PID == pid
+			}
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the exit event")
+	}
+	assert.True(t, forked)
+	assert.True(t, renamed)
+	assert.True(t, exited)
+}
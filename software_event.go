@@ -0,0 +1,135 @@
+package obs
+
+import (
+	"runtime"
+)
+
+// SoftwareEventKind is the kind of events the kernel counts in software.
+type SoftwareEventKind int
+
+// These constants are linux ABI, defined as PERF_COUNT_SW_* in
+// <linux/perf_event.h>.
+const (
+	// CPUClock is a high resolution per-CPU timer.
+	CPUClock SoftwareEventKind = iota
+	// TaskClock is a clock count specific to the task that is running.
+	TaskClock
+	// PageFaults is the number of page faults.
+	PageFaults
+	// ContextSwitches is the number of context switches.
+	ContextSwitches
+	// CPUMigrations is the number of times a process has migrated to a new
+	// CPU.
+	CPUMigrations
+	// PageFaultsMin is the number of minor page faults, the ones that didn't
+	// require disk I/O to handle.
+	PageFaultsMin
+	// PageFaultsMaj is the number of major page faults, the ones that required
+	// disk I/O to handle.
+	PageFaultsMaj
+	// AlignmentFaults is the number of alignment faults.
+	AlignmentFaults
+	// EmulationFaults is the number of emulation faults.
+	EmulationFaults
+	// Dummy is a placeholder event that counts nothing.
+	Dummy
+	// BPFOutput is used by eBPF programs to send data to userspace with
+	// bpf_perf_event_output().
+	BPFOutput
+)
+
+var softwareEventNames = []string{
+	"cpu-clock",
+	"task-clock",
+	"page-faults",
+	"context-switches",
+	"cpu-migrations",
+	"minor-faults",
+	"major-faults",
+	"alignment-faults",
+	"emulation-faults",
+	"dummy",
+	"bpf-output",
+}
+
+// String returns the name of the event, as listed by perf list.
+func (k SoftwareEventKind) String() string {
+	if k < 0 || int(k) >= len(softwareEventNames) {
+		return "unknown"
+	}
+	return softwareEventNames[k]
+}
+
+const (
+	// defaultSampleFrequency is the number of samples per second taken when
+	// neither a sample period nor frequency is given. This is perf's default.
+	defaultSampleFrequency = 4000
+)
+
+// softwareEvent is a software event the observer listens to.
+type softwareEvent struct {
+	kind    SoftwareEventKind
+	options eventOptions
+	perf    *perfSystemEvent
+}
+
+func newSoftwareEvent(kind SoftwareEventKind, options *eventOptions) *softwareEvent {
+	return &softwareEvent{
+		kind:    kind,
+		options: *options,
+	}
+}
+
+// config returns the perf configuration of the event.
+func (e *softwareEvent) config() perfEventConfig {
+	config := perfEventConfig{
+		eventType:    perfTypeSoftware,
+		config:       uint64(e.kind),
+		sampleType:   perfSampleIP | perfSampleTID | perfSampleTime | perfSampleCPU,
+		samplePeriod: e.options.samplePeriod,
+		sampleFreq:   e.options.sampleFreq,
+		counting:     e.options.counting,
+		sideBand:     e.options.sideBand,
+		cgroup:       e.options.cgroup,
+		pid:          e.options.pid,
+		inherit:      e.options.inherit,
+		enableOnExec: e.options.enableOnExec,
+
+		nCpus:        runtime.NumCPU(),
+		nPages:       8,
+		wakeupEvents: 1,
+	}
+
+	// Counting events don't sample: a sample period would only raise
+	// overflow interrupts with no ring buffer to receive the samples.
+	// bpf-output events are emitted by eBPF programs, they are all wanted
+	// with their data.
+	if config.counting {
+		config.samplePeriod = 0
+		config.sampleFreq = false
+	} else if e.kind == BPFOutput {
+		config.sampleType |= perfSampleRaw
+		config.samplePeriod = 1
+		config.sampleFreq = false
+	} else if config.samplePeriod == 0 {
+		config.samplePeriod = defaultSampleFrequency
+		config.sampleFreq = true
+	}
+
+	return config
+}
+
+func (e *softwareEvent) open() error {
+	var err error
+
+	config := e.config()
+	e.perf, err = newPerfSystemEvent(&config)
+
+	return err
+}
+
+func (e *softwareEvent) close() {
+	if e.perf != nil {
+		e.perf.close()
+	}
+}
@@ -0,0 +1,77 @@
+package obs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSoftwareEventKindString(t *testing.T) {
+	assert.Equal(t, "context-switches", ContextSwitches.String())
+	assert.Equal(t, "bpf-output", BPFOutput.String())
+	assert.Equal(t, "unknown", SoftwareEventKind(42).String())
+}
+
+func TestSoftwareEventConfig(t *testing.T) {
+	tests := []struct {
+		kind         SoftwareEventKind
+		options      []EventOption
+		samplePeriod uint64
+		sampleFreq   bool
+	}{
+		{CPUClock, nil, defaultSampleFrequency, true},
+		{CPUClock, []EventOption{WithSamplePeriod(1000)}, 1000, false},
+		{CPUClock, []EventOption{WithSampleFrequency(99)}, 99, true},
+		{CPUClock, []EventOption{WithCounting()}, 0, false},
+		{CPUClock, []EventOption{WithSampleFrequency(99), WithCounting()}, 0, false},
+		{BPFOutput, nil, 1, false},
+	}
+
+	for _, test := range tests {
+		options := newEventOptions(test.options)
+		config := newSoftwareEvent(test.kind, options).config()
+		assert.Equal(t, test.samplePeriod, config.samplePeriod, "%s %+v", test.kind, *options)
+		assert.Equal(t, test.sampleFreq, config.sampleFreq, "%s %+v", test.kind, *options)
+	}
+}
+
+// openObserver opens o, skipping the test if perf events aren't available.
+func openObserver(t *testing.T, o *Observer) {
+	if err := o.Open(); err != nil {
+		t.Skipf("unable to open perf events: %v", err)
+	}
+}
+
+func TestSoftwareEventCounting(t *testing.T) {
+	o := NewObserver()
+	clock := o.AddSoftwareEvent(CPUClock, WithCounting())
+	sampled := o.AddSoftwareEvent(ContextSwitches)
+	openObserver(t, o)
+	defer o.Close()
+
+	// Burn some CPU.
+	x := 0
+	for i := 0; i < 10000000; i++ {
+		x += i
+	}
+
+	count, err := o.Count(clock)
+	assert.Nil(t, err)
+	assert.True(t, count > 0)
+
+	_, err = o.Count(sampled)
+	assert.NotNil(t, err)
+}
+
+func TestSoftwareEventSampling(t *testing.T) {
+	o := NewObserver()
+	source := o.AddSoftwareEvent(CPUClock, WithSampleFrequency(1000))
+	openObserver(t, o)
+	defer o.Close()
+
+	event, err := o.ReadEvent()
+	assert.Nil(t, err)
+	assert.Equal(t, source, event.GetSource())
+	sample := event.(*SoftwareEvent)
+	assert.NotZero(t, sample.GetTimestamp())
+}
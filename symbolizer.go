@@ -0,0 +1,519 @@
+package obs
+
+import (
+	"bytes"
+	"debug/elf"
+	"debug/gosym"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/ulikunitz/xz"
+)
+
+// UserSymbol is a user space address resolved by a Symbolizer.
+type UserSymbol struct {
+	// Name is the name of the function the address belongs to, "" if the
+	// function couldn't be found.
+	Name string
+	// Offset is the offset of the address in the function.
+	Offset uint64
+	// Module is the path of the file mapped at the address, or a pseudo-path
+	// such as "[vdso]".
+	Module string
+	// BuildID is the build ID of Module, in hexadecimal, "" if unknown.
+	BuildID string
+	// File and Line are the source location of the address. They are only
+	// known for Go binaries.
+	File string
+	Line int
+}
+
+// String returns a human readable representation of s, eg. "main+0x1a" or
+// "[/usr/lib/libfoo.so]" when the function isn't known.
+func (s *UserSymbol) String() string {
+	if s.Name == "" {
+		return "[" + s.Module + "]"
+	}
+	if s.Offset == 0 {
+		return s.Name
+	}
+	return fmt.Sprintf("%s+%#x", s.Name, s.Offset)
+}
+
+// elfSymbol is a function of an ELF object.
+type elfSymbol struct {
+	name  string
+	value uint64
+	size  uint64
+}
+
+// elfObject holds what is needed to symbolize addresses of an ELF file.
+type elfObject struct {
+	buildID string
+	// exec is true for non-relocatable executables: virtual addresses are
+	// the runtime addresses.
+	exec bool
+	// loads are the PT_LOAD program headers, used to translate file offsets
+	// into virtual addresses.
+	loads []elf.ProgHeader
+	// symbols is sorted by value.
+	symbols []elfSymbol
+	// goTable is the Go symbol table, for Go binaries.
+	goTable *gosym.Table
+}
+
+// processMaps caches the mappings of a process and their ELF objects.
+type processMaps struct {
+	mappings []Mapping
+	// objects has the ELF object of each mapping, once opened.
+	objects []*elfObject
+	// opened records the mappings we've tried to open.
+	opened []bool
+}
+
+// Symbolizer resolves user space addresses to functions. It reads the ELF
+// files mapped by processes, through /proc/pid/root so the files of
+// containerized processes are found. Symbols are read from the symbol tables,
+// the MiniDebugInfo .gnu_debugdata section and, for Go binaries, the pclntab.
+//
+// The symbols of a file are cached by build ID, processes running the same
+// binaries share the cached symbols. The mappings of processes are cached
+// too, call Forget once a process has exited.
+type Symbolizer struct {
+	mu sync.Mutex
+	// objects are the ELF objects, indexed by build ID.
+	objects   map[string]*elfObject
+	processes map[int]*processMaps
+}
+
+// NewSymbolizer creates a Symbolizer.
+func NewSymbolizer() *Symbolizer {
+	return &Symbolizer{
+		objects:   make(map[string]*elfObject),
+		processes: make(map[int]*processMaps),
+	}
+}
+
+// Forget drops the cached mappings of the process pid.
+func (s *Symbolizer) Forget(pid int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.processes, pid)
+}
+
+func (s *Symbolizer) readMappings(pid int) (*processMaps, error) {
+	mappings, err := NewProcess(pid).Mappings()
+	if err != nil {
+		return nil, err
+	}
+
+	pm := &processMaps{
+		mappings: mappings,
+		objects:  make([]*elfObject, len(mappings)),
+		opened:   make([]bool, len(mappings)),
+	}
+	s.processes[pid] = pm
+	return pm, nil
+}
+
+// findMapping returns the index of the mapping containing addr, -1 if there's
+// no such mapping.
+func (pm *processMaps) findMapping(addr uint64) int {
+	i := sort.Search(len(pm.mappings), func(i int) bool {
+		return pm.mappings[i].End > addr
+	})
+	if i == len(pm.mappings) || pm.mappings[i].Start > addr {
+		return -1
+	}
+	return i
+}
+
+// Symbolize resolves the address addr of the process pid.
+func (s *Symbolizer) Symbolize(pid int, addr uint64) (UserSymbol, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pm, ok := s.processes[pid]
+	if !ok {
+		var err error
+		if pm, err = s.readMappings(pid); err != nil {
+			return UserSymbol{}, errors.Wrap(err, "symbolize")
+		}
+	}
+
+	i := pm.findMapping(addr)
+	if i == -1 && ok {
+		// The process may have mapped new files since we last read its
+		// mappings.
+		var err error
+		if pm, err = s.readMappings(pid); err != nil {
+			return UserSymbol{}, errors.Wrap(err, "symbolize")
+		}
+		i = pm.findMapping(addr)
+	}
+	if i == -1 {
+		return UserSymbol{}, fmt.Errorf("symbolize: no mapping for address %#x", addr)
+	}
+
+	m := &pm.mappings[i]
+	sym := UserSymbol{Module: m.Path}
+	if !m.IsFile() {
+		return sym, nil
+	}
+
+	if !pm.opened[i] {
+		pm.opened[i] = true
+		obj, err := s.openObject(pid, m)
+		if err != nil {
+			return sym, errors.Wrap(err, "symbolize")
+		}
+		pm.objects[i] = obj
+	}
+
+	obj := pm.objects[i]
+	if obj == nil {
+		// We've failed to open the file before.
+		return sym, nil
+	}
+	sym.BuildID = obj.buildID
+	obj.lookup(obj.vaddr(m, addr), &sym)
+
+	return sym, nil
+}
+
+// openMapping opens the file mapped by m, as seen by the process pid.
+func openMapping(pid int, m *Mapping) (*os.File, error) {
+	proc := "/proc/" + strconv.Itoa(pid)
+
+	if !m.Deleted {
+		f, err := os.Open(proc + "/root" + m.Path)
+		if err == nil {
+			return f, nil
+		}
+	}
+
+	// Deleted files, or files we can't reach through the root of the
+	// process, can still be opened through map_files.
+	return os.Open(fmt.Sprintf("%s/map_files/%x-%x", proc, m.Start, m.End))
+}
+
+// openObject returns the ELF object mapped by m, from the cache when we
+// already know its build ID.
+func (s *Symbolizer) openObject(pid int, m *Mapping) (*elfObject, error) {
+	f, err := openMapping(pid, m)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	ef, err := elf.NewFile(f)
+	if err != nil {
+		return nil, err
+	}
+
+	// Objects without build ID are cached by device and inode.
+	key := fmt.Sprintf("%d:%d:%d", m.Major, m.Minor, m.Inode)
+	buildID := readBuildID(ef)
+	if buildID != "" {
+		key = buildID
+	}
+	if obj, ok := s.objects[key]; ok {
+		return obj, nil
+	}
+
+	obj := newELFObject(ef)
+	obj.buildID = buildID
+	s.objects[key] = obj
+
+	return obj, nil
+}
+
+func newELFObject(f *elf.File) *elfObject {
+	obj := &elfObject{
+		exec: f.Type == elf.ET_EXEC,
+	}
+
+	for _, prog := range f.Progs {
+		if prog.Type == elf.PT_LOAD {
+			obj.loads = append(obj.loads, prog.ProgHeader)
+		}
+	}
+
+	obj.addSymbols(f.Symbols())
+	obj.addSymbols(f.DynamicSymbols())
+	if debugData, err := readDebugData(f); err == nil {
+		obj.addSymbols(debugData.Symbols())
+	}
+	obj.goTable = readGoTable(f)
+
+	obj.index()
+	return obj
+}
+
+// addSymbols adds the functions of symbols. An error, eg. because the ELF
+// file doesn't have a symbol table, results in no symbol being added.
+func (o *elfObject) addSymbols(symbols []elf.Symbol, err error) {
+	if err != nil {
+		return
+	}
+
+	for i := range symbols {
+		sym := &symbols[i]
+		typ := elf.ST_TYPE(sym.Info)
+		if (typ != elf.STT_FUNC && typ != elf.STT_GNU_IFUNC) ||
+			sym.Section == elf.SHN_UNDEF || sym.Value == 0 {
+			continue
+		}
+		o.symbols = append(o.symbols, elfSymbol{
+			name:  sym.Name,
+			value: sym.Value,
+			size:  sym.Size,
+		})
+	}
+}
+
+// index sorts the symbols by address, removing the duplicates found in both
+// the static and dynamic symbol tables as well as the aliases.
+func (o *elfObject) index() {
+	sort.Slice(o.symbols, func(i, j int) bool {
+		a, b := &o.symbols[i], &o.symbols[j]
+		if a.value != b.value {
+			return a.value < b.value
+		}
+		// Aliases: prefer the public name, eg. malloc over __libc_malloc.
+		if leadingUnderscores(a.name) != leadingUnderscores(b.name) {
+			return leadingUnderscores(a.name) < leadingUnderscores(b.name)
+		}
+		return a.name < b.name
+	})
+
+	symbols := o.symbols[:0]
+	for _, sym := range o.symbols {
+		last := len(symbols) - 1
+		if last >= 0 && symbols[last].value == sym.value {
+			continue
+		}
+		symbols = append(symbols, sym)
+	}
+	o.symbols = symbols
+}
+
+// vaddr translates the runtime address addr, mapped by m, into a virtual
+// address of the ELF object.
+func (o *elfObject) vaddr(m *Mapping, addr uint64) uint64 {
+	if o.exec {
+		return addr
+	}
+
+	offset := addr - m.Start + m.Offset
+	for i := range o.loads {
+		load := &o.loads[i]
+		if offset >= load.Off && offset < load.Off+load.Filesz {
+			return offset - load.Off + load.Vaddr
+		}
+	}
+	return offset
+}
+
+// lookup fills the function details of the virtual address vaddr in sym.
+func (o *elfObject) lookup(vaddr uint64, sym *UserSymbol) {
+	if o.goTable != nil {
+		if fn := o.goTable.PCToFunc(vaddr); fn != nil {
+			sym.Name = fn.Name
+			sym.Offset = vaddr - fn.Entry
+			sym.File, sym.Line, _ = o.goTable.PCToLine(vaddr)
+			return
+		}
+	}
+
+	i := sort.Search(len(o.symbols), func(i int) bool {
+		return o.symbols[i].value > vaddr
+	})
+	if i == 0 {
+		return
+	}
+	s := &o.symbols[i-1]
+	if s.size != 0 && vaddr >= s.value+s.size {
+		return
+	}
+	sym.Name = s.name
+	sym.Offset = vaddr - s.value
+}
+
+// readBuildID returns the GNU build ID of f, in hexadecimal, "" if f doesn't
+// have one.
+func readBuildID(f *elf.File) string {
+	for _, section := range f.Sections {
+		if section.Type != elf.SHT_NOTE {
+			continue
+		}
+		data, err := section.Data()
+		if err != nil {
+			continue
+		}
+		if id := findBuildIDNote(data, f.ByteOrder); id != nil {
+			return hex.EncodeToString(id)
+		}
+	}
+	return ""
+}
+
+// ntGNUBuildID is the type of the build ID note.
+const ntGNUBuildID = 3
+
+// findBuildIDNote walks the ELF notes in data looking for the GNU build ID.
+func findBuildIDNote(data []byte, order binary.ByteOrder) []byte {
+	align4 := func(n uint32) uint32 { return (n + 3) &^ 3 }
+
+	for len(data) >= 12 {
+		nameSize := order.Uint32(data[0:4])
+		descSize := order.Uint32(data[4:8])
+		typ := order.Uint32(data[8:12])
+		data = data[12:]
+
+		if uint64(align4(nameSize))+uint64(align4(descSize)) > uint64(len(data)) {
+			return nil
+		}
+		name := data[:nameSize]
+		desc := data[align4(nameSize) : align4(nameSize)+descSize]
+		data = data[align4(nameSize)+align4(descSize):]
+
+		if typ == ntGNUBuildID && string(name) == "GNU\x00" {
+			return desc
+		}
+	}
+	return nil
+}
+
+// readDebugData returns the ELF file embedded, xz-compressed, in the
+// .gnu_debugdata section of f. Distributions use this MiniDebugInfo section to
+// ship the symbols of otherwise stripped binaries.
+func readDebugData(f *elf.File) (*elf.File, error) {
+	section := f.Section(".gnu_debugdata")
+	if section == nil {
+		return nil, errors.New("no .gnu_debugdata section")
+	}
+	data, err := section.Data()
+	if err != nil {
+		return nil, err
+	}
+	r, err := xz.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	uncompressed, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return elf.NewFile(bytes.NewReader(uncompressed))
+}
+
+// readGoTable returns the Go symbol table of f, nil if f isn't a Go binary.
+// The pclntab is kept in stripped Go binaries.
+func readGoTable(f *elf.File) *gosym.Table {
+	pclntab := f.Section(".gopclntab")
+	text := f.Section(".text")
+	if pclntab == nil || text == nil {
+		return nil
+	}
+	pcln, err := pclntab.Data()
+	if err != nil {
+		return nil
+	}
+
+	// Go 1.2 and older binaries have their symbols in .gosymtab.
+	var symtab []byte
+	if section := f.Section(".gosymtab"); section != nil {
+		symtab, _ = section.Data()
+	}
+
+	table, err := gosym.NewTable(symtab, gosym.NewLineTable(pcln, goTextStart(f, pcln, text)))
+	if err != nil {
+		return nil
+	}
+	return table
+}
+
+// goTextStart returns the address of the Go text, which isn't the start of the
+// .text section when the binary has been linked with an external linker.
+func goTextStart(f *elf.File, pcln []byte, text *elf.Section) uint64 {
+	if symbols, err := f.Symbols(); err == nil {
+		for i := range symbols {
+			if symbols[i].Name == "runtime.text" && symbols[i].Value != 0 {
+				return symbols[i].Value
+			}
+		}
+	}
+
+	// Stripped binaries: since Go 1.18, the pclntab header has a text start
+	// field, after the magic, 4 bytes of flags and two counts. It is left to
+	// 0 by recent linkers.
+	if len(pcln) < 8 {
+		return text.Addr
+	}
+	magic := f.ByteOrder.Uint32(pcln)
+	ptrSize := int(pcln[7])
+	if (magic != 0xfffffff0 && magic != 0xfffffff1) || (ptrSize != 4 && ptrSize != 8) {
+		return text.Addr
+	}
+	word := func(b []byte) uint64 {
+		if ptrSize == 4 {
+			return uint64(f.ByteOrder.Uint32(b))
+		}
+		return f.ByteOrder.Uint64(b)
+	}
+	if offset := 8 + 2*ptrSize; len(pcln) >= offset+ptrSize {
+		if start := word(pcln[offset:]); start != 0 {
+			return start
+		}
+	}
+
+	// Last resort, find the runtime moduledata, which starts with a pointer
+	// to the pclntab, and read its text field. Recent toolchains put the
+	// moduledata in its own section.
+	if start := findModuleDataText(f, word, ptrSize, text); start != 0 {
+		return start
+	}
+	return text.Addr
+}
+
+// moduleDataTextWord is the index of the text field in the runtime moduledata
+// struct, in pointer-sized words: it follows the pclntab header pointer, 6
+// slices, findfunctab, minpc and maxpc.
+const moduleDataTextWord = 1 + 6*3 + 3
+
+func findModuleDataText(f *elf.File, word func([]byte) uint64, ptrSize int, text *elf.Section) uint64 {
+	pclntab := f.Section(".gopclntab")
+
+	for _, name := range []string{".go.module", ".noptrdata", ".data"} {
+		section := f.Section(name)
+		if section == nil || section.Type == elf.SHT_NOBITS {
+			continue
+		}
+		data, err := section.Data()
+		if err != nil {
+			continue
+		}
+		end := len(data) - (moduleDataTextWord+1)*ptrSize
+		for i := 0; i <= end; i += ptrSize {
+			if word(data[i:]) != pclntab.Addr {
+				continue
+			}
+			start := word(data[i+moduleDataTextWord*ptrSize:])
+			if start >= text.Addr && start < text.Addr+text.Size {
+				return start
+			}
+		}
+	}
+
+	return 0
+}
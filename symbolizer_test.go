@@ -0,0 +1,136 @@
+package obs
+
+import (
+	"debug/elf"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFindBuildIDNote(t *testing.T) {
+	var note sampleBuilder
+	// A note that isn't the build ID, followed by the build ID.
+	note.u32(4).u32(4).u32(1).bytes([]byte("GNU\x00")).u32(42)
+	note.u32(4).u32(3).u32(ntGNUBuildID).bytes([]byte("GNU\x00")).bytes([]byte{0xde, 0xad, 0xbe, 0})
+
+	assert.Equal(t, []byte{0xde, 0xad, 0xbe}, findBuildIDNote(note, NativeEndian))
+	assert.Nil(t, findBuildIDNote(note[:20], NativeEndian))
+	assert.Nil(t, findBuildIDNote(nil, NativeEndian))
+}
+
+// TestMiniDebugInfo resolves symbols of a stripped binary which symbols are
+// only found in the .gnu_debugdata section. See testdata/elf/gen.sh.
+func TestMiniDebugInfo(t *testing.T) {
+	f, err := elf.Open("testdata/elf/minidebuginfo")
+	assert.Nil(t, err)
+	defer f.Close()
+
+	_, err = f.Symbols()
+	assert.NotNil(t, err, "the binary should be stripped")
+
+	obj := newELFObject(f)
+	assert.Equal(t, "77db3da89dcf72ee7381872a8d2f0ccfaabe6b80", readBuildID(f))
+
+	tests := []struct {
+		vaddr    uint64
+		expected string
+	}{
+		{0x1139, "add"},
+		{0x1140, "add+0x7"},
+		{0x114d, "compute"},
+		{0x118b, "main"},
+		{0x10, "[]"},
+	}
+
+	for _, test := range tests {
+		var sym UserSymbol
+		obj.lookup(test.vaddr, &sym)
+		assert.Equal(t, test.expected, sym.String())
+	}
+}
+
+func TestELFObjectVaddr(t *testing.T) {
+	obj := elfObject{
+		loads: []elf.ProgHeader{
+			{Type: elf.PT_LOAD, Off: 0, Vaddr: 0, Filesz: 0x1000},
+			{Type: elf.PT_LOAD, Off: 0x1000, Vaddr: 0x201000, Filesz: 0x2000},
+		},
+	}
+	m := Mapping{Start: 0x7f0000001000, End: 0x7f0000003000, Offset: 0x1000}
+	assert.Equal(t, uint64(0x201010), obj.vaddr(&m, 0x7f0000001010))
+
+	obj.exec = true
+	assert.Equal(t, uint64(0x7f0000001010), obj.vaddr(&m, 0x7f0000001010))
+}
+
+func TestSymbolizerGo(t *testing.T) {
+	s := NewSymbolizer()
+	pc := uint64(reflect.ValueOf(TestSymbolizerGo).Pointer())
+
+	sym, err := s.Symbolize(os.Getpid(), pc)
+	assert.Nil(t, err)
+	assert.Equal(t, "github.com/dlespiau/obs.TestSymbolizerGo", sym.Name)
+	assert.Equal(t, uint64(0), sym.Offset)
+	assert.Equal(t, "symbolizer_test.go", filepath.Base(sym.File))
+	assert.NotZero(t, sym.Line)
+
+	exe, err := os.Executable()
+	assert.Nil(t, err)
+	assert.Equal(t, exe, sym.Module)
+
+	// The second lookup hits the cache.
+	sym2, err := s.Symbolize(os.Getpid(), pc+1)
+	assert.Nil(t, err)
+	assert.Equal(t, sym.Name, sym2.Name)
+	assert.Equal(t, uint64(1), sym2.Offset)
+
+	s.Forget(os.Getpid())
+	_, err = s.Symbolize(os.Getpid(), 0)
+	assert.NotNil(t, err)
+}
+
+// TestSymbolizerC resolves a libc function, libc being mapped in the test
+// binary through cgo.
+func TestSymbolizerC(t *testing.T) {
+	mappings, err := NewProcess(os.Getpid()).Mappings()
+	assert.Nil(t, err)
+
+	var libc *Mapping
+	for i := range mappings {
+		m := &mappings[i]
+		if filepath.Base(m.Path) == "libc.so.6" && m.Executable() {
+			libc = m
+		}
+	}
+	if libc == nil {
+		t.Skip("libc isn't mapped")
+	}
+
+	f, err := elf.Open(libc.Path)
+	assert.Nil(t, err)
+	defer f.Close()
+	symbols, err := f.DynamicSymbols()
+	assert.Nil(t, err)
+
+	var malloc uint64
+	for _, sym := range symbols {
+		if sym.Name == "malloc" && sym.Section != elf.SHN_UNDEF {
+			malloc = sym.Value
+		}
+	}
+	var addr uint64
+	for _, prog := range f.Progs {
+		if prog.Type == elf.PT_LOAD && malloc >= prog.Vaddr && malloc < prog.Vaddr+prog.Filesz {
+			addr = malloc - prog.Vaddr + prog.Off - libc.Offset + libc.Start
+		}
+	}
+
+	s := NewSymbolizer()
+	sym, err := s.Symbolize(os.Getpid(), addr+4)
+	assert.Nil(t, err)
+	assert.Equal(t, "malloc+0x4", sym.String())
+	assert.NotEqual(t, "", sym.BuildID)
+}
@@ -0,0 +1,23 @@
+#!/bin/sh
+#
+# Generates minidebuginfo, a stripped binary which symbols are only found in
+# its .gnu_debugdata section, as described in:
+#
+#   https://sourceware.org/gdb/onlinedocs/gdb/MiniDebugInfo.html
+
+set -e
+
+cd "$(dirname "$0")"
+tmp=$(mktemp -d)
+trap 'rm -rf "$tmp"' EXIT
+
+gcc -O0 -Wl,--build-id -o "$tmp/minidebuginfo" minidebuginfo.c
+nm "$tmp/minidebuginfo" --format=posix --defined-only |
+	awk '{ if ($2 == "T" || $2 == "t") print $1 }' | sort > "$tmp/funcsyms"
+objcopy --only-keep-debug "$tmp/minidebuginfo" "$tmp/debug"
+objcopy -S --remove-section .gdb_index --remove-section .comment \
+	--keep-symbols="$tmp/funcsyms" "$tmp/debug" "$tmp/mini_debuginfo"
+strip --strip-all -R .comment "$tmp/minidebuginfo"
+xz "$tmp/mini_debuginfo"
+objcopy --add-section .gnu_debugdata="$tmp/mini_debuginfo.xz" "$tmp/minidebuginfo"
+cp "$tmp/minidebuginfo" minidebuginfo
@@ -0,0 +1,22 @@
+#include <stdio.h>
+
+static int add(int a, int b)
+{
+	return a + b;
+}
+
+int compute(int n)
+{
+	int i, total = 0;
+
+	for (i = 0; i < n; i++)
+		total = add(total, i);
+
+	return total;
+}
+
+int main(void)
+{
+	printf("%d\n", compute(10));
+	return 0;
+}
@@ -1,7 +1,9 @@
 package obs
 
 import (
+	"fmt"
 	"io/ioutil"
+	"os"
 	"runtime"
 	"strconv"
 	"strings"
@@ -23,6 +25,9 @@ type tracepoint struct {
 	// decode the raw data incoming from perf events.
 	format format
 
+	// options given when adding the tracepoint to the observer.
+	options eventOptions
+
 	// underlying perf events
 	perf *perfSystemEvent
 }
@@ -30,16 +35,30 @@ type tracepoint struct {
 // newTracepoint creates a Tracepoint. Name is the tracepoint name as listed by:
 //
 //   $ sudo perf list tracepoint
-func newTracepoint(name string) *tracepoint {
+func newTracepoint(name string, options *eventOptions) *tracepoint {
 	return &tracepoint{
-		Name: name,
+		Name:    name,
+		options: *options,
 	}
 }
 
+// tracepointPath returns the tracefs directory of the tracepoint name.
+func tracepointPath(name string) string {
+	return tracingRoot + "/events/" + strings.Replace(name, ":", "/", 1)
+}
+
+// TracepointExists returns true if the tracepoint name is available in the
+// running kernel. Some tracepoints depend on the kernel configuration, eg.
+// the syscalls tracepoints need CONFIG_FTRACE_SYSCALLS.
+func TracepointExists(name string) bool {
+	_, err := os.Stat(tracepointPath(name) + "/id")
+	return err == nil
+}
+
 func (tp *tracepoint) open() error {
 	var err error
 
-	tpPath := tracingRoot + "/events/" + strings.Replace(tp.Name, ":", "/", 1)
+	tpPath := tracepointPath(tp.Name)
 
 	// Start by retrieving the event id.
 	idBytes, err := ioutil.ReadFile(tpPath + "/id")
@@ -57,11 +76,18 @@ func (tp *tracepoint) open() error {
 		return err
 	}
 
+	// Make sure the raw data is laid out the way the user expects it.
+	if tp.options.layout != nil {
+		if err := tp.format.checkLayout(tp.options.layout); err != nil {
+			return fmt.Errorf("tracepoint %s: %v", tp.Name, err)
+		}
+	}
+
 	// Finally, configure perf to receive events.
 	config := perfEventConfig{
 		eventType:  perfTypeTracePoint,
-		sampleType: perfSampleRaw,
-		config:     id,
+		sampleType: perfSampleTime | perfSampleRaw | perfSampleTID,
+		config:     uint64(id),
 
 		// TODO(damien): Use online CPUs. System event should fill that for us.
 		nCpus: runtime.NumCPU(),
@@ -69,6 +95,21 @@ func (tp *tracepoint) open() error {
 		// get sensible defaults.
 		nPages:       8,
 		wakeupEvents: 1,
+
+		sideBand: tp.options.sideBand,
+		cgroup:   tp.options.cgroup,
+
+		pid:          tp.options.pid,
+		inherit:      tp.options.inherit,
+		enableOnExec: tp.options.enableOnExec,
+	}
+	// Side-band records identify the task and CPU they were emitted for
+	// with the sample ID fields.
+	if config.sideBand != 0 {
+		config.sampleType |= perfSampleCPU
+	}
+	if tp.options.callchain {
+		config.sampleType |= perfSampleCallchain
 	}
 	tp.perf, err = newPerfSystemEvent(&config)
 